@@ -0,0 +1,194 @@
+package analyzer
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/brimdata/brimcap/pcap"
+	"github.com/brimdata/zed"
+	"github.com/brimdata/zed/pkg/field"
+	"github.com/brimdata/zed/zcode"
+	"github.com/brimdata/zed/zio"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// CommunityIDConfig configures the enrichment of an analyzer's records with
+// the Community ID of their flow.  Each field is a dotted path into the
+// record and defaults to that of Zeek conn records.
+type CommunityIDConfig struct {
+	// Field is the name of the top-level field added to records that
+	// lack it.
+	Field   string `yaml:"field,omitempty"`
+	SrcAddr string `yaml:"src_addr,omitempty"`
+	SrcPort string `yaml:"src_port,omitempty"`
+	DstAddr string `yaml:"dst_addr,omitempty"`
+	DstPort string `yaml:"dst_port,omitempty"`
+	// Proto is the name of a field holding either the name of the
+	// transport protocol (e.g., "tcp") or its IP protocol number.
+	Proto string `yaml:"proto,omitempty"`
+	Seed  uint16 `yaml:"seed,omitempty"`
+}
+
+func (c CommunityIDConfig) withDefaults() CommunityIDConfig {
+	setDefault(&c.Field, "community_id")
+	setDefault(&c.SrcAddr, "id.orig_h")
+	setDefault(&c.SrcPort, "id.orig_p")
+	setDefault(&c.DstAddr, "id.resp_h")
+	setDefault(&c.DstPort, "id.resp_p")
+	setDefault(&c.Proto, "proto")
+	return c
+}
+
+func setDefault(s *string, def string) {
+	if *s == "" {
+		*s = def
+	}
+}
+
+func (c CommunityIDConfig) validate() error {
+	if strings.Contains(c.Field, ".") {
+		return fmt.Errorf("community_id field must be a top-level field: %q", c.Field)
+	}
+	return nil
+}
+
+// communityIDReader adds a Community ID field to the records read from reader
+// that lack one.  Records without the flow fields are passed through as is.
+type communityIDReader struct {
+	zctx    *zed.Context
+	reader  zio.Reader
+	field   string
+	srcAddr field.Path
+	srcPort field.Path
+	dstAddr field.Path
+	dstPort field.Path
+	proto   field.Path
+	seed    uint16
+	types   map[*zed.TypeRecord]*zed.TypeRecord
+}
+
+func newCommunityIDReader(zctx *zed.Context, reader zio.Reader, conf CommunityIDConfig) *communityIDReader {
+	conf = conf.withDefaults()
+	return &communityIDReader{
+		zctx:    zctx,
+		reader:  reader,
+		field:   conf.Field,
+		srcAddr: field.Dotted(conf.SrcAddr),
+		srcPort: field.Dotted(conf.SrcPort),
+		dstAddr: field.Dotted(conf.DstAddr),
+		dstPort: field.Dotted(conf.DstPort),
+		proto:   field.Dotted(conf.Proto),
+		seed:    conf.Seed,
+		types:   make(map[*zed.TypeRecord]*zed.TypeRecord),
+	}
+}
+
+func (c *communityIDReader) Read() (*zed.Value, error) {
+	val, err := c.reader.Read()
+	if val == nil || err != nil {
+		return val, err
+	}
+	typ := zed.TypeRecordOf(val.Type())
+	if typ == nil || typ.HasField(c.field) {
+		return val, nil
+	}
+	id, ok := c.communityID(val)
+	if !ok {
+		return val, nil
+	}
+	outType, err := c.lookupType(typ)
+	if err != nil {
+		return nil, err
+	}
+	bytes := append(zcode.Bytes(nil), val.Bytes()...)
+	bytes = zcode.Append(bytes, zed.EncodeString(id.String()))
+	out := zed.NewValue(outType, bytes)
+	return &out, nil
+}
+
+// lookupType returns typ with the Community ID field appended.  Like Zed's
+// put operator, this drops any name of the input type.
+func (c *communityIDReader) lookupType(typ *zed.TypeRecord) (*zed.TypeRecord, error) {
+	if out, ok := c.types[typ]; ok {
+		return out, nil
+	}
+	fields := append([]zed.Field{}, typ.Fields...)
+	fields = append(fields, zed.Field{Name: c.field, Type: zed.TypeString})
+	out, err := c.zctx.LookupTypeRecord(fields)
+	if err != nil {
+		return nil, err
+	}
+	c.types[typ] = out
+	return out, nil
+}
+
+func (c *communityIDReader) communityID(val *zed.Value) (pcap.CommunityID, bool) {
+	src := derefIP(val.DerefPath(c.srcAddr))
+	dst := derefIP(val.DerefPath(c.dstAddr))
+	if src == nil || dst == nil {
+		return pcap.CommunityID{}, false
+	}
+	proto, ok := derefProto(val.DerefPath(c.proto), src.To4() == nil)
+	if !ok {
+		return pcap.CommunityID{}, false
+	}
+	var sport, dport uint16
+	switch proto {
+	case layers.IPProtocolTCP, layers.IPProtocolUDP, layers.IPProtocolSCTP, layers.IPProtocolICMPv4, layers.IPProtocolICMPv6:
+		sportVal, dportVal := val.DerefPath(c.srcPort), val.DerefPath(c.dstPort)
+		if sportVal == nil || dportVal == nil {
+			return pcap.CommunityID{}, false
+		}
+		sport, dport = uint16(sportVal.AsInt()), uint16(dportVal.AsInt())
+		if proto == layers.IPProtocolICMPv4 || proto == layers.IPProtocolICMPv6 {
+			// The ports of ICMP flows, as in Zeek, are the message
+			// type and code.
+			sport, dport, _ = pcap.ICMPPorts(uint8(sport), uint8(dport), proto == layers.IPProtocolICMPv6)
+		}
+	}
+	return pcap.ComputeCommunityID(c.seed, src, dst, proto, sport, dport), true
+}
+
+func derefIP(val *zed.Value) net.IP {
+	if val == nil {
+		return nil
+	}
+	switch zed.TypeUnder(val.Type()) {
+	case zed.TypeIP:
+		return net.IP(zed.DecodeIP(val.Bytes()).AsSlice())
+	case zed.TypeString:
+		return net.ParseIP(val.AsString())
+	}
+	return nil
+}
+
+// derefProto returns the IP protocol of a protocol name or number.  Since Zeek
+// names both ICMP and ICMPv6 "icmp", ipv6 tells which is meant.
+func derefProto(val *zed.Value, ipv6 bool) (layers.IPProtocol, bool) {
+	if val == nil {
+		return 0, false
+	}
+	if zed.TypeUnder(val.Type()) != zed.TypeString {
+		if !zed.IsInteger(zed.TypeUnder(val.Type()).ID()) {
+			return 0, false
+		}
+		return layers.IPProtocol(val.AsInt()), true
+	}
+	switch strings.ToLower(val.AsString()) {
+	case "tcp":
+		return layers.IPProtocolTCP, true
+	case "udp":
+		return layers.IPProtocolUDP, true
+	case "sctp":
+		return layers.IPProtocolSCTP, true
+	case "icmp":
+		if ipv6 {
+			return layers.IPProtocolICMPv6, true
+		}
+		return layers.IPProtocolICMPv4, true
+	case "icmp6", "icmpv6", "ipv6-icmp":
+		return layers.IPProtocolICMPv6, true
+	}
+	return 0, false
+}
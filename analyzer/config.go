@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strconv"
 
+	"github.com/brimdata/brimcap/pcap/bpf"
 	"github.com/brimdata/zed/zio/anyio"
 	"go.uber.org/multierr"
 )
@@ -14,9 +15,15 @@ import (
 type Config struct {
 	Args []string `yaml:"args,omitempty"`
 	// Cmd is the command to run for this analyzer (required).
-	Cmd      string   `yaml:"cmd"`
-	Disabled bool     `yaml:"disabled,omitempty"`
-	Globs    []string `yaml:"globs,omitempty"`
+	Cmd string `yaml:"cmd"`
+	// CommunityID if set adds the Community ID of their flow to the
+	// analyzer's records that lack one.
+	CommunityID *CommunityIDConfig `yaml:"community_id,omitempty"`
+	Disabled    bool               `yaml:"disabled,omitempty"`
+	// Filter if set is a packet filter expression (see package bpf)
+	// selecting the packets sent to this analyzer.
+	Filter string   `yaml:"filter,omitempty"`
+	Globs  []string `yaml:"globs,omitempty"`
 	// Name is a unique selector for this analyzer (required).
 	Name       string           `yaml:"name"`
 	ReaderOpts anyio.ReaderOpts `yaml:"-"`
@@ -34,6 +41,7 @@ func (c *Config) SetFlags(fs *flag.FlagSet) {
 	pre := fmt.Sprintf("analyzers.%s.", c.Name)
 	fs.StringVar(&c.Cmd, pre+"cmd", c.Cmd, "command to run")
 	fs.BoolVar(&c.Disabled, pre+"disabled", c.Disabled, "disable analyzer")
+	fs.StringVar(&c.Filter, pre+"filter", c.Filter, "packet filter expression")
 	fs.StringVar(&c.StdoutPath, pre+"stdout", c.StdoutPath, "write stdout to path")
 	fs.StringVar(&c.StderrPath, pre+"stderr", c.StderrPath, "write stderr to path")
 	fs.StringVar(&c.WorkDir, pre+"workdir", c.WorkDir, "working directory")
@@ -46,6 +54,14 @@ func (c Config) Validate() error {
 	if c.Cmd == "" {
 		return fmt.Errorf("%s: cmd value must be set", c.getName())
 	}
+	if _, err := bpf.Compile(c.Filter); err != nil {
+		return fmt.Errorf("%s: %w", c.getName(), err)
+	}
+	if c.CommunityID != nil {
+		if err := c.CommunityID.validate(); err != nil {
+			return fmt.Errorf("%s: %w", c.getName(), err)
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,49 @@
+package analyzer
+
+import (
+	"context"
+	"io"
+
+	"github.com/brimdata/brimcap/pcap"
+	"github.com/brimdata/brimcap/pcap/pcapio"
+)
+
+// filterWriter is an io.WriteCloser that writes the pcap written to it to
+// an analyzer process, dropping the packets that do not match the analyzer's
+// filter.
+type filterWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newFilterWriter(ctx context.Context, w io.WriteCloser, filter pcap.PacketFilter) *filterWriter {
+	pr, pw := io.Pipe()
+	f := &filterWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := filterPcap(ctx, w, pr, filter)
+		w.Close()
+		// Fail subsequent writes if the filter stopped early.
+		pr.CloseWithError(err)
+		f.done <- err
+	}()
+	return f
+}
+
+func filterPcap(ctx context.Context, w io.Writer, r io.Reader, filter pcap.PacketFilter) error {
+	reader, err := pcapio.NewReader(r)
+	if err != nil {
+		return err
+	}
+	return pcap.Filter(ctx, w, reader, filter)
+}
+
+func (f *filterWriter) Write(b []byte) (int, error) {
+	return f.pw.Write(b)
+}
+
+// Close waits for the filtered pcap to be written out and closes the
+// underlying writer.
+func (f *filterWriter) Close() error {
+	f.pw.Close()
+	return <-f.done
+}
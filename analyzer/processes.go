@@ -14,6 +14,7 @@ import (
 	"strings"
 	"sync/atomic"
 
+	"github.com/brimdata/brimcap/pcap/bpf"
 	"github.com/brimdata/zed/zio"
 	"golang.org/x/sync/errgroup"
 )
@@ -35,6 +36,14 @@ func runProcesses(ctx context.Context, r io.Reader, confs ...Config) (*operation
 			return nil, err
 		}
 		group.Go(cmd.Run)
+		if conf.Filter != "" {
+			filter, err := bpf.Compile(conf.Filter)
+			if err != nil {
+				return nil, err
+			}
+			writers = append(writers, newFilterWriter(ctx, cmd, filter))
+			continue
+		}
 		writers = append(writers, cmd)
 	}
 	writeCounter := new(writeCounter)
@@ -74,6 +74,9 @@ func tailOne(ctx context.Context, zctx *zed.Context, conf Config, warner ztail.W
 		}
 		wrapped.reader = runtime.AsReader(query)
 	}
+	if conf.CommunityID != nil {
+		wrapped.reader = newCommunityIDReader(zctx, wrapped.reader, *conf.CommunityID)
+	}
 	return wrapped, tailer, nil
 }
 
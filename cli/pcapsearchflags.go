@@ -3,11 +3,15 @@ package cli
 import (
 	"flag"
 	"fmt"
+	"math"
 	"net"
 	"strconv"
 	"time"
 
 	"github.com/brimdata/brimcap"
+	"github.com/brimdata/brimcap/pcap"
+	"github.com/brimdata/brimcap/pcap/bpf"
+	"github.com/brimdata/brimcap/pcap/pcapio"
 	"github.com/brimdata/zed"
 	"github.com/brimdata/zed/pkg/nano"
 	"github.com/brimdata/zed/zson"
@@ -16,6 +20,16 @@ import (
 
 type PcapSearchFlags struct {
 	Search brimcap.Search
+	// Flows, if set, is the file of records of the connections to search
+	// for (see brimcap.ReadSearches) or "-" for standard input.  Record,
+	// if set, is the record of a single connection.  In either case,
+	// Search holds the options common to all connections and Fields names
+	// the field mapping of the records (see brimcap.Config.FieldMapping).
+	// UID, if set, selects the records of Flows with that UID.
+	Flows  string
+	Record string
+	Fields string
+	UID    string
 
 	ts       *tsArg
 	duration time.Duration
@@ -24,6 +38,17 @@ type PcapSearchFlags struct {
 	srcport  portArg
 	dstip    ipArg
 	dstport  portArg
+	dir      pcapio.NgDirection
+	filter   string
+	tunnel   pcap.TunnelLayer
+	defrag   bool
+	dedup    time.Duration
+	cid      string
+	cidSeed  uint
+	vtags    []uint32
+	icmpType *uint8
+	icmpCode *uint8
+	icmpID   *uint16
 }
 
 func (f *PcapSearchFlags) SetFlags(fs *flag.FlagSet) {
@@ -44,51 +69,169 @@ func (f *PcapSearchFlags) SetFlags(fs *flag.FlagSet) {
 		f.duration = time.Duration(zed.DecodeDuration(val.Bytes()))
 		return nil
 	})
-	fs.StringVar(&f.proto, "proto", "", "protocol of the connection (either tcp, udp or icmp)")
+	fs.StringVar(&f.proto, "proto", "", "protocol of the connection (either tcp, udp, sctp, or icmp)")
 	fs.Var(&f.srcip, "src.ip", "ip address of the connection source")
 	fs.Var(&f.srcport, "src.port", "port of the connection source")
 	fs.Var(&f.dstip, "dst.ip", "ip address of the connection destination")
 	fs.Var(&f.dstport, "dst.port", "port of the connection destination")
+	f.SetOptionFlags(fs)
+	fs.StringVar(&f.Flows, "flows", "", "file of records of the connections to search for or stdin if -")
+	fs.StringVar(&f.Record, "record", "", "ZSON or JSON record of the connection (e.g., a Zeek conn record)")
+	fs.StringVar(&f.Fields, "fields", "zeek", "field mapping of -flows and -record records (zeek, suricata, nfdump, or from config)")
+	fs.StringVar(&f.UID, "uid", "", "only search for the -flows records with this uid (e.g., a Zeek uid)")
+}
+
+// SetOptionFlags sets the flags of the options that restrict the packets of
+// a connection matched (e.g., -direction and -filter), which SetFlags sets
+// along with the flags identifying the connection.  Commands that identify
+// connections otherwise (e.g., brimcap slice) use just these.
+func (f *PcapSearchFlags) SetOptionFlags(fs *flag.FlagSet) {
+	fs.Func("direction", "only match pcap-ng packets captured in this direction (inbound or outbound)", func(s string) (err error) {
+		f.dir, err = pcapio.ParseDirection(s)
+		return err
+	})
+	fs.Func("tunnel", "IP header of tunneled packets to match the connection on (either, outer, or inner)", func(s string) (err error) {
+		f.tunnel, err = pcap.ParseTunnelLayer(s)
+		return err
+	})
+	fs.Func("sctp.vtag", "verification tag of the SCTP association (may be repeated)", func(s string) error {
+		val, err := strconv.ParseUint(s, 0, 32)
+		f.vtags = append(f.vtags, uint32(val))
+		return err
+	})
+	fs.Func("icmp.type", "ICMP type of the connection (id.orig_p of Zeek conn records)", func(s string) error {
+		val, err := strconv.ParseUint(s, 10, 8)
+		f.icmpType = ptr(uint8(val))
+		return err
+	})
+	fs.Func("icmp.code", "ICMP code of the connection (id.resp_p of Zeek conn records)", func(s string) error {
+		val, err := strconv.ParseUint(s, 10, 8)
+		f.icmpCode = ptr(uint8(val))
+		return err
+	})
+	fs.Func("icmp.id", "identifier of the ICMP echo messages of the connection", func(s string) error {
+		val, err := strconv.ParseUint(s, 10, 16)
+		f.icmpID = ptr(uint16(val))
+		return err
+	})
+	fs.BoolVar(&f.defrag, "defrag", false, "match all fragments of matching IP datagrams")
+	fs.DurationVar(&f.dedup, "dedup", 0, "drop packets duplicating a packet found within this window (e.g., 1s)")
+	fs.StringVar(&f.cid, "community_id", "", "Community ID of the connection (replaces its protocol and addresses)")
+	fs.UintVar(&f.cidSeed, "community_id.seed", 0, "seed of the Community ID")
+	fs.StringVar(&f.filter, "filter", "", "only match packets matching this packet filter expression")
 }
 
 func (f *PcapSearchFlags) Init() error {
 	var merr error
-	if f.ts == nil {
+	records := f.Flows != "" || f.Record != ""
+	if f.Flows != "" && f.Record != "" {
+		merr = multierr.Append(merr, fmt.Errorf("%q cannot be used with %q", "-flows", "-record"))
+	}
+	if f.UID != "" && f.Flows == "" {
+		merr = multierr.Append(merr, fmt.Errorf("%q requires %q", "-uid", "-flows"))
+	}
+	if records {
+		if f.ts.isSet() || f.srcip != nil || f.dstip != nil || f.proto != "" || f.cid != "" {
+			merr = multierr.Append(merr, fmt.Errorf("%q and %q cannot be used with %q, %q, %q, %q, or %q", "-flows", "-record", "-ts", "-proto", "-src.ip", "-dst.ip", "-community_id"))
+		}
+	} else if f.ts == nil {
 		merr = multierr.Append(merr, errFlagRequired("-start"))
 	}
-	if f.srcip == nil {
-		merr = multierr.Append(merr, errFlagRequired("-src.ip"))
+	if f.cid == "" && !records {
+		if f.srcip == nil {
+			merr = multierr.Append(merr, errFlagRequired("-src.ip"))
+		}
+		if f.dstip == nil {
+			merr = multierr.Append(merr, errFlagRequired("-dst.ip"))
+		}
+	}
+	if err := f.InitOptions(); err != nil {
+		merr = multierr.Append(merr, err)
 	}
-	if f.dstip == nil {
-		merr = multierr.Append(merr, errFlagRequired("-dst.ip"))
+	if f.proto != "sctp" && len(f.vtags) > 0 {
+		merr = multierr.Append(merr, fmt.Errorf("%q requires %q", "-sctp.vtag", "-proto sctp"))
+	}
+	if f.proto != "icmp" && (f.icmpType != nil || f.icmpCode != nil || f.icmpID != nil) {
+		merr = multierr.Append(merr, fmt.Errorf("%q, %q, and %q require %q", "-icmp.type", "-icmp.code", "-icmp.id", "-proto icmp"))
 	}
 	switch f.proto {
-	case "tcp", "udp", "icmp":
+	case "tcp", "udp", "sctp", "icmp":
 	case "":
-		merr = multierr.Append(merr, errFlagRequired("-proto"))
+		if f.cid == "" && !records {
+			merr = multierr.Append(merr, errFlagRequired("-proto"))
+		}
 	default:
 		merr = multierr.Append(merr, fmt.Errorf("unsupported value for %q: %q", "-proto", f.proto))
 	}
 	if merr != nil {
 		return merr
 	}
+	f.Search.Span = nano.Span{Ts: nano.Ts(*f.ts), Dur: nano.Duration(f.duration)}
+	f.Search.Proto = f.proto
+	f.Search.SrcIP = net.IP(f.srcip)
+	f.Search.SrcPort = uint16(f.srcport)
+	f.Search.DstIP = net.IP(f.dstip)
+	f.Search.DstPort = uint16(f.dstport)
+	return nil
+}
+
+// InitOptions checks the flags set by SetOptionFlags and sets the options of
+// Search from them, leaving the fields identifying the connection zero.
+func (f *PcapSearchFlags) InitOptions() error {
+	var merr error
+	if f.cid != "" {
+		if _, err := pcap.ParseCommunityID(f.cid); err != nil {
+			merr = multierr.Append(merr, err)
+		}
+	}
+	if f.dedup < 0 {
+		merr = multierr.Append(merr, fmt.Errorf("negative value for %q: %s", "-dedup", f.dedup))
+	}
+	if f.cidSeed > math.MaxUint16 {
+		merr = multierr.Append(merr, fmt.Errorf("unsupported value for %q: %d", "-community_id.seed", f.cidSeed))
+	}
+	if _, err := bpf.Compile(f.filter); err != nil {
+		merr = multierr.Append(merr, err)
+	}
+	if f.icmpCode != nil && f.icmpType == nil {
+		merr = multierr.Append(merr, fmt.Errorf("%q requires %q", "-icmp.code", "-icmp.type"))
+	}
+	if merr != nil {
+		return merr
+	}
 	f.Search = brimcap.Search{
-		Span:    nano.Span{Ts: nano.Ts(*f.ts), Dur: nano.Duration(f.duration)},
-		Proto:   f.proto,
-		SrcIP:   net.IP(f.srcip),
-		SrcPort: uint16(f.srcport),
-		DstIP:   net.IP(f.dstip),
-		DstPort: uint16(f.dstport),
+		Direction: f.dir,
+		Filter:    f.filter,
+		Tunnel:    f.tunnel,
+		Defrag:    f.defrag,
+		Dedup:     nano.Duration(f.dedup),
+
+		CommunityID:     f.cid,
+		CommunityIDSeed: uint16(f.cidSeed),
+		SCTPTags:        f.vtags,
+		ICMPType:        f.icmpType,
+		ICMPEchoID:      f.icmpID,
+	}
+	if f.icmpCode != nil {
+		f.Search.ICMPCode = *f.icmpCode
 	}
 	return nil
 }
 
+func ptr[T any](v T) *T {
+	return &v
+}
+
 func errFlagRequired(flag string) error {
 	return fmt.Errorf("%q required", flag)
 }
 
 type tsArg nano.Ts
 
+func (t *tsArg) isSet() bool {
+	return t != nil && *t != 0
+}
+
 func (t *tsArg) String() string {
 	if t == nil {
 		return ""
@@ -10,6 +10,8 @@ import (
 	"github.com/brimdata/brimcap/cli"
 	"github.com/brimdata/brimcap/cli/analyzecli"
 	"github.com/brimdata/brimcap/cmd/brimcap/root"
+	"github.com/brimdata/brimcap/decompress"
+	"github.com/brimdata/brimcap/pcap/pcapio"
 	"github.com/brimdata/zed/cli/outputflags"
 	"github.com/brimdata/zed/pkg/charm"
 	"github.com/brimdata/zed/pkg/nano"
@@ -86,18 +88,33 @@ func (c *Command) Run(args []string) (err error) {
 	if err != nil {
 		return err
 	}
+	dr, err := decompress.NewReader(pcapfile)
+	if err != nil {
+		return err
+	}
+	pcap, converted, err := pcapio.ConvertForLibpcap(dr)
+	if err != nil {
+		return err
+	}
+	defer pcap.Close()
+	size := info.Size()
+	if dr.Format != decompress.None || converted {
+		// Progress is measured in bytes of the decompressed or
+		// converted capture so the total size is unknown.
+		size = 0
+	}
 	// json:
 	// - always display stats (except if -nostats is enabled)
 	// status line display stats iff:
 	// - -o is a file: display stats
 	// - -o is stdout and stdout is NOT a terminal: display stats
 	if root.LogJSON {
-		c.Display = analyzecli.JSONDisplay(!c.nostats, info.Size(), nano.Span{})
+		c.Display = analyzecli.JSONDisplay(!c.nostats, size, nano.Span{})
 	} else {
 		tofile := c.out.FileName() != ""
 		stats := !c.nostats && (tofile || !term.IsTerminal(int(os.Stdout.Fd())))
-		c.Display = analyzecli.StatusLineDisplay(stats, info.Size(), nano.Span{})
+		c.Display = analyzecli.StatusLineDisplay(stats, size, nano.Span{})
 	}
 	defer c.Display.End()
-	return analyzer.Run(ctx, pcapfile, emitter, c, time.Second, c.config.Analyzers...)
+	return analyzer.Run(ctx, pcap, emitter, c, time.Second, c.config.Analyzers...)
 }
@@ -0,0 +1,115 @@
+package convert
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/brimdata/brimcap/cmd/brimcap/root"
+	"github.com/brimdata/brimcap/pcap/pcapio"
+	"github.com/brimdata/zed/pkg/charm"
+)
+
+var Convert = &charm.Spec{
+	Name:  "convert",
+	Usage: "convert [options]",
+	Short: "convert a capture to pcap or pcap-ng",
+	Long: `
+The convert command reads a capture in any format brimcap understands
+(legacy pcap, pcap-ng, snoop, or ERF, optionally compressed) and writes it
+as pcap-ng or legacy pcap, as selected by -f.
+
+Packets keep their timestamps, link types, and captured and original
+lengths.  Converting to pcap-ng keeps the sections and interfaces of the
+input along with their comments and timestamp resolutions, and its
+decryption secrets blocks.  Name resolution and interface statistics blocks
+are not copied, nor are blocks of types brimcap doesn't know (e.g., custom
+blocks), which it skips when reading.  Converting to legacy pcap drops the
+decryption secrets too, since the format can't hold them.
+
+Since a legacy pcap holds a single link type and timestamp precision,
+converting to legacy pcap fails if the input has interfaces that cannot be
+described by one file header (e.g., interfaces with different link types).
+`,
+	New: New,
+}
+
+func init() {
+	root.Brimcap.Add(Convert)
+}
+
+type Command struct {
+	outputFile string
+	inputFile  string
+	format     string
+	*root.Command
+}
+
+func New(parent charm.Command, f *flag.FlagSet) (charm.Command, error) {
+	c := &Command{Command: parent.(*root.Command)}
+	f.StringVar(&c.outputFile, "w", "-", "output file to create or stdout if -")
+	f.StringVar(&c.inputFile, "r", "-", "input file to read from or stdin if -")
+	f.StringVar(&c.format, "f", "pcapng", "output format [pcap,pcapng]")
+	return c, nil
+}
+
+func (c *Command) Run(args []string) (err error) {
+	cleanup, err := c.Command.Init()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if len(args) != 0 {
+		return errors.New("convert: extraneous arguments on command line")
+	}
+	if c.format != "pcap" && c.format != "pcapng" {
+		return fmt.Errorf("unknown output format: %s", c.format)
+	}
+	in := os.Stdin
+	if c.inputFile != "-" {
+		in, err = os.Open(c.inputFile)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+	reader, err := pcapio.NewReader(in)
+	if err != nil {
+		return err
+	}
+	out := io.Writer(os.Stdout)
+	if c.outputFile != "-" {
+		var f *os.File
+		f, err = os.OpenFile(c.outputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(f)
+		defer func() {
+			if ferr := w.Flush(); err == nil {
+				err = ferr
+			}
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(c.outputFile)
+			}
+		}()
+		out = w
+	}
+	var writer pcapio.Writer
+	if c.format == "pcap" {
+		writer = pcapio.NewPcapWriter(out)
+	} else {
+		writer = pcapio.NewNgWriter(out)
+	}
+	err = pcapio.Copy(writer, reader)
+	if errors.Is(err, pcapio.ErrUnrepresentable) {
+		err = fmt.Errorf("cannot convert to %s: %w", c.format, err)
+	}
+	return err
+}
@@ -1,11 +1,15 @@
 package index
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/brimdata/brimcap"
 	"github.com/brimdata/brimcap/cli"
@@ -33,12 +37,50 @@ the number of slots is computed by choosing D, the smallest
 power-of-2 divisor of N, the number of packets in the pcap file, such that N / D
 is less than or equal to the limit specified by -n).
 
-The output is written in json format to standard output or if -x is specified,
-to the indicate file.
+The index is written to standard output or, if -x is specified, to the
+indicated file in a versioned binary format.  Its header records the size,
+modification time, and SHA-256 hash of the pcap along with the -n limit, and
+brimcap slice and brimcap search refuse an index whose pcap has since changed,
+warning and scanning the whole pcap instead.  With -f json, the index is
+written in JSON as by older versions of brimcap, without a header, so it
+can be inspected or processed by other tools.  brimcap slice accepts either
+format while a brimcap root always holds binary indexes.
+
+Pcaps compressed with gzip, zstd, xz, or bzip2 are decompressed on the fly and
+indexed by their decompressed offsets.  For gzip pcaps made of multiple members
+(e.g., as written by bgzip) and zstd pcaps made of multiple frames (e.g., as
+written by pzstd), the index also records member and frame boundaries so
+slicing can restart decompression near the requested packets rather than at
+the beginning of the file.  Single-member gzip, single-frame zstd, xz, and
+bzip2 pcaps are always decompressed from the beginning when sliced.
+
+If -flows is specified, the index also summarizes the flows of the packets
+in each of its slots with a Bloom filter of their IP address pairs and TCP,
+UDP, and SCTP 5-tuples.  Searches for a flow (by brimcap slice or brimcap
+search) then skip the slots, and with -root whole pcaps, that can't hold it.
+Building the summary decodes every packet so indexing takes longer, and the
+index grows by about 10 bits per distinct flow in each slot.
 
 If the -root flag is specified the pcap index will be written to a common
 directory, then multiple pcaps can be searched in parallel using the brimcap
-search command.
+search command.  Indexing a pcap already in the root replaces its index and,
+if the pcap has only been appended to since (e.g., by a capture still being
+written), reads just the packets appended, so a growing capture can be
+re-indexed periodically to keep it searchable.  A block cut short at the end
+of the pcap is left for the next run.  If the number of slots would exceed
+-n, adjacent slots are merged.
+
+With -watch, the command runs until interrupted, keeping -root up to date with
+the pcaps of a directory, e.g., the rolling output of a sensor.  The argument
+of -watch is a directory, whose files are all watched, or a directory followed
+by a glob matching the names of the files watched (e.g., "/data/*.pcap"), and
+-watch may be repeated.  A file is indexed once it has stopped growing for
+-settle and again each time it settles after growing, and its index is removed
+from -root when the file is removed or renamed, including when that happened
+before the command started.  Files that can't be indexed are warned about and
+retried when they change.  A directory that can't be watched any longer, e.g.,
+because it was removed, is warned about, and the command exits once no
+directories are left.
 `,
 	New: New,
 }
@@ -50,6 +92,10 @@ func init() {
 type Command struct {
 	*root.Command
 	limit      int
+	flows      bool
+	watch      watchFlags
+	settle     time.Duration
+	format     string
 	config     cli.ConfigFlags
 	inputFile  string
 	outputFile string
@@ -63,20 +109,36 @@ func New(parent charm.Command, f *flag.FlagSet) (charm.Command, error) {
 	f.StringVar(&c.inputFile, "r", "-", "input file to read from or stdin if -")
 	f.StringVar(&c.outputFile, "x", "-", "name of output file for the index or - for stdout")
 	f.IntVar(&c.limit, "n", 10000, "limit on index size")
+	f.StringVar(&c.format, "f", "binary", "format of the index [binary,json]")
+	f.BoolVar(&c.flows, "flows", false, "summarize flows so searches skip regions without them")
+	f.Var(&c.watch, "watch", "directory, optionally ending in a glob, whose pcaps to keep indexed in -root (may be repeated)")
+	f.DurationVar(&c.settle, "settle", 5*time.Second, "time a watched pcap must stop growing before it's indexed")
 	return c, nil
 }
 
 func (c *Command) Run(args []string) (err error) {
-	cleanup, err := c.Command.Init()
+	ctx, cleanup, err := c.Command.InitWithContext()
 	if err != nil {
 		return err
 	}
 	defer cleanup()
+	if c.format != "binary" && c.format != "json" {
+		return fmt.Errorf("unknown index format: %s", c.format)
+	}
+	if len(c.watch) > 0 {
+		if c.config.RootPath == "" {
+			return errors.New("-watch requires -root")
+		}
+		if c.inputFile != "-" {
+			return errors.New("-watch and -r cannot be used together")
+		}
+		return brimcap.Root(c.config.RootPath).Watch(ctx, c.watch, c.options(), c.settle, c)
+	}
 	if c.config.RootPath != "" {
 		if c.inputFile == "-" {
 			return errors.New("cannot write pcap from stdin to brimcap root")
 		}
-		_, err := brimcap.Root(c.config.RootPath).AddPcap(c.inputFile, c.limit, c)
+		_, err := brimcap.Root(c.config.RootPath).AddPcapWithOptions(c.inputFile, c.options())
 		return err
 	}
 	f, err := cli.OpenFileArg(c.inputFile)
@@ -84,19 +146,35 @@ func (c *Command) Run(args []string) (err error) {
 		return err
 	}
 	defer f.Close()
-	index, err := pcap.CreateIndexWithWarnings(f, c.limit, c)
+	var path string
+	if c.inputFile != "-" {
+		if path, err = filepath.Abs(c.inputFile); err != nil {
+			return err
+		}
+	}
+	header, index, err := pcap.CreateIndexFile(f, path, c.options())
 	if err != nil {
 		return err
 	}
-	b, err := json.Marshal(index)
+	var buf bytes.Buffer
+	switch c.format {
+	case "binary":
+		err = pcap.WriteIndexFile(&buf, header, index)
+	case "json":
+		err = json.NewEncoder(&buf).Encode(index)
+	}
 	if err != nil {
 		return err
 	}
 	if c.outputFile == "-" {
-		fmt.Println(string(b))
-		return nil
+		_, err = os.Stdout.Write(buf.Bytes())
+		return err
 	}
-	return os.WriteFile(c.outputFile, b, 0644)
+	return os.WriteFile(c.outputFile, buf.Bytes(), 0644)
+}
+
+func (c *Command) options() pcap.IndexOptions {
+	return pcap.IndexOptions{Size: c.limit, Flows: c.flows, Warner: c}
 }
 
 // XXX this should log to json in root command -json flag is set
@@ -104,3 +182,14 @@ func (c *Command) Warn(msg string) error {
 	fmt.Fprintf(os.Stderr, "warning: %s\n", msg)
 	return nil
 }
+
+type watchFlags []string
+
+func (w *watchFlags) String() string {
+	return strings.Join(*w, ",")
+}
+
+func (w *watchFlags) Set(s string) error {
+	*w = append(*w, s)
+	return nil
+}
@@ -1,7 +1,6 @@
 package info
 
 import (
-	"bytes"
 	"errors"
 	"flag"
 	"fmt"
@@ -57,41 +56,104 @@ func (c *Command) Run(args []string) error {
 		return err
 	}
 	out := os.Stdout
-	if pr, ok := reader.(*pcapio.PcapReader); ok {
-		return readPcap(pr, out)
+	switch reader := reader.(type) {
+	case *pcapio.PcapReader:
+		return readPcap(reader, out)
+	case *pcapio.SnoopReader:
+		return readSnoop(reader, out)
+	case *pcapio.ErfReader:
+		return readErf(reader, out)
 	}
 	return readNgPcap(reader.(*pcapio.NgReader), out)
 }
 
 func readPcap(reader *pcapio.PcapReader, out io.Writer) error {
 	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
-	var pcnt int
-	fmt.Fprintf(w, "Pcap type:\tpcap\n")
+	if reader.Modified() {
+		fmt.Fprintf(w, "Pcap type:\tmodified pcap\n")
+	} else {
+		fmt.Fprintf(w, "Pcap type:\tpcap\n")
+	}
 	fmt.Fprintf(w, "Pcap version:\t%s\n", reader.Version())
 	fmt.Fprintf(w, "Link type:\t%s\n", reader.LinkType.String())
 	fmt.Fprintf(w, "Packet size limit:\t%d\n", reader.Snaplen())
+	pcnt, err := countPackets(reader, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Number of packets:\t%d\n", pcnt)
+	return w.Flush()
+}
+
+func readSnoop(reader *pcapio.SnoopReader, out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "Pcap type:\tsnoop\n")
+	fmt.Fprintf(w, "Snoop version:\t%s\n", reader.Version())
+	fmt.Fprintf(w, "Link type:\t%s\n", reader.LinkType.String())
+	var drops uint32
+	pcnt, err := countPackets(reader, func(block []byte) {
+		drops = reader.Drops(block)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Number of packets:\t%d\n", pcnt)
+	fmt.Fprintf(w, "Packets dropped:\t%d\n", drops)
+	return w.Flush()
+}
+
+func readErf(reader *pcapio.ErfReader, out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "Pcap type:\terf\n")
+	var lost uint64
+	pcnt, err := countPackets(reader, func(block []byte) {
+		lost += uint64(reader.Loss(block))
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Number of packets:\t%d\n", pcnt)
+	fmt.Fprintf(w, "Packets lost:\t%d\n", lost)
+	return w.Flush()
+}
+
+// countPackets reads the remainder of reader and returns the number of
+// packets read, calling fn (if not nil) on each packet block.
+func countPackets(reader pcapio.Reader, fn func([]byte)) (int, error) {
+	var pcnt int
 	for {
 		block, typ, err := reader.Read()
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			return err
+			return 0, err
 		}
 		if block == nil {
 			break
 		}
 		if typ == pcapio.TypePacket {
 			pcnt++
+			if fn != nil {
+				fn(block)
+			}
 		}
 	}
-	fmt.Fprintf(w, "Number of packets:\t%d\n", pcnt)
-	return w.Flush()
+	return pcnt, nil
+}
+
+// ngInterface is an interface described in a pcap-ng section.  ifno is its
+// number within the section and stats holds its most recent statistics, if
+// any.
+type ngInterface struct {
+	pcapio.NgInterface
+	ifno  int
+	stats *pcapio.NgInterfaceStatistics
 }
 
 func readNgPcap(reader *pcapio.NgReader, out io.Writer) error {
-	var intfCounter, pcnt int
-	buf := bytes.NewBuffer(nil)
+	var intfs []ngInterface
+	var section, pcnt int
 	w := tabwriter.NewWriter(out, 4, 0, 1, ' ', 0)
 	fmt.Fprintf(w, "Pcap type:\tpcapng\n")
 	for {
@@ -107,27 +169,47 @@ func readNgPcap(reader *pcapio.NgReader, out io.Writer) error {
 		}
 		switch typ {
 		case pcapio.TypeSection:
-			intfCounter = 0
-			section := reader.SectionHeader(block)
-			fmt.Fprintf(w, "Pcap Version:\t%s\n", section.Version())
+			section = len(intfs)
+			info := reader.SectionHeader(block)
+			fmt.Fprintf(w, "Pcap Version:\t%s\n", info.Version())
 		case pcapio.TypeInterface:
 			intf, err := reader.InterfaceDescriptor(block)
 			if err != nil {
 				return err
 			}
-			fmt.Fprintf(buf, "Interface %d:\n", intfCounter)
-			intfCounter++
-			fmt.Fprintf(buf, "\tDescription:\t%s\n", intf.Description)
-			fmt.Fprintf(buf, "\tLink type:\t%s\n", intf.LinkType)
-			fmt.Fprintf(buf, "\tTime resolution:\t%s\n", intf.Resolution().String())
-			fmt.Fprintf(buf, "\tPacket size limit:\t%d\n", intf.SnapLength)
+			intfs = append(intfs, ngInterface{intf, len(intfs) - section, nil})
+		case pcapio.TypeInterfaceStatistics:
+			ifno, stats, err := reader.InterfaceStatistics(block)
+			if err != nil {
+				return err
+			}
+			intfs[section+ifno].stats = &stats
 		case pcapio.TypePacket:
 			pcnt++
 		}
 	}
 	fmt.Fprintf(w, "Number of packets:\t%d\n", pcnt)
-	if _, err := io.Copy(w, buf); err != nil {
-		return err
+	for _, intf := range intfs {
+		fmt.Fprintf(w, "Interface %d:\n", intf.ifno)
+		fmt.Fprintf(w, "\tDescription:\t%s\n", intf.Description)
+		fmt.Fprintf(w, "\tLink type:\t%s\n", intf.LinkType)
+		fmt.Fprintf(w, "\tTime resolution:\t%s\n", intf.Resolution().String())
+		if intf.TimestampOffset != 0 {
+			fmt.Fprintf(w, "\tTime offset:\t%ds\n", intf.TimestampOffset)
+		}
+		fmt.Fprintf(w, "\tPacket size limit:\t%d\n", intf.SnapLength)
+		if stats := intf.stats; stats != nil {
+			printStat(w, "Packets received", stats.PacketsReceived)
+			printStat(w, "Packets dropped", stats.PacketsDropped)
+			printStat(w, "Packets dropped by OS", stats.OSDropped)
+		}
 	}
 	return w.Flush()
 }
+
+// printStat prints a capture statistic unless it is missing.
+func printStat(w io.Writer, name string, value uint64) {
+	if value != pcapio.NgNoValue64 {
+		fmt.Fprintf(w, "\t%s:\t%d\n", name, value)
+	}
+}
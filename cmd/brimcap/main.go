@@ -5,12 +5,16 @@ import (
 
 	_ "github.com/brimdata/brimcap/cmd/brimcap/analyze"
 	_ "github.com/brimdata/brimcap/cmd/brimcap/config"
+	_ "github.com/brimdata/brimcap/cmd/brimcap/convert"
 	_ "github.com/brimdata/brimcap/cmd/brimcap/cut"
 	_ "github.com/brimdata/brimcap/cmd/brimcap/index"
 	_ "github.com/brimdata/brimcap/cmd/brimcap/info"
+	_ "github.com/brimdata/brimcap/cmd/brimcap/merge"
 	"github.com/brimdata/brimcap/cmd/brimcap/root"
+	_ "github.com/brimdata/brimcap/cmd/brimcap/rootcmd"
 	_ "github.com/brimdata/brimcap/cmd/brimcap/search"
 	_ "github.com/brimdata/brimcap/cmd/brimcap/slice"
+	_ "github.com/brimdata/brimcap/cmd/brimcap/split"
 	_ "github.com/brimdata/brimcap/cmd/brimcap/ts"
 )
 
@@ -0,0 +1,95 @@
+package merge
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"io"
+	"os"
+
+	"github.com/brimdata/brimcap/cmd/brimcap/root"
+	"github.com/brimdata/brimcap/pcap/pcapio"
+	"github.com/brimdata/zed/pkg/charm"
+)
+
+var Merge = &charm.Spec{
+	Name:  "merge",
+	Usage: "merge [options] file [file ...]",
+	Short: "merge captures into one time-ordered pcap-ng",
+	Long: `
+The merge command reads the packets of one or more captures in any format
+brimcap understands and writes them in timestamp order as a single pcap-ng
+section.
+
+As with mergecap, the packets of each input are assumed to be in timestamp
+order.  Packets with the same timestamp are written in the order of the
+inputs on the command line.
+
+Interfaces are reconciled across inputs: interfaces with identical
+descriptions (link type, snap length, timestamp resolution and offset, name,
+and so forth) are written once and shared in the output.  Decryption secrets
+are kept while name resolution and interface statistics blocks are dropped.
+`,
+	New: New,
+}
+
+func init() {
+	root.Brimcap.Add(Merge)
+}
+
+type Command struct {
+	outputFile string
+	*root.Command
+}
+
+func New(parent charm.Command, f *flag.FlagSet) (charm.Command, error) {
+	c := &Command{Command: parent.(*root.Command)}
+	f.StringVar(&c.outputFile, "w", "-", "output file to create or stdout if -")
+	return c, nil
+}
+
+func (c *Command) Run(args []string) (err error) {
+	cleanup, err := c.Command.Init()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if len(args) == 0 {
+		return errors.New("merge: no input files")
+	}
+	var readers []pcapio.Reader
+	for _, path := range args {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r, err := pcapio.NewReader(f)
+		if err != nil {
+			return err
+		}
+		readers = append(readers, r)
+	}
+	out := io.Writer(os.Stdout)
+	if c.outputFile != "-" {
+		var f *os.File
+		f, err = os.OpenFile(c.outputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(f)
+		defer func() {
+			if ferr := w.Flush(); err == nil {
+				err = ferr
+			}
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(c.outputFile)
+			}
+		}()
+		out = w
+	}
+	return pcapio.Merge(pcapio.NewNgWriter(out), readers...)
+}
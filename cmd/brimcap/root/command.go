@@ -38,7 +38,8 @@ Additionally logs can also be written as ndjson and then operated on using jq
 (https://stedolan.github.io/jq/).
 
 The brimcap index command can be used to index pcap files for
-flow extraction via the brimcap search command.
+flow extraction via the brimcap search command, and the brimcap root command
+lists and maintains the indexed pcaps.
 `,
 	New: New,
 }
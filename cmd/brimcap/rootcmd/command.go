@@ -0,0 +1,130 @@
+package rootcmd
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/brimdata/brimcap"
+	"github.com/brimdata/brimcap/cmd/brimcap/root"
+	"github.com/brimdata/brimcap/pcap"
+	"github.com/brimdata/zed"
+	"github.com/brimdata/zed/pkg/charm"
+	"github.com/brimdata/zed/pkg/nano"
+	"github.com/brimdata/zed/zio"
+	"github.com/brimdata/zed/zson"
+)
+
+var Cmd = &charm.Spec{
+	Name:  "root",
+	Usage: "root [subcommand]",
+	Short: "list and maintain the pcaps of a brimcap root",
+	Long: `
+The root command and its subcommands list and maintain the pcaps indexed in a
+brimcap root (see brimcap index -root).  Their output is written in the
+format given by the output flags, as by brimcap analyze, and defaults to a
+table.
+`,
+	New: New,
+}
+
+func init() {
+	Cmd.Add(Ls)
+	Cmd.Add(Rm)
+	Cmd.Add(GC)
+	root.Brimcap.Add(Cmd)
+}
+
+type Command struct {
+	*root.Command
+}
+
+func New(parent charm.Command, f *flag.FlagSet) (charm.Command, error) {
+	return &Command{Command: parent.(*root.Command)}, nil
+}
+
+func (c *Command) Run(args []string) error {
+	return charm.NeedHelp
+}
+
+// entry is the record written for an entry of a root.  Size, Packets, Indexed,
+// and Age are null for an entry written by an older brimcap and Error for an
+// entry whose status is ok.
+type entry struct {
+	PcapPath string    `zed:"pcap_path"`
+	Status   string    `zed:"status"`
+	Size     *int64    `zed:"size"`
+	Packets  *uint64   `zed:"packets"`
+	Ts       nano.Ts   `zed:"ts"`
+	Duration *duration `zed:"duration"`
+	Indexed  *nano.Ts  `zed:"indexed"`
+	Age      *duration `zed:"age"`
+	Error    *string   `zed:"error"`
+}
+
+// newEntry returns the entry of file, whose Check returned err.
+func newEntry(file brimcap.File, err error, now time.Time) entry {
+	span := file.Index.Span()
+	e := entry{
+		PcapPath: file.PcapPath,
+		Status:   "ok",
+		Ts:       span.Ts,
+	}
+	dur := duration(span.Dur)
+	e.Duration = &dur
+	if h := file.Header; h.Version != 0 {
+		age := duration(nano.TimeToTs(now) - h.Created)
+		e.Size, e.Packets, e.Indexed, e.Age = &h.PcapSize, &h.Packets, &h.Created, &age
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		e.Status = "missing"
+	case errors.Is(err, pcap.ErrStaleIndex):
+		e.Status = "stale"
+	case err != nil:
+		e.Status = "error"
+	case file.Header.Version == 0:
+		// The index can't be checked against the pcap but is
+		// used as is.
+		e.Status = "legacy"
+	}
+	if err != nil {
+		msg := err.Error()
+		e.Error = &msg
+	}
+	return e
+}
+
+// duration is a nano.Duration that marshals to a Zed duration, which is null
+// for a nil *duration.
+type duration nano.Duration
+
+func (d *duration) MarshalZNG(m *zson.MarshalZNGContext) (zed.Type, error) {
+	if d == nil {
+		m.Builder.Append(nil)
+		return zed.TypeDuration, nil
+	}
+	m.Builder.Append(zed.EncodeDuration(nano.Duration(*d)))
+	return zed.TypeDuration, nil
+}
+
+// writeEntries writes the records of entries sorted by pcap path.
+func writeEntries(w zio.Writer, entries []entry) error {
+	slices.SortStableFunc(entries, func(a, b entry) int {
+		return strings.Compare(a.PcapPath, b.PcapPath)
+	})
+	m := zson.NewZNGMarshaler()
+	for _, e := range entries {
+		val, err := m.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := w.Write(val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package rootcmd
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+	"time"
+
+	"github.com/brimdata/brimcap/cli"
+	"github.com/brimdata/brimcap/pcap"
+	"github.com/brimdata/zed/cli/outputflags"
+	"github.com/brimdata/zed/pkg/charm"
+	"github.com/brimdata/zed/pkg/storage"
+)
+
+var GC = &charm.Spec{
+	Name:  "gc",
+	Usage: "root gc [options]",
+	Short: "remove missing and changed pcaps from a brimcap root",
+	Long: `
+The gc command removes from a brimcap root the indexes of the pcaps that no
+longer exist or have changed since they were indexed, writing a record for
+each as by brimcap root ls.  Changed pcaps, which brimcap search reads in
+full, can be indexed again with brimcap index -root instead.  Legacy pcaps,
+whose index can't be checked, are kept unless they no longer exist.
+`,
+	New: NewGC,
+}
+
+type GCCommand struct {
+	*Command
+	config cli.ConfigFlags
+	out    outputflags.Flags
+}
+
+func NewGC(parent charm.Command, f *flag.FlagSet) (charm.Command, error) {
+	c := &GCCommand{Command: parent.(*Command)}
+	c.out.DefaultFormat = "table"
+	c.out.SetFlags(f)
+	err := c.config.SetRootOnlyFlags(f)
+	return c, err
+}
+
+func (c *GCCommand) Run(args []string) error {
+	ctx, cleanup, err := c.InitWithContext(&c.out)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if len(args) > 0 {
+		return errors.New("gc takes no arguments")
+	}
+	if c.config.RootPath == "" {
+		return errors.New("root path (-root) must be set")
+	}
+	files, err := c.config.Root().Pcaps()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var entries []entry
+	for _, file := range files {
+		err := file.Check()
+		if !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, pcap.ErrStaleIndex) {
+			continue
+		}
+		if err := file.Delete(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		entries = append(entries, newEntry(file, err, now))
+	}
+	w, err := c.out.Open(ctx, storage.NewLocalEngine())
+	if err != nil {
+		return err
+	}
+	if err := writeEntries(w, entries); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
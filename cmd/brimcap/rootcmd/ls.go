@@ -0,0 +1,77 @@
+package rootcmd
+
+import (
+	"errors"
+	"flag"
+	"time"
+
+	"github.com/brimdata/brimcap/cli"
+	"github.com/brimdata/zed/cli/outputflags"
+	"github.com/brimdata/zed/pkg/charm"
+	"github.com/brimdata/zed/pkg/storage"
+)
+
+var Ls = &charm.Spec{
+	Name:  "ls",
+	Usage: "root ls [options]",
+	Short: "list the pcaps of a brimcap root",
+	Long: `
+The ls command lists the pcaps of a brimcap root, writing a record for each
+with its path (pcap_path), its size in bytes (size) and number of packets
+(packets) when indexed, the time span of its packets (ts and duration), when
+it was indexed (indexed) and how long ago (age), and the status of its index
+(status): ok, missing if the pcap no longer exists, stale if it has changed
+since it was indexed (see brimcap index -root and brimcap root gc), or legacy
+if it was indexed by an older brimcap, whose index can't be checked against
+the pcap and should be re-created with brimcap index -root.  For a missing or
+stale pcap, error tells why.  The size, packets, indexed, and age of a legacy
+pcap are null.
+`,
+	New: NewLs,
+}
+
+type LsCommand struct {
+	*Command
+	config cli.ConfigFlags
+	out    outputflags.Flags
+}
+
+func NewLs(parent charm.Command, f *flag.FlagSet) (charm.Command, error) {
+	c := &LsCommand{Command: parent.(*Command)}
+	c.out.DefaultFormat = "table"
+	c.out.SetFlags(f)
+	err := c.config.SetRootOnlyFlags(f)
+	return c, err
+}
+
+func (c *LsCommand) Run(args []string) error {
+	ctx, cleanup, err := c.InitWithContext(&c.out)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if len(args) > 0 {
+		return errors.New("ls takes no arguments")
+	}
+	if c.config.RootPath == "" {
+		return errors.New("root path (-root) must be set")
+	}
+	files, err := c.config.Root().Pcaps()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var entries []entry
+	for _, file := range files {
+		entries = append(entries, newEntry(file, file.Check(), now))
+	}
+	w, err := c.out.Open(ctx, storage.NewLocalEngine())
+	if err != nil {
+		return err
+	}
+	if err := writeEntries(w, entries); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,91 @@
+package rootcmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/brimdata/brimcap/cli"
+	"github.com/brimdata/zed/cli/outputflags"
+	"github.com/brimdata/zed/pkg/charm"
+	"github.com/brimdata/zed/pkg/storage"
+)
+
+var Rm = &charm.Spec{
+	Name:  "rm",
+	Usage: "root rm [options] pcap...",
+	Short: "remove pcaps from a brimcap root",
+	Long: `
+The rm command removes the indexes of the given pcaps from a brimcap root so
+they're no longer searched, writing a record for each as by brimcap root ls.
+The pcaps themselves are not modified or removed.  It's an error for a pcap not
+to be in the root.
+`,
+	New: NewRm,
+}
+
+type RmCommand struct {
+	*Command
+	config cli.ConfigFlags
+	out    outputflags.Flags
+}
+
+func NewRm(parent charm.Command, f *flag.FlagSet) (charm.Command, error) {
+	c := &RmCommand{Command: parent.(*Command)}
+	c.out.DefaultFormat = "table"
+	c.out.SetFlags(f)
+	err := c.config.SetRootOnlyFlags(f)
+	return c, err
+}
+
+func (c *RmCommand) Run(args []string) error {
+	ctx, cleanup, err := c.InitWithContext(&c.out)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if len(args) == 0 {
+		return errors.New("rm takes one or more pcaps")
+	}
+	if c.config.RootPath == "" {
+		return errors.New("root path (-root) must be set")
+	}
+	root := c.config.Root()
+	files, err := root.Pcaps()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var entries []entry
+	for _, arg := range args {
+		path, err := filepath.Abs(arg)
+		if err != nil {
+			return err
+		}
+		n := len(entries)
+		for _, file := range files {
+			if file.PcapPath == path {
+				entries = append(entries, newEntry(file, file.Check(), now))
+			}
+		}
+		if len(entries) == n {
+			return fmt.Errorf("%s: pcap is not in the root", arg)
+		}
+	}
+	for _, e := range entries {
+		if err := root.DeletePcap(e.PcapPath); err != nil {
+			return err
+		}
+	}
+	w, err := c.out.Open(ctx, storage.NewLocalEngine())
+	if err != nil {
+		return err
+	}
+	if err := writeEntries(w, entries); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -1,13 +1,23 @@
 package search
 
 import (
+	"context"
 	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/brimdata/brimcap"
 	"github.com/brimdata/brimcap/cli"
 	"github.com/brimdata/brimcap/cmd/brimcap/root"
+	"github.com/brimdata/brimcap/pcap"
+	"github.com/brimdata/zed"
 	"github.com/brimdata/zed/pkg/charm"
+	"github.com/brimdata/zed/zio/anyio"
 )
 
 var Search = &charm.Spec{
@@ -18,6 +28,62 @@ var Search = &charm.Spec{
 The search command searches in parallel for a specific connection in a list of
 indexed pcap files (generated using brimcap index -root) and writes the results
 to a new pcap file or to standard output.
+
+The connection is given either by -proto, -src.ip, -src.port, -dst.ip, and
+-dst.port or by its Community ID (-community_id), as found in the
+community_id field of Zeek and Suricata logs.  If both are given, packets
+must match both.
+
+The matching packets of all files are merged in timestamp order into a single
+pcap-ng section (see brimcap merge) or, if the matches all come from legacy
+pcaps with the same link type, snap length, and timestamp resolution, into a
+legacy pcap.  Sections without matches are dropped, and the same search over
+the same pcaps always writes the same bytes.
+
+Pcaps indexed with brimcap index -flows are skipped, along with the regions of
+other pcaps, if their flow summaries show they can't hold the connection.
+Searches by Community ID alone can't skip them.
+
+With -dedup, packets that duplicate a packet found within that window (e.g.,
+"1s"), whether in the same pcap or in another, are dropped like editcap -w,
+keeping the earliest of each run of duplicates.
+
+The connection may instead be given by a record, such as a Zeek conn record
+copied from Zui, in ZSON or JSON with -record.  The record's fields that give
+the connection are named by the field mapping of -fields, which is either
+zeek (ts, duration, proto, id.orig_h, id.orig_p, id.resp_h, id.resp_p,
+community_id, and uid), suricata (flow.start, ts, proto, src_ip, src_port,
+dest_ip, dest_port, and community_id), nfdump (first, last, proto,
+src4_addr, src_port, dst4_addr, and dst_port), or one defined in the fields
+section of the config file, e.g.,
+
+  fields:
+    mylog:
+      ts: start
+      end: stop
+      proto: transport
+      src_addr: client.addr
+      src_port: client.port
+      dst_addr: server.addr
+      dst_port: server.port
+
+A record without address fields is searched for by its Community ID.
+
+With -flows, the command searches for the connections of all records of a
+file in any format read by zq (or of standard input if -flows is -), reading
+each region of each pcap once.  With -uid, only the records with that uid
+(e.g., a Zeek uid) are searched for.  The options other than those giving a
+connection apply to all of them.  The packets of all connections are written
+to a single pcap or, with -perflow, those of the connection of the nth record
+(counting from zero) are written to a pcap named after -w with a suffix of
+n, e.g., "out-00002.pcap" for "-w out.pcap".  No pcap is written for a
+connection without packets.
+
+With -mmap, pcaps are read through memory mappings rather than with read
+system calls, which saves copying and system calls when searching many large
+pcaps.  Since truncating a mapped pcap can crash brimcap on some platforms,
+only pcaps that were complete when indexed and haven't changed since are
+mapped, so captures still being written are always read.
 `,
 	New: New,
 }
@@ -30,12 +96,16 @@ type Command struct {
 	*root.Command
 	config      cli.ConfigFlags
 	outfile     string
+	perflow     bool
+	mmap        bool
 	searchflags cli.PcapSearchFlags
 }
 
 func New(parent charm.Command, f *flag.FlagSet) (charm.Command, error) {
 	c := &Command{Command: parent.(*root.Command)}
 	f.StringVar(&c.outfile, "w", "-", "file to write to or stdout if -")
+	f.BoolVar(&c.perflow, "perflow", false, "write the packets of each connection of -flows to its own file")
+	f.BoolVar(&c.mmap, "mmap", false, "read pcaps through memory mappings")
 	c.searchflags.SetFlags(f)
 	err := c.config.SetRootOnlyFlags(f)
 	return c, err
@@ -53,6 +123,26 @@ func (c *Command) Run(args []string) (err error) {
 	if c.config.RootPath == "" {
 		return errors.New("root path (-root) must be set")
 	}
+	c.searchflags.Search.Mmap = c.mmap
+	if err := c.warnStale(); err != nil {
+		return err
+	}
+	if c.searchflags.Flows != "" {
+		return c.searchFlows(ctx)
+	}
+	if c.searchflags.Record != "" {
+		reqs, err := c.readRecords(strings.NewReader(c.searchflags.Record))
+		if err != nil {
+			return err
+		}
+		if len(reqs) != 1 {
+			return errors.New("-record must be a single record")
+		}
+		c.searchflags.Search = reqs[0]
+	}
+	if c.perflow {
+		return errors.New("-perflow requires -flows")
+	}
 	out := os.Stdout
 	if c.outfile != "-" {
 		out, err = os.Create(c.outfile)
@@ -69,3 +159,79 @@ func (c *Command) Run(args []string) (err error) {
 	}
 	return err
 }
+
+// warnStale warns of the pcaps whose index is stale since they are searched
+// in full and of those that are missing since they are skipped.
+func (c *Command) warnStale() error {
+	files, err := c.config.Root().Pcaps()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		switch err := file.Check(); {
+		case errors.Is(err, pcap.ErrStaleIndex):
+			fmt.Fprintf(os.Stderr, "warning: %s: %s (searching the whole pcap; re-index it with brimcap index -root)\n", file.PcapPath, err)
+		case errors.Is(err, fs.ErrNotExist):
+			fmt.Fprintf(os.Stderr, "warning: %s: pcap is missing (remove it with brimcap root gc)\n", file.PcapPath)
+		}
+	}
+	return nil
+}
+
+func (c *Command) searchFlows(ctx context.Context) error {
+	if c.perflow && c.outfile == "-" {
+		return errors.New("-perflow requires -w")
+	}
+	var r io.Reader = os.Stdin
+	if c.searchflags.Flows != "-" {
+		f, err := os.Open(c.searchflags.Flows)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	reqs, err := c.readRecords(r)
+	if err != nil {
+		return err
+	}
+	if uid := c.searchflags.UID; uid != "" && len(reqs) == 0 {
+		return fmt.Errorf("no record with uid %q", uid)
+	}
+	if c.perflow {
+		return c.config.Root().SearchEach(ctx, reqs, c.create)
+	}
+	out := os.Stdout
+	if c.outfile != "-" {
+		out, err = os.Create(c.outfile)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.config.Root().SearchAll(ctx, reqs, out)
+	if c.outfile != "-" {
+		out.Close()
+		if err != nil {
+			os.Remove(c.outfile)
+		}
+	}
+	return err
+}
+
+func (c *Command) readRecords(r io.Reader) ([]brimcap.Search, error) {
+	fields, err := c.config.FieldMapping(c.searchflags.Fields)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := anyio.NewReader(zed.NewContext(), r, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return brimcap.ReadSearches(zr, fields, c.searchflags.UID, c.searchflags.Search)
+}
+
+func (c *Command) create(n int) (io.WriteCloser, error) {
+	ext := filepath.Ext(c.outfile)
+	return os.Create(fmt.Sprintf("%s-%05d%s", strings.TrimSuffix(c.outfile, ext), n, ext))
+}
@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 
+	"github.com/brimdata/brimcap/cli"
 	"github.com/brimdata/brimcap/cmd/brimcap/root"
 	"github.com/brimdata/brimcap/pcap"
 	"github.com/brimdata/brimcap/pcap/pcapio"
@@ -36,13 +38,55 @@ tool like wireshark.
 If an index is provided with -x, then the packets that fall outside of
 the indexed time range are skipped without disk I/O, which dramatically speeds up the
 slicing when extracting a small range out of a large pcap.
+If the index was created with brimcap index -flows, the regions that can't
+hold the flow filter's flow are skipped as well.  An index whose header shows
+that it's of another pcap (by absolute path) or that the pcap has changed in
+size or modification time since it was indexed is ignored with a warning.
+An index in JSON has no header and is always used.
 If the time range is specified, it is used by the index and only
 packets that fall within the time range are scanned.  (If the time
 range is given but no index is provided, then the entire pcap is scanned
 but only packets that fall within the time range are matched.)
 If a flow filter is specified in the format "ip:port ip:port",
-along with a protocol ("tcp", "udp", or "icmp" specified with -p), then
-only packets from that flow are matched.
+along with a protocol ("tcp", "udp", "sctp", or "icmp" specified with -p),
+then only packets from that flow are matched.  For pcap-ng input, TLS key log
+lines in decryption secrets blocks are kept only for the TLS sessions whose
+ClientHello is matched, and other secrets are dropped, unless every packet
+is matched.
+
+The ports of an ICMP flow are ignored so all ICMP and ICMPv6 messages between
+its hosts match unless narrowed by -icmp.type and -icmp.code, which are given
+as in the id.orig_p and id.resp_p fields of Zeek conn records (for message
+types like echo request with a counterpart, the code is ignored and messages
+of both types match), or by -icmp.id, the identifier of echo messages.  An
+SCTP flow may be narrowed by verification tags with -sctp.vtag, which may be
+repeated.
+
+If -community_id is specified, only packets of the flow with that Community ID
+(computed with the seed given by -community_id.seed) are matched.  This covers
+TCP, UDP, SCTP, ICMP, and ICMPv6 flows.
+
+Only the initial fragment of a fragmented IP datagram holds the transport
+header matched by a flow filter.  If -defrag is specified, the other fragments
+of the matching datagrams are matched as well.  Fragments that precede the
+initial fragment of their datagram are written right after it.
+
+Packets encapsulated by VLAN or QinQ tags, MPLS labels, or GRE, VXLAN, GENEVE,
+or IP-in-IP tunnels are matched against the flow filter by each of their IP
+headers.  The -tunnel flag restricts matching to the "outer" (outermost) or
+"inner" (innermost) header, which for unencapsulated packets are the same.
+
+If -dedup is specified, matching packets that duplicate an earlier matching
+packet (i.e., have the same captured data and original length) seen within
+that window (e.g., "1s") are dropped, like editcap -w.  Such duplicates come
+from span ports and overlapping sensors capturing the same packet twice.
+
+If -direction is "inbound" or "outbound", only pcap-ng packets whose epb_flags
+option indicates that direction are matched.
+
+If -filter is specified, only packets matching the packet filter expression
+are matched.  The expression syntax is that of tcpdump (see pcap-filter(7)),
+e.g., "tcp port 443 and not host 10.0.0.1".
 
 The time format for -from and -to is currently float seconds since 1970-01-01.
 We will support more flexible time formats in the future.
@@ -55,12 +99,13 @@ func init() {
 }
 
 type Command struct {
-	outputFile string
-	inputFile  string
-	indexFile  string
-	from       string
-	to         string
-	proto      string
+	outputFile  string
+	inputFile   string
+	indexFile   string
+	from        string
+	to          string
+	proto       string
+	searchflags cli.PcapSearchFlags
 	*root.Command
 }
 
@@ -71,7 +116,8 @@ func New(parent charm.Command, f *flag.FlagSet) (charm.Command, error) {
 	f.StringVar(&c.indexFile, "x", "", "index file")
 	f.StringVar(&c.from, "from", "", "beginning of time range")
 	f.StringVar(&c.to, "to", "", "end of time range")
-	f.StringVar(&c.proto, "p", "tcp", "transport protocol [tcp,udp,icmp]")
+	f.StringVar(&c.proto, "p", "tcp", "transport protocol [tcp,udp,sctp,icmp]")
+	c.searchflags.SetOptionFlags(f)
 	return c, nil
 }
 
@@ -103,19 +149,25 @@ func (c *Command) Run(args []string) error {
 	if c.indexFile != "" && c.inputFile == "-" {
 		return errors.New("stdin cannot be used with an index file; use -r to specify the pcap file")
 	}
-	var flow pcap.Flow
-	filter := false
+	if err := c.searchflags.InitOptions(); err != nil {
+		return err
+	}
+	req := c.searchflags.Search
 	if len(args) == 2 {
-		var err error
-		flow, err = pcap.ParseFlow(args[0], args[1])
+		flow, err := pcap.ParseFlow(args[0], args[1])
 		if err != nil {
 			return err
 		}
-		filter = true
+		req.Proto = c.proto
+		req.SrcIP, req.SrcPort = flow.S0.IP, uint16(flow.S0.Port)
+		req.DstIP, req.DstPort = flow.S1.IP, uint16(flow.S1.Port)
 	} else if len(args) != 0 {
 		return errors.New("pcap slice: extraneous arguments on command line")
 	}
-	span, err := parseSpan(c.from, c.to)
+	if req.Span, err = parseSpan(c.from, c.to); err != nil {
+		return err
+	}
+	search, err := req.PcapSearch()
 	if err != nil {
 		return err
 	}
@@ -129,15 +181,36 @@ func (c *Command) Run(args []string) error {
 	}
 	reader := io.Reader(in)
 	if c.indexFile != "" {
-		index, err := pcap.LoadIndex(c.indexFile)
+		header, index, err := pcap.LoadIndexFile(c.indexFile)
 		if err != nil {
 			return err
 		}
-		slicer, err := pcap.NewSlicer(in, index, span)
+		info, err := in.Stat()
 		if err != nil {
 			return err
 		}
-		reader = io.Reader(slicer)
+		var path string
+		if c.inputFile != "-" {
+			if path, err = filepath.Abs(c.inputFile); err != nil {
+				return err
+			}
+		}
+		if err := header.Check(path, info); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %s: %s (scanning the whole pcap)\n", c.indexFile, err)
+		} else {
+			var r io.ReadSeeker = in
+			if info.Mode().IsRegular() {
+				r = io.NewSectionReader(in, 0, pcap.IndexedSize(header, info.Size()))
+			}
+			slicer, err := pcap.NewSlicer(r, index, search)
+			if err != nil {
+				return err
+			}
+			if slicer == nil {
+				return pcap.ErrNoPcapsFound
+			}
+			reader = io.Reader(slicer)
+		}
 	}
 	pcapReader, err := pcapio.NewReader(reader)
 	if err != nil {
@@ -156,20 +229,5 @@ func (c *Command) Run(args []string) error {
 		}()
 		out = w
 	}
-	var search pcap.Search
-	if filter {
-		switch c.proto {
-		case "tcp":
-			search = pcap.NewTCPSearch(span, flow)
-		case "udp":
-			search = pcap.NewUDPSearch(span, flow)
-		case "icmp":
-			search = pcap.NewICMPSearch(span, flow.S0.IP, flow.S1.IP)
-		default:
-			return fmt.Errorf("unknown protocol: %s", c.proto)
-		}
-	} else {
-		search = pcap.NewRangeSearch(span)
-	}
 	return search.Run(ctx, out, pcapReader)
 }
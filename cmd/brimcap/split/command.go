@@ -0,0 +1,148 @@
+package split
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brimdata/brimcap"
+	"github.com/brimdata/brimcap/cli"
+	"github.com/brimdata/brimcap/cmd/brimcap/root"
+	"github.com/brimdata/brimcap/pcap/pcapio"
+	"github.com/brimdata/zed/pkg/charm"
+	"github.com/brimdata/zed/pkg/nano"
+	"github.com/brimdata/zed/pkg/units"
+)
+
+var Split = &charm.Spec{
+	Name:  "split",
+	Usage: "split [options]",
+	Short: "split a capture into chunks by time, packet count, or size",
+	Long: `
+The split command cuts a capture into consecutive chunks, each of which is a
+complete capture, and writes them to files named after -w with a five-digit
+chunk number inserted before the extension, e.g., "-w out.pcapng" writes
+out-00000.pcapng, out-00001.pcapng, and so forth.
+
+A chunk ends when any of the given limits is reached: -duration is the time
+window of each chunk (aligned to the first packet, with no files written for
+windows without packets), -count is the number of packets in a chunk, and
+-size is the size of a chunk (e.g., "1GB"), which a chunk may exceed by at
+most one packet.
+
+Legacy pcap input is split into legacy pcaps and all other formats into
+pcap-ngs.  For pcap-ng, each chunk begins with the section header and
+interface descriptions in effect along with the decryption secrets seen so far
+in the section.  Name resolution and interface statistics blocks are not
+copied.
+
+If the -root flag is specified, each chunk is indexed and added to the brimcap
+root as soon as it is written so it can be searched with brimcap search.
+`,
+	New: New,
+}
+
+func init() {
+	root.Brimcap.Add(Split)
+}
+
+type Command struct {
+	*root.Command
+	config     cli.ConfigFlags
+	inputFile  string
+	outputFile string
+	duration   time.Duration
+	count      int
+	size       units.Bytes
+	limit      int
+}
+
+func New(parent charm.Command, f *flag.FlagSet) (charm.Command, error) {
+	c := &Command{Command: parent.(*root.Command)}
+	if err := c.config.SetRootOnlyFlags(f); err != nil {
+		return nil, err
+	}
+	f.StringVar(&c.inputFile, "r", "-", "input file to read from or stdin if -")
+	f.StringVar(&c.outputFile, "w", "", "name of output files (required)")
+	f.DurationVar(&c.duration, "duration", 0, "time window of each chunk")
+	f.IntVar(&c.count, "count", 0, "number of packets in each chunk")
+	f.Var(&c.size, "size", "size of each chunk")
+	f.IntVar(&c.limit, "n", 10000, "limit on index size of chunks added to root")
+	return c, nil
+}
+
+func (c *Command) Run(args []string) error {
+	cleanup, err := c.Command.Init()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if len(args) != 0 {
+		return errors.New("split: extraneous arguments on command line")
+	}
+	if c.outputFile == "" || c.outputFile == "-" {
+		return errors.New("output file name (-w) must be set")
+	}
+	if c.duration <= 0 && c.count <= 0 && c.size <= 0 {
+		return errors.New("one of -duration, -count, or -size must be set")
+	}
+	if err := c.config.Validate(); err != nil {
+		return err
+	}
+	f, err := cli.OpenFileArg(c.inputFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	reader, err := pcapio.NewReaderWithWarnings(f, c)
+	if err != nil {
+		return err
+	}
+	limit := pcapio.SplitLimit{
+		Duration: nano.Duration(c.duration),
+		Packets:  c.count,
+		Bytes:    int64(c.size),
+	}
+	return pcapio.Split(reader, limit, c.create)
+}
+
+func (c *Command) create(chunk int) (io.WriteCloser, error) {
+	ext := filepath.Ext(c.outputFile)
+	path := fmt.Sprintf("%s-%05d%s", strings.TrimSuffix(c.outputFile, ext), chunk, ext)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkFile{Writer: bufio.NewWriter(f), file: f, cmd: c}, nil
+}
+
+type chunkFile struct {
+	*bufio.Writer
+	file *os.File
+	cmd  *Command
+}
+
+// Close flushes and closes the chunk and adds it to the root, if any.
+func (c *chunkFile) Close() error {
+	err := c.Flush()
+	if cerr := c.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || c.cmd.config.RootPath == "" {
+		return err
+	}
+	_, err = brimcap.Root(c.cmd.config.RootPath).AddPcap(c.file.Name(), c.cmd.limit, c.cmd)
+	return err
+}
+
+// XXX this should log to json in root command -json flag is set
+func (c *Command) Warn(msg string) error {
+	fmt.Fprintf(os.Stderr, "warning: %s\n", msg)
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/brimdata/brimcap/cmd/brimcap/root"
@@ -20,6 +21,10 @@ var Ts = &charm.Spec{
 	Long: `
 The ts command prints the time stamps of each packet in the input pcap in
 fractional seconds.  This is useful for testing.
+
+For pcap-ng packets with an epb_flags option indicating the direction or with
+opt_comment options, the time stamp is followed by "direction=" and the
+direction and by "comment=" and each quoted comment.
 `,
 	New: New,
 }
@@ -91,7 +96,20 @@ func (c *Command) Run(args []string) error {
 			if err != nil {
 				return err
 			}
-			fmt.Fprintln(out, ts.Time().Format(time.RFC3339Nano))
+			line := ts.Time().Format(time.RFC3339Nano)
+			if ng, ok := reader.(*pcapio.NgReader); ok {
+				opts, err := ng.PacketOptions(block)
+				if err != nil {
+					return err
+				}
+				if dir := opts.Direction(); dir != pcapio.NgDirectionUnknown {
+					line += " direction=" + dir.String()
+				}
+				for _, comment := range opts.Comments {
+					line += " comment=" + strconv.Quote(comment)
+				}
+			}
+			fmt.Fprintln(out, line)
 		}
 	}
 	return nil
@@ -3,7 +3,9 @@ package brimcap
 import (
 	_ "embed"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
 
 	"github.com/brimdata/brimcap/analyzer"
 	"gopkg.in/yaml.v3"
@@ -30,6 +32,9 @@ var DefaultConfig = Config{
 type Config struct {
 	RootPath  string            `yaml:"root,omitempty"`
 	Analyzers []analyzer.Config `yaml:"analyzers,omitempty"`
+	// Fields holds field mappings for brimcap search in addition to
+	// those of FieldMappings, which they override.
+	Fields map[string]FieldMapping `yaml:"fields,omitempty"`
 }
 
 func LoadConfigYAML(path string) (Config, error) {
@@ -49,5 +54,22 @@ func LoadConfigYAML(path string) (Config, error) {
 func (c Config) Root() Root { return Root(c.RootPath) }
 
 func (c Config) Validate() error {
+	for _, name := range slices.Sorted(maps.Keys(c.Fields)) {
+		if err := c.Fields[name].validate(); err != nil {
+			return fmt.Errorf("fields %s: %w", name, err)
+		}
+	}
 	return analyzer.Configs(c.Analyzers).Validate()
 }
+
+// FieldMapping returns the field mapping of the given name from Fields or
+// FieldMappings.
+func (c Config) FieldMapping(name string) (FieldMapping, error) {
+	if m, ok := c.Fields[name]; ok {
+		return m, nil
+	}
+	if m, ok := FieldMappings[name]; ok {
+		return m, nil
+	}
+	return FieldMapping{}, fmt.Errorf("unknown field mapping: %q", name)
+}
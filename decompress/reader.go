@@ -0,0 +1,234 @@
+// Package decompress provides transparent decompression of capture files
+// compressed with gzip, zstd, xz or bzip2.  The compression format is sniffed
+// from the magic at the beginning of the stream so uncompressed input passes
+// through unmodified.
+package decompress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/brimdata/brimcap/recorder"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+type Format int
+
+const (
+	None Format = iota
+	Gzip
+	Zstd
+	Xz
+	Bzip2
+)
+
+var formatNames = []string{"none", "gzip", "zstd", "xz", "bzip2"}
+
+func (f Format) String() string {
+	if f < 0 || int(f) >= len(formatNames) {
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+	return formatNames[f]
+}
+
+func (f Format) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+func (f *Format) UnmarshalText(b []byte) error {
+	for i, name := range formatNames {
+		if string(b) == name {
+			*f = Format(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown compression format: %q", b)
+}
+
+var magics = []struct {
+	format Format
+	magic  []byte
+}{
+	{Gzip, []byte{0x1f, 0x8b}},
+	{Zstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{Xz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{Bzip2, []byte("BZh")},
+}
+
+// Sniff returns the compression format indicated by the magic at the
+// beginning of b.
+func Sniff(b []byte) Format {
+	for _, m := range magics {
+		if bytes.HasPrefix(b, m.magic) {
+			return m.format
+		}
+	}
+	return None
+}
+
+// Checkpoint is a point in a compressed stream from which decompression can
+// be restarted.  Offset is the position in the decompressed stream and
+// CompressedOffset is the corresponding position in the compressed stream.
+type Checkpoint struct {
+	Offset           uint64 `json:"offset"`
+	CompressedOffset uint64 `json:"compressed_offset"`
+}
+
+// checkpointInterval is the minimum distance in the decompressed stream
+// between recorded checkpoints.
+var checkpointInterval uint64 = 8 * 1024 * 1024
+
+// Reader is an io.Reader that decompresses the underlying stream according
+// to the sniffed Format.  While reading gzip or zstd data, Reader records a
+// Checkpoint at gzip member and zstd frame boundaries (no more often than
+// every checkpointInterval bytes) so multi-member or multi-frame files, e.g.,
+// those written by bgzip, pzstd, or by concatenating rotated chunks, can be
+// sliced without decompressing from the start of the file.
+//
+// Decompressor state within a member or frame is not recorded so a gzip file
+// with a single member, a zstd file with a single frame, and any xz or bzip2
+// file have just one checkpoint at the beginning of the stream and slicing
+// them always decompresses from the start.
+type Reader struct {
+	Format      Format
+	reader      io.Reader
+	counter     *countingReader
+	gzip        *gzip.Reader
+	zstd        *zstd.Decoder
+	frame       *zstdFrame
+	offset      uint64
+	checkpoints []Checkpoint
+}
+
+// NewReader sniffs the compression format of r and returns a Reader that
+// decompresses it.
+func NewReader(r io.Reader) (*Reader, error) {
+	record := recorder.NewRecorder(r)
+	track := recorder.NewTrack(record)
+	magic := make([]byte, 6)
+	n, err := io.ReadFull(track, magic)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	reader := &Reader{
+		Format:      Sniff(magic[:n]),
+		reader:      record,
+		checkpoints: []Checkpoint{{}},
+	}
+	switch reader.Format {
+	case Gzip:
+		reader.counter = &countingReader{Reader: bufio.NewReader(record)}
+		gz, err := gzip.NewReader(reader.counter)
+		if err != nil {
+			return nil, err
+		}
+		gz.Multistream(false)
+		reader.gzip = gz
+		reader.reader = gz
+		return reader, nil
+	case Zstd:
+		reader.counter = &countingReader{Reader: bufio.NewReader(record)}
+		reader.frame = &zstdFrame{reader: reader.counter}
+		// With a concurrency of one the decoder runs synchronously and
+		// does not need to be closed to release goroutines.
+		zr, err := zstd.NewReader(reader.frame, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		reader.zstd = zr
+		reader.reader = zr
+		return reader, nil
+	}
+	reader.reader, err = newDecompressor(reader.Format, record)
+	return reader, err
+}
+
+func newDecompressor(format Format, r io.Reader) (io.Reader, error) {
+	switch format {
+	case Gzip:
+		return gzip.NewReader(bufio.NewReader(r))
+	case Zstd:
+		// With a concurrency of one the decoder runs synchronously and
+		// does not need to be closed to release goroutines.
+		return zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	case Xz:
+		return xz.NewReader(bufio.NewReader(r))
+	case Bzip2:
+		return bzip2.NewReader(bufio.NewReader(r)), nil
+	}
+	return r, nil
+}
+
+func (r *Reader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	r.offset += uint64(n)
+	if err == io.EOF && r.counter != nil {
+		err = r.nextMember()
+	}
+	if err != nil && err != io.EOF && r.Format != None {
+		err = fmt.Errorf("%s: %w", r.Format, err)
+	}
+	return n, err
+}
+
+// nextMember restarts decompression at the gzip member or zstd frame that
+// follows the one just read and records a checkpoint for it.
+func (r *Reader) nextMember() error {
+	compressed := r.counter.offset
+	if r.gzip != nil {
+		if err := r.gzip.Reset(r.counter); err != nil {
+			return err
+		}
+		r.gzip.Multistream(false)
+	} else {
+		if _, err := r.counter.Peek(1); err != nil {
+			return err
+		}
+		r.frame.reset()
+		if err := r.zstd.Reset(r.frame); err != nil {
+			return err
+		}
+	}
+	last := r.checkpoints[len(r.checkpoints)-1]
+	if r.offset-last.Offset >= checkpointInterval {
+		r.checkpoints = append(r.checkpoints, Checkpoint{
+			Offset:           r.offset,
+			CompressedOffset: compressed,
+		})
+	}
+	return nil
+}
+
+// Checkpoints returns the checkpoints recorded so far.
+func (r *Reader) Checkpoints() []Checkpoint {
+	return r.checkpoints
+}
+
+// countingReader counts the bytes consumed from a bufio.Reader.  Since it
+// implements io.ByteReader, the gzip package reads from it directly without
+// buffering ahead so the count is exact at gzip member boundaries.  For zstd,
+// zstdFrame never reads past the end of a frame so the count is exact at frame
+// boundaries too.
+type countingReader struct {
+	*bufio.Reader
+	offset uint64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.Reader.Read(b)
+	c.offset += uint64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.Reader.ReadByte()
+	if err == nil {
+		c.offset++
+	}
+	return b, err
+}
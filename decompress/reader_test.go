@@ -0,0 +1,115 @@
+package decompress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testData() []byte {
+	var b bytes.Buffer
+	for i := 0; b.Len() < 64*1024; i++ {
+		b.WriteString("the quick brown fox jumps over the lazy dog ")
+		b.WriteByte(byte(i))
+	}
+	return b.Bytes()
+}
+
+func gzipMembers(t *testing.T, data []byte, size int) []byte {
+	var b bytes.Buffer
+	for len(data) > 0 {
+		n := min(size, len(data))
+		w := gzip.NewWriter(&b)
+		_, err := w.Write(data[:n])
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		data = data[n:]
+	}
+	return b.Bytes()
+}
+
+func TestReaderCheckpoints(t *testing.T) {
+	defer func(n uint64) { checkpointInterval = n }(checkpointInterval)
+	checkpointInterval = 10000
+	data := testData()
+	compressed := gzipMembers(t, data, 4096)
+	r, err := NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	assert.Equal(t, Gzip, r.Format)
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, data, out)
+	checkpoints := r.Checkpoints()
+	require.Greater(t, len(checkpoints), 1)
+	for _, c := range checkpoints[1:] {
+		assert.Zero(t, c.Offset%4096)
+	}
+	s := NewSeeker(bytes.NewReader(compressed), Gzip, checkpoints)
+	for _, off := range []int{30000, 100, 50000, 50001, 12288} {
+		_, err := s.Seek(int64(off), io.SeekStart)
+		require.NoError(t, err)
+		b := make([]byte, 10)
+		_, err = io.ReadFull(s, b)
+		require.NoError(t, err)
+		assert.Equal(t, data[off:off+10], b)
+	}
+}
+
+func TestReaderZstd(t *testing.T) {
+	data := testData()
+	enc, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	compressed := enc.EncodeAll(data, nil)
+	r, err := NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	assert.Equal(t, Zstd, r.Format)
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, data, out)
+	assert.Equal(t, []Checkpoint{{}}, r.Checkpoints())
+}
+
+func TestReaderZstdFrames(t *testing.T) {
+	defer func(n uint64) { checkpointInterval = n }(checkpointInterval)
+	checkpointInterval = 10000
+	data := testData()
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderCRC(true))
+	require.NoError(t, err)
+	var compressed []byte
+	for b := data; len(b) > 0; b = b[min(4096, len(b)):] {
+		compressed = enc.EncodeAll(b[:min(4096, len(b))], compressed)
+	}
+	r, err := NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, data, out)
+	checkpoints := r.Checkpoints()
+	require.Greater(t, len(checkpoints), 1)
+	for _, c := range checkpoints[1:] {
+		assert.Zero(t, c.Offset%4096)
+	}
+	s := NewSeeker(bytes.NewReader(compressed), Zstd, checkpoints)
+	for _, off := range []int{30000, 100, 50000, 50001, 12288} {
+		_, err := s.Seek(int64(off), io.SeekStart)
+		require.NoError(t, err)
+		b := make([]byte, 10)
+		_, err = io.ReadFull(s, b)
+		require.NoError(t, err)
+		assert.Equal(t, data[off:off+10], b)
+	}
+}
+
+func TestReaderNone(t *testing.T) {
+	r, err := NewReader(bytes.NewReader([]byte("abc")))
+	require.NoError(t, err)
+	assert.Equal(t, None, r.Format)
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(out))
+}
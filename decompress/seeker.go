@@ -0,0 +1,83 @@
+package decompress
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Seeker implements io.ReadSeeker over the decompressed form of a compressed
+// io.ReadSeeker.  A seek restarts decompression from the closest preceding
+// checkpoint and discards decompressed data up to the seek offset unless the
+// current position is already closer.
+type Seeker struct {
+	seeker      io.ReadSeeker
+	format      Format
+	checkpoints []Checkpoint
+	reader      io.Reader
+	offset      uint64
+}
+
+func NewSeeker(seeker io.ReadSeeker, format Format, checkpoints []Checkpoint) *Seeker {
+	if len(checkpoints) == 0 {
+		checkpoints = []Checkpoint{{}}
+	}
+	return &Seeker{
+		seeker:      seeker,
+		format:      format,
+		checkpoints: checkpoints,
+	}
+}
+
+func (s *Seeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += int64(s.offset)
+	default:
+		return 0, errors.New("decompress: seek relative to end not supported")
+	}
+	if offset < 0 {
+		return 0, errors.New("decompress: negative position")
+	}
+	off := uint64(offset)
+	i := sort.Search(len(s.checkpoints), func(i int) bool {
+		return s.checkpoints[i].Offset > off
+	})
+	if i == 0 {
+		return 0, fmt.Errorf("decompress: no checkpoint for offset %d", off)
+	}
+	c := s.checkpoints[i-1]
+	if s.reader == nil || s.offset > off || s.offset < c.Offset {
+		if _, err := s.seeker.Seek(int64(c.CompressedOffset), io.SeekStart); err != nil {
+			return 0, err
+		}
+		reader, err := newDecompressor(s.format, s.seeker)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", s.format, err)
+		}
+		s.reader = reader
+		s.offset = c.Offset
+	}
+	n, err := io.CopyN(io.Discard, s.reader, int64(off-s.offset))
+	s.offset += uint64(n)
+	if err != nil && err != io.EOF {
+		return int64(s.offset), fmt.Errorf("%s: %w", s.format, err)
+	}
+	return int64(s.offset), nil
+}
+
+func (s *Seeker) Read(b []byte) (int, error) {
+	if s.reader == nil {
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+	}
+	n, err := s.reader.Read(b)
+	s.offset += uint64(n)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%s: %w", s.format, err)
+	}
+	return n, err
+}
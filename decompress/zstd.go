@@ -0,0 +1,125 @@
+package decompress
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	zstdMagic              = 0xfd2fb528
+	zstdSkippableMagicMask = 0xfffffff0
+	zstdSkippableMagic     = 0x184d2a50
+)
+
+const (
+	zstdFrameHeader = iota
+	zstdFrameBlock
+	zstdFrameChecksum
+	zstdFrameEnd
+)
+
+// zstdFrame is an io.Reader over a single zstd frame (or skippable frame) of
+// the underlying stream.  It parses just enough of the frame and block headers
+// to return io.EOF at the end of the frame so the zstd decoder stops exactly
+// at frame boundaries, which lets Reader record them as checkpoints the same
+// way it does gzip member boundaries.
+type zstdFrame struct {
+	reader    *countingReader
+	state     int
+	checksum  bool
+	remaining int
+}
+
+func (z *zstdFrame) reset() {
+	z.state = zstdFrameHeader
+	z.remaining = 0
+}
+
+func (z *zstdFrame) Read(b []byte) (int, error) {
+	for z.remaining == 0 {
+		if err := z.next(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := z.reader.Read(b[:min(len(b), z.remaining)])
+	z.remaining -= n
+	if err == io.EOF && z.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// next computes the length of the next piece of the frame, which is either
+// the frame header, a block, or the content checksum.
+func (z *zstdFrame) next() error {
+	switch z.state {
+	case zstdFrameHeader:
+		hdr, err := z.reader.Peek(5)
+		if err != nil {
+			if err == io.EOF && len(hdr) == 0 {
+				return io.EOF
+			}
+			return io.ErrUnexpectedEOF
+		}
+		magic := binary.LittleEndian.Uint32(hdr)
+		if magic&zstdSkippableMagicMask == zstdSkippableMagic {
+			if hdr, err = z.reader.Peek(8); err != nil {
+				return io.ErrUnexpectedEOF
+			}
+			z.remaining = 8 + int(binary.LittleEndian.Uint32(hdr[4:8]))
+			z.state = zstdFrameEnd
+			return nil
+		}
+		if magic != zstdMagic {
+			return fmt.Errorf("invalid frame magic %#x", magic)
+		}
+		descriptor := hdr[4]
+		singleSegment := descriptor&0x20 != 0
+		n := 5
+		if !singleSegment {
+			// window descriptor
+			n++
+		}
+		n += [4]int{0, 1, 2, 4}[descriptor&3]
+		switch descriptor >> 6 {
+		case 0:
+			if singleSegment {
+				n++
+			}
+		case 1:
+			n += 2
+		case 2:
+			n += 4
+		case 3:
+			n += 8
+		}
+		z.checksum = descriptor&0x04 != 0
+		z.remaining = n
+		z.state = zstdFrameBlock
+	case zstdFrameBlock:
+		hdr, err := z.reader.Peek(3)
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		h := uint32(hdr[0]) | uint32(hdr[1])<<8 | uint32(hdr[2])<<16
+		size := int(h >> 3)
+		if (h>>1)&3 == 1 {
+			// RLE blocks hold a single byte.
+			size = 1
+		}
+		z.remaining = 3 + size
+		if h&1 != 0 {
+			z.state = zstdFrameEnd
+			if z.checksum {
+				z.state = zstdFrameChecksum
+			}
+		}
+	case zstdFrameChecksum:
+		z.remaining = 4
+		z.state = zstdFrameEnd
+	case zstdFrameEnd:
+		return io.EOF
+	}
+	return nil
+}
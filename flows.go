@@ -0,0 +1,266 @@
+package brimcap
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+
+	"github.com/brimdata/zed"
+	"github.com/brimdata/zed/pkg/field"
+	"github.com/brimdata/zed/pkg/nano"
+	"github.com/brimdata/zed/zio"
+	"github.com/brimdata/zed/zson"
+)
+
+// FieldMapping names the fields of the records, such as Zeek conn records,
+// that give the connection searched for by Search.  Each is a dotted path
+// into the record.  Values may be of their Zed type or, as in records read
+// from JSON, strings or numbers, where numeric times and durations are in
+// seconds.
+type FieldMapping struct {
+	// Ts is the start time of the connection.
+	Ts string `yaml:"ts,omitempty"`
+	// End, if set, is the end time of the connection.  Otherwise,
+	// Duration, if set, is its duration.
+	End      string `yaml:"end,omitempty"`
+	Duration string `yaml:"duration,omitempty"`
+	// Proto is either the name of the transport protocol (e.g., "tcp")
+	// or its IP protocol number.
+	Proto   string `yaml:"proto,omitempty"`
+	SrcAddr string `yaml:"src_addr,omitempty"`
+	SrcPort string `yaml:"src_port,omitempty"`
+	DstAddr string `yaml:"dst_addr,omitempty"`
+	DstPort string `yaml:"dst_port,omitempty"`
+	// CommunityID identifies the connection of records without address
+	// fields.
+	CommunityID string `yaml:"community_id,omitempty"`
+	// UID is a unique identifier of the connection, like the uid field
+	// of Zeek records.
+	UID string `yaml:"uid,omitempty"`
+}
+
+// FieldMappings holds the built-in field mappings of Zeek conn records,
+// Suricata records (as shaped by brimcap analyze), and nfdump records (as
+// shaped by examples/nfdump.yml).
+var FieldMappings = map[string]FieldMapping{
+	"zeek": {
+		Ts:          "ts",
+		Duration:    "duration",
+		Proto:       "proto",
+		SrcAddr:     "id.orig_h",
+		SrcPort:     "id.orig_p",
+		DstAddr:     "id.resp_h",
+		DstPort:     "id.resp_p",
+		CommunityID: "community_id",
+		UID:         "uid",
+	},
+	// An alert's ts is when it fired, so the search runs from the start
+	// of its flow to then.
+	"suricata": {
+		Ts:          "flow.start",
+		End:         "ts",
+		Proto:       "proto",
+		SrcAddr:     "src_ip",
+		SrcPort:     "src_port",
+		DstAddr:     "dest_ip",
+		DstPort:     "dest_port",
+		CommunityID: "community_id",
+	},
+	"nfdump": {
+		Ts:      "first",
+		End:     "last",
+		Proto:   "proto",
+		SrcAddr: "src4_addr",
+		SrcPort: "src_port",
+		DstAddr: "dst4_addr",
+		DstPort: "dst_port",
+	},
+}
+
+func (m FieldMapping) validate() error {
+	if m.Ts == "" {
+		return errors.New("ts field must be set")
+	}
+	if (m.SrcAddr == "" || m.DstAddr == "" || m.Proto == "") && m.CommunityID == "" {
+		return errors.New("src_addr, dst_addr, and proto fields or community_id field must be set")
+	}
+	return nil
+}
+
+// ReadSearches returns a search for each record read from r (see
+// FieldMapping.Search).  If uid is not empty, only records whose UID field
+// is uid are searched for.
+func ReadSearches(r zio.Reader, m FieldMapping, uid string, template Search) ([]Search, error) {
+	var searches []Search
+	for {
+		val, err := r.Read()
+		if val == nil || err != nil {
+			return searches, err
+		}
+		if uid != "" && m.uid(val) != uid {
+			continue
+		}
+		search, err := m.Search(val, template)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, zson.FormatValue(*val))
+		}
+		searches = append(searches, search)
+	}
+}
+
+func (m FieldMapping) uid(val *zed.Value) string {
+	if m.UID == "" {
+		return ""
+	}
+	return derefPath(val, m.UID).AsString()
+}
+
+// Search returns a copy of template searching for the connection of val.
+// A record without address fields is searched for by its Community ID.
+func (m FieldMapping) Search(val *zed.Value, template Search) (Search, error) {
+	search := template
+	ts, ok := derefTime(derefPath(val, m.Ts))
+	if !ok {
+		return search, fmt.Errorf("record has no %s time field", m.Ts)
+	}
+	search.Span = nano.Span{Ts: ts, Dur: 1}
+	if end, ok := derefTime(derefPath(val, m.End)); ok {
+		search.Span = nano.NewSpanTs(ts, max(end, ts+1))
+	} else if dur, ok := derefDuration(derefPath(val, m.Duration)); ok {
+		search.Span.Dur = max(dur, 1)
+	}
+	search.SrcIP = derefIP(derefPath(val, m.SrcAddr))
+	search.DstIP = derefIP(derefPath(val, m.DstAddr))
+	if search.SrcIP == nil || search.DstIP == nil {
+		search.CommunityID = derefPath(val, m.CommunityID).AsString()
+		if search.CommunityID == "" {
+			return search, fmt.Errorf("record has neither %s and %s address fields nor %s field", m.SrcAddr, m.DstAddr, m.CommunityID)
+		}
+		return search, nil
+	}
+	proto, ok := derefProto(derefPath(val, m.Proto))
+	if !ok {
+		return search, fmt.Errorf("record has no supported %s field", m.Proto)
+	}
+	search.Proto = proto
+	sport, sok := derefUint16(derefPath(val, m.SrcPort))
+	dport, dok := derefUint16(derefPath(val, m.DstPort))
+	switch {
+	case proto != "icmp":
+		if !sok || !dok {
+			return search, fmt.Errorf("record has no %s and %s port fields", m.SrcPort, m.DstPort)
+		}
+		search.SrcPort, search.DstPort = sport, dport
+	case sok && dok:
+		// Zeek's ICMP ports are the message type and code.
+		search.ICMPType, search.ICMPCode = ptr(uint8(sport)), uint8(dport)
+	}
+	return search, nil
+}
+
+func derefPath(val *zed.Value, path string) *zed.Value {
+	if path == "" {
+		return nil
+	}
+	return val.DerefPath(field.Dotted(path))
+}
+
+func derefTime(val *zed.Value) (nano.Ts, bool) {
+	if val == nil {
+		return 0, false
+	}
+	switch typ := zed.TypeUnder(val.Type()); {
+	case typ == zed.TypeTime:
+		return val.AsTime(), true
+	case typ == zed.TypeString:
+		ts, err := nano.ParseRFC3339Nano([]byte(val.AsString()))
+		return ts, err == nil
+	case zed.IsNumber(typ.ID()):
+		return nano.Ts(derefSeconds(val)), true
+	}
+	return 0, false
+}
+
+func derefDuration(val *zed.Value) (nano.Duration, bool) {
+	if val == nil {
+		return 0, false
+	}
+	switch typ := zed.TypeUnder(val.Type()); {
+	case typ == zed.TypeDuration:
+		return zed.DecodeDuration(val.Bytes()), true
+	case zed.IsNumber(typ.ID()):
+		return nano.Duration(derefSeconds(val)), true
+	}
+	return 0, false
+}
+
+// derefSeconds returns a number of seconds in nanoseconds.  Since a float64
+// number of seconds since the epoch isn't precise to the nanosecond, it is
+// rounded to the microsecond, which is the precision of Zeek's JSON logs.
+func derefSeconds(val *zed.Value) int64 {
+	return int64(math.Round(derefFloat(val)*1e6)) * 1000
+}
+
+func derefFloat(val *zed.Value) float64 {
+	if zed.IsFloat(zed.TypeUnder(val.Type()).ID()) {
+		return val.Float()
+	}
+	return float64(val.AsInt())
+}
+
+func derefUint16(val *zed.Value) (uint16, bool) {
+	if val == nil || !zed.IsNumber(zed.TypeUnder(val.Type()).ID()) {
+		return 0, false
+	}
+	n := derefFloat(val)
+	if n < 0 || n > math.MaxUint16 {
+		return 0, false
+	}
+	return uint16(n), true
+}
+
+func derefIP(val *zed.Value) net.IP {
+	if val == nil {
+		return nil
+	}
+	switch zed.TypeUnder(val.Type()) {
+	case zed.TypeIP:
+		return net.IP(zed.DecodeIP(val.Bytes()).AsSlice())
+	case zed.TypeString:
+		return net.ParseIP(val.AsString())
+	}
+	return nil
+}
+
+// derefProto returns the name of the protocol of a Search.Proto given by
+// name or IP protocol number.
+func derefProto(val *zed.Value) (string, bool) {
+	if val == nil {
+		return "", false
+	}
+	var proto string
+	switch typ := zed.TypeUnder(val.Type()); {
+	case typ == zed.TypeString:
+		proto = strings.ToLower(val.AsString())
+	case zed.IsInteger(typ.ID()):
+		proto = protoNames[val.AsInt()]
+	}
+	switch proto {
+	case "icmp6", "icmpv6", "ipv6-icmp":
+		// ICMP searches match both ICMP and ICMPv6.
+		proto = "icmp"
+	}
+	switch proto {
+	case "tcp", "udp", "sctp", "icmp":
+		return proto, true
+	}
+	return "", false
+}
+
+var protoNames = map[int64]string{1: "icmp", 6: "tcp", 17: "udp", 58: "icmp", 132: "sctp"}
+
+func ptr[T any](v T) *T {
+	return &v
+}
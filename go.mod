@@ -7,7 +7,9 @@ require (
 	github.com/fsnotify/fsnotify v1.4.9
 	github.com/gopacket/gopacket v1.2.0
 	github.com/gosuri/uilive v0.0.4
+	github.com/klauspost/compress v1.16.7
 	github.com/stretchr/testify v1.8.4
+	github.com/ulikunitz/xz v0.5.12
 	go.uber.org/multierr v1.8.0
 	golang.org/x/sync v0.4.0
 	golang.org/x/term v0.13.0
@@ -33,7 +35,6 @@ require (
 	github.com/hashicorp/golang-lru/v2 v2.0.1 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/klauspost/asmfmt v1.3.2 // indirect
-	github.com/klauspost/compress v1.16.7 // indirect
 	github.com/klauspost/cpuid/v2 v2.2.5 // indirect
 	github.com/kr/pretty v0.3.0 // indirect
 	github.com/kr/text v0.2.0 // indirect
@@ -0,0 +1,83 @@
+// Package mmap provides read-only access to files through memory mappings
+// where the platform supports them.
+package mmap
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// File is a read-only file whose contents are mapped into memory.  If the
+// file cannot be mapped (e.g., it is empty or the platform does not support
+// mappings), File falls back to reading the file with ReadAt and Bytes
+// returns nil.
+//
+// Since the mapping reflects the file, truncating a mapped file while it is
+// being read may crash the program on some platforms.
+type File struct {
+	file *os.File
+	data []byte
+	size int64
+}
+
+// Open opens the named file for reading and maps it into memory.
+func Open(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	size := info.Size()
+	file := &File{file: f, size: size}
+	if size > 0 && int64(int(size)) == size {
+		// A file that can't be mapped is read with ReadAt instead.
+		file.data, _ = mmap(f, int(size))
+	}
+	return file, nil
+}
+
+// Bytes returns the contents of the file or nil if the file is not mapped.
+// The returned slice is valid until Close is called.
+func (f *File) Bytes() []byte {
+	return f.data
+}
+
+// Size returns the size of the file when it was opened.
+func (f *File) Size() int64 {
+	return f.size
+}
+
+func (f *File) ReadAt(b []byte, off int64) (int, error) {
+	if f.data == nil {
+		return f.file.ReadAt(b, off)
+	}
+	if off < 0 {
+		return 0, errors.New("mmap: negative offset")
+	}
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close unmaps and closes the file.
+func (f *File) Close() error {
+	var err error
+	if f.data != nil {
+		err = munmap(f.data)
+		f.data = nil
+	}
+	if cerr := f.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
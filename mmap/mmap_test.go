@@ -0,0 +1,38 @@
+package mmap
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(path, []byte("0123456789"), 0600))
+	f, err := Open(path)
+	require.NoError(t, err)
+	assert.EqualValues(t, 10, f.Size())
+	b := make([]byte, 4)
+	n, err := f.ReadAt(b, 3)
+	require.NoError(t, err)
+	assert.Equal(t, "3456", string(b[:n]))
+	n, err = f.ReadAt(b, 8)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, "89", string(b[:n]))
+	require.NoError(t, f.Close())
+}
+
+func TestEmptyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty")
+	require.NoError(t, os.WriteFile(path, nil, 0600))
+	f, err := Open(path)
+	require.NoError(t, err)
+	assert.Nil(t, f.Bytes())
+	_, err = f.ReadAt(make([]byte, 1), 0)
+	assert.ErrorIs(t, err, io.EOF)
+	require.NoError(t, f.Close())
+}
@@ -0,0 +1,17 @@
+package mmap
+
+import (
+	"errors"
+	"os"
+)
+
+// Files are not mapped on Windows since a mapped file cannot be deleted or
+// renamed there, which would interfere with pcap rotation.
+
+func mmap(*os.File, int) ([]byte, error) {
+	return nil, errors.New("mmap: not supported on windows")
+}
+
+func munmap([]byte) error {
+	return nil
+}
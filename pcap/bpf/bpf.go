@@ -0,0 +1,61 @@
+// Package bpf compiles packet filter expressions in the syntax of
+// pcap-filter(7), as used by tcpdump and the Berkeley Packet Filter, into
+// matchers that run on packets decoded by gopacket.  Expressions are
+// evaluated against a packet's decoded layers rather than compiled to BPF
+// instructions so no libpcap is needed.
+//
+// The supported primitives are
+//
+//	[ip|ip6|arp|rarp] [src|dst|src or dst|src and dst] host ADDR
+//	ether [src|dst|...] host MAC
+//	[ip|ip6|arp|rarp] [src|dst|...] net NET[/LEN] | net NET mask MASK
+//	[tcp|udp|sctp] [src|dst|...] port PORT | portrange PORT-PORT
+//	ip|ip6|arp|rarp|tcp|udp|sctp|icmp|icmp6|igmp
+//	[ip|ip6|ether] proto PROTO
+//	vlan [ID] | mpls [LABEL]
+//	[ether] broadcast | [ether|ip|ip6] multicast
+//	less LEN | greater LEN
+//	EXPR RELOP EXPR
+//
+// where the arithmetic expressions compared by RELOP (>, <, >=, <=, =, ==,
+// or !=) are built from numbers, len, the named offsets and values of
+// pcap-filter(7) (e.g., tcpflags and tcp-syn), and packet data accessors
+// like tcp[13] or ip[2:2] using the operators +, -, *, /, %, &, |, ^, <<,
+// and >>.  Primitives are combined with and (&&), or (||), not (!), and
+// parentheses.  As in tcpdump, a bare address or port following and or or
+// takes the qualifiers of the preceding primitive, so "host a or b" means
+// "host a or host b".
+//
+// Unlike BPF programs, matchers find layers anywhere in the decoded packet,
+// so for instance "host 10.0.0.1" matches VLAN-tagged packets without a
+// preceding vlan primitive.  Host names and service names are resolved when
+// the expression is compiled.
+package bpf
+
+import (
+	"strings"
+
+	"github.com/gopacket/gopacket"
+)
+
+// Compile compiles a filter expression into a function reporting whether a
+// packet matches it.  As with tcpdump, an empty expression matches every
+// packet.
+func Compile(expr string) (func(gopacket.Packet) bool, error) {
+	if strings.TrimSpace(expr) == "" {
+		return func(gopacket.Packet) bool { return true }, nil
+	}
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	m, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, p.unexpected(tok)
+	}
+	return m, nil
+}
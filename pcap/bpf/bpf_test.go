@@ -0,0 +1,129 @@
+package bpf_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/brimdata/brimcap/pcap/bpf"
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	mac0 = net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	mac1 = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+)
+
+func newPacket(t *testing.T, ls ...gopacket.SerializableLayer) gopacket.Packet {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	for _, l := range ls {
+		if nl, ok := l.(gopacket.NetworkLayer); ok {
+			for _, l := range ls {
+				switch l := l.(type) {
+				case *layers.TCP:
+					require.NoError(t, l.SetNetworkLayerForChecksum(nl))
+				case *layers.UDP:
+					require.NoError(t, l.SetNetworkLayerForChecksum(nl))
+				}
+			}
+		}
+	}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, ls...))
+	return gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+}
+
+func TestCompile(t *testing.T) {
+	tcp4 := newPacket(t,
+		&layers.Ethernet{SrcMAC: mac0, DstMAC: mac1, EthernetType: layers.EthernetTypeIPv4},
+		&layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 5}},
+		&layers.TCP{SrcPort: 1234, DstPort: 80, SYN: true},
+	)
+	udp4 := newPacket(t,
+		&layers.Ethernet{SrcMAC: mac0, DstMAC: mac0, EthernetType: layers.EthernetTypeDot1Q},
+		&layers.Dot1Q{VLANIdentifier: 100, Type: layers.EthernetTypeIPv4},
+		&layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{192, 168, 1, 2}, DstIP: net.IP{8, 8, 8, 8}},
+		&layers.UDP{SrcPort: 5353, DstPort: 53},
+		gopacket.Payload("query"),
+	)
+	tcp6 := newPacket(t,
+		&layers.Ethernet{SrcMAC: mac0, DstMAC: mac0, EthernetType: layers.EthernetTypeIPv6},
+		&layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolTCP, SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("ff02::1")},
+		&layers.TCP{SrcPort: 443, DstPort: 50000, ACK: true},
+	)
+	arp := newPacket(t,
+		&layers.Ethernet{SrcMAC: mac0, DstMAC: mac1, EthernetType: layers.EthernetTypeARP},
+		&layers.ARP{AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4, HwAddressSize: 6, ProtAddressSize: 4,
+			Operation: 1, SourceHwAddress: mac0, SourceProtAddress: []byte{10, 0, 0, 1}, DstHwAddress: mac1, DstProtAddress: []byte{10, 0, 0, 5}},
+	)
+	packets := []gopacket.Packet{tcp4, udp4, tcp6, arp}
+	cases := []struct {
+		expr     string
+		expected []bool
+	}{
+		{"", []bool{true, true, true, true}},
+		{"host 10.0.0.5", []bool{true, false, false, true}},
+		{"ip host 10.0.0.5", []bool{true, false, false, false}},
+		{"src host 10.0.0.5", []bool{false, false, false, false}},
+		{"dst host 10.0.0.5 and tcp", []bool{true, false, false, false}},
+		{"host 10.0.0.1 or 2001:db8::1", []bool{true, false, true, true}},
+		{"net 192.168.0.0/16", []bool{false, true, false, false}},
+		{"src net 192.168", []bool{false, true, false, false}},
+		{"net 10.0.0.0 mask 255.255.255.0", []bool{true, false, false, true}},
+		{"net 2001:db8::/32", []bool{false, false, true, false}},
+		{"port 80 or 53", []bool{true, true, false, false}},
+		{"tcp port 53", []bool{false, false, false, false}},
+		{"udp dst port domain", []bool{false, true, false, false}},
+		{"src or dst port 443", []bool{false, false, true, false}},
+		{"portrange 1000-2000", []bool{true, false, false, false}},
+		{"tcp", []bool{true, false, true, false}},
+		{"ip and not udp", []bool{true, false, false, false}},
+		{"ip6", []bool{false, false, true, false}},
+		{"arp", []bool{false, false, false, true}},
+		{"ip proto \\udp", []bool{false, true, false, false}},
+		{"ip6 proto 6", []bool{false, false, true, false}},
+		{"ether proto ip6", []bool{false, false, true, false}},
+		{"ether src 00:01:02:03:04:05 and broadcast", []bool{true, false, false, true}},
+		{"ip6 multicast", []bool{false, false, true, false}},
+		{"vlan 100 and udp", []bool{false, true, false, false}},
+		{"vlan 200", []bool{false, false, false, false}},
+		{"tcp[tcpflags] & tcp-syn != 0", []bool{true, false, false, false}},
+		{"tcp[13] & (tcp-syn|tcp-ack) == tcp-ack", []bool{false, false, true, false}},
+		{"ip[9] = 17", []bool{false, true, false, false}},
+		{"udp[8:4] = 0x71756572", []bool{false, true, false, false}},
+		{"udp[100] = 0", []bool{false, false, false, false}},
+		{"(len - 14) / 2 >= 20 && !arp", []bool{true, true, true, false}},
+		{"less 60", []bool{true, true, false, true}},
+		{"greater 70", []bool{false, false, true, false}},
+		{"not (host 10.0.0.1 || vlan)", []bool{false, false, true, false}},
+	}
+	for _, c := range cases {
+		match, err := bpf.Compile(c.expr)
+		require.NoError(t, err, "expr %q", c.expr)
+		for i, pkt := range packets {
+			assert.Equal(t, c.expected[i], match(pkt), "expr %q packet %d", c.expr, i)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, expr := range []string{
+		"host",
+		"host 10.0.0.1 and",
+		"port 99999",
+		"tcp host 10.0.0.1",
+		"net 10.0.0.1/8",
+		"tcp[13:3] = 0",
+		"ether host 10.0.0.1",
+		"(tcp",
+		"len > ",
+		"foo[0] = 1",
+		"ip broadcast",
+		"host 10.0.0.1 $",
+	} {
+		_, err := bpf.Compile(expr)
+		assert.Error(t, err, "expr %q", expr)
+	}
+}
@@ -0,0 +1,104 @@
+package bpf
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	// tokenWord is a keyword, number, address, or name.
+	tokenWord
+	// tokenOp is an operator or punctuation.
+	tokenOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) is(text string) bool {
+	return t.kind != tokenEOF && t.text == text
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// Operators are matched longest first.
+var operators = []string{
+	"&&", "||", "==", "!=", ">=", "<=", "<<", ">>",
+	"(", ")", "[", "]", "!", ">", "<", "=", "+", "-", "*", "/", "%", "&", "|", "^", ":",
+}
+
+// lex splits expr into tokens.  A word begins with a letter, digit,
+// backslash (which escapes a protocol name, as in "ip proto \tcp"), or, for
+// IPv6 addresses like "::1", a colon.  Words may hold dots and colons so that
+// addresses and MACs are single words, except between brackets where a colon
+// separates an offset from a size.  Words beginning with a letter may also
+// hold hyphens (e.g., "tcp-syn") so subtraction from a name needs spaces.
+func lex(expr string) ([]token, error) {
+	var tokens []token
+	var depth int
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		if unicode.IsSpace(c) {
+			i++
+			continue
+		}
+		if isWordStart(c) || (c == ':' && depth == 0 && i+1 < len(expr) && expr[i+1] == ':') {
+			j := i + 1
+			for j < len(expr) && isWordChar(rune(expr[j]), depth, unicode.IsLetter(rune(expr[i]))) {
+				j++
+			}
+			tokens = append(tokens, token{tokenWord, expr[i:j], i})
+			i = j
+			continue
+		}
+		var op string
+		for _, o := range operators {
+			if strings.HasPrefix(expr[i:], o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return nil, fmt.Errorf("invalid filter expression: unexpected character %q", c)
+		}
+		switch op {
+		case "[":
+			depth++
+		case "]":
+			depth--
+		}
+		tokens = append(tokens, token{tokenOp, op, i})
+		i += len(op)
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(expr)}), nil
+}
+
+func isWordStart(c rune) bool {
+	return c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c) || c == '\\')
+}
+
+func isWordChar(c rune, depth int, name bool) bool {
+	switch {
+	case c >= unicode.MaxASCII:
+		return false
+	case unicode.IsLetter(c), unicode.IsDigit(c), c == '.', c == '_':
+		return true
+	case c == ':':
+		return depth == 0
+	case c == '-':
+		return name
+	}
+	return false
+}
@@ -0,0 +1,349 @@
+package bpf
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+func and(a, b matcher) matcher {
+	return func(pkt gopacket.Packet) bool { return a(pkt) && b(pkt) }
+}
+
+func or(a, b matcher) matcher {
+	return func(pkt gopacket.Packet) bool { return a(pkt) || b(pkt) }
+}
+
+// matchDir combines the results of matching a packet's source and
+// destination according to the direction qualifier dir.
+func matchDir(dir string, src, dst bool) bool {
+	switch dir {
+	case "src":
+		return src
+	case "dst":
+		return dst
+	case "src and dst":
+		return src && dst
+	}
+	return src || dst
+}
+
+// packetLen returns the length of the packet on the wire if known and
+// otherwise its captured length.
+func packetLen(pkt gopacket.Packet) uint32 {
+	if md := pkt.Metadata(); md != nil && md.Length > 0 {
+		return uint32(md.Length)
+	}
+	return uint32(len(pkt.Data()))
+}
+
+var broadcastMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+func castMatcher(proto, cast string) matcher {
+	switch proto {
+	case "ip":
+		return func(pkt gopacket.Packet) bool {
+			ip, ok := pkt.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+			return ok && ip.DstIP.IsMulticast()
+		}
+	case "ip6":
+		return func(pkt gopacket.Packet) bool {
+			ip, ok := pkt.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+			return ok && ip.DstIP.IsMulticast()
+		}
+	}
+	return func(pkt gopacket.Packet) bool {
+		eth, ok := pkt.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+		if !ok {
+			return false
+		}
+		if cast == "broadcast" {
+			return bytes.Equal(eth.DstMAC, broadcastMAC)
+		}
+		return len(eth.DstMAC) > 0 && eth.DstMAC[0]&1 != 0
+	}
+}
+
+func vlanMatcher(id *uint32) matcher {
+	return func(pkt gopacket.Packet) bool {
+		tag, ok := pkt.Layer(layers.LayerTypeDot1Q).(*layers.Dot1Q)
+		return ok && (id == nil || uint32(tag.VLANIdentifier) == *id)
+	}
+}
+
+func mplsMatcher(label *uint32) matcher {
+	return func(pkt gopacket.Packet) bool {
+		mpls, ok := pkt.Layer(layers.LayerTypeMPLS).(*layers.MPLS)
+		return ok && (label == nil || mpls.Label == *label)
+	}
+}
+
+func etherHostMatcher(dir string, mac net.HardwareAddr) matcher {
+	return func(pkt gopacket.Packet) bool {
+		eth, ok := pkt.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+		return ok && matchDir(dir, bytes.Equal(eth.SrcMAC, mac), bytes.Equal(eth.DstMAC, mac))
+	}
+}
+
+// addresses returns the source and destination addresses of the packet for
+// proto, which is empty to match either IPv4, IPv6, or ARP.
+func addresses(pkt gopacket.Packet, proto string) (net.IP, net.IP, bool) {
+	if proto == "" || proto == "ip" {
+		if ip, ok := pkt.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok {
+			return ip.SrcIP, ip.DstIP, true
+		}
+	}
+	if proto == "" || proto == "ip6" {
+		if ip, ok := pkt.Layer(layers.LayerTypeIPv6).(*layers.IPv6); ok {
+			return ip.SrcIP, ip.DstIP, true
+		}
+	}
+	if proto == "" || proto == "arp" || proto == "rarp" {
+		if arp, ok := pkt.Layer(layers.LayerTypeARP).(*layers.ARP); ok && (proto == "" || isRARP(arp) == (proto == "rarp")) {
+			return net.IP(arp.SourceProtAddress), net.IP(arp.DstProtAddress), true
+		}
+	}
+	return nil, nil, false
+}
+
+// isRARP returns true for reverse ARP operations (3 and 4).
+func isRARP(arp *layers.ARP) bool {
+	return arp.Operation == 3 || arp.Operation == 4
+}
+
+func netMatcher(proto, dir string, nets ...*net.IPNet) matcher {
+	contains := func(ip net.IP) bool {
+		for _, n := range nets {
+			if len(ip) == len(n.IP) && n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(pkt gopacket.Packet) bool {
+		src, dst, ok := addresses(pkt, proto)
+		return ok && matchDir(dir, contains(src), contains(dst))
+	}
+}
+
+// ports returns the source and destination ports of the packet for proto,
+// which is empty to match either TCP, UDP, or SCTP.
+func ports(pkt gopacket.Packet, proto string) (uint16, uint16, bool) {
+	if proto == "" || proto == "tcp" {
+		if tcp, ok := pkt.Layer(layers.LayerTypeTCP).(*layers.TCP); ok {
+			return uint16(tcp.SrcPort), uint16(tcp.DstPort), true
+		}
+	}
+	if proto == "" || proto == "udp" {
+		if udp, ok := pkt.Layer(layers.LayerTypeUDP).(*layers.UDP); ok {
+			return uint16(udp.SrcPort), uint16(udp.DstPort), true
+		}
+	}
+	if proto == "" || proto == "sctp" {
+		if sctp, ok := pkt.Layer(layers.LayerTypeSCTP).(*layers.SCTP); ok {
+			return uint16(sctp.SrcPort), uint16(sctp.DstPort), true
+		}
+	}
+	return 0, 0, false
+}
+
+func portMatcher(proto, dir string, lo, hi uint16) matcher {
+	return func(pkt gopacket.Packet) bool {
+		src, dst, ok := ports(pkt, proto)
+		return ok && matchDir(dir, lo <= src && src <= hi, lo <= dst && dst <= hi)
+	}
+}
+
+var etherTypes = map[string]uint16{
+	"ip":   uint16(layers.EthernetTypeIPv4),
+	"ip6":  uint16(layers.EthernetTypeIPv6),
+	"arp":  uint16(layers.EthernetTypeARP),
+	"rarp": 0x8035,
+	"vlan": uint16(layers.EthernetTypeDot1Q),
+	"mpls": uint16(layers.EthernetTypeMPLSUnicast),
+}
+
+func etherProtoMatcher(typ uint16) matcher {
+	return func(pkt gopacket.Packet) bool {
+		switch link := pkt.LinkLayer().(type) {
+		case *layers.Ethernet:
+			return uint16(link.EthernetType) == typ
+		case *layers.LinuxSLL:
+			return uint16(link.EthernetType) == typ
+		}
+		return false
+	}
+}
+
+var ipProtos = map[string]uint8{
+	"icmp":  uint8(layers.IPProtocolICMPv4),
+	"igmp":  uint8(layers.IPProtocolIGMP),
+	"tcp":   uint8(layers.IPProtocolTCP),
+	"udp":   uint8(layers.IPProtocolUDP),
+	"gre":   uint8(layers.IPProtocolGRE),
+	"esp":   uint8(layers.IPProtocolESP),
+	"ah":    uint8(layers.IPProtocolAH),
+	"icmp6": uint8(layers.IPProtocolICMPv6),
+	"pim":   103,
+	"vrrp":  112,
+	"sctp":  uint8(layers.IPProtocolSCTP),
+}
+
+// ipProtoMatcher matches the protocol field of IPv4 or the next header field
+// of IPv6 (for proto "ip6" or "" and like tcpdump, without following the
+// chain of extension headers).
+func ipProtoMatcher(proto string, n uint8) matcher {
+	return func(pkt gopacket.Packet) bool {
+		if proto == "" || proto == "ip" {
+			if ip, ok := pkt.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok && uint8(ip.Protocol) == n {
+				return true
+			}
+		}
+		if proto == "" || proto == "ip6" {
+			if ip, ok := pkt.Layer(layers.LayerTypeIPv6).(*layers.IPv6); ok && uint8(ip.NextHeader) == n {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// protoMatcher matches a protocol primitive.  Transport protocols match
+// either on the IPv4 protocol field, so that fragments match, or on the
+// decoded layer, so that IPv6 extension headers are followed.
+func protoMatcher(proto string) matcher {
+	switch proto {
+	case "ip":
+		return hasLayer(layers.LayerTypeIPv4)
+	case "ip6":
+		return hasLayer(layers.LayerTypeIPv6)
+	case "arp", "rarp":
+		rarp := proto == "rarp"
+		return func(pkt gopacket.Packet) bool {
+			arp, ok := pkt.Layer(layers.LayerTypeARP).(*layers.ARP)
+			return ok && isRARP(arp) == rarp
+		}
+	}
+	m := ipProtoMatcher("ip", ipProtos[proto])
+	var typ gopacket.LayerType
+	switch proto {
+	case "tcp":
+		typ = layers.LayerTypeTCP
+	case "udp":
+		typ = layers.LayerTypeUDP
+	case "sctp":
+		typ = layers.LayerTypeSCTP
+	case "icmp":
+		typ = layers.LayerTypeICMPv4
+	case "icmp6":
+		typ = layers.LayerTypeICMPv6
+	case "igmp":
+		typ = layers.LayerTypeIGMP
+	}
+	return or(m, hasLayer(typ))
+}
+
+func hasLayer(typ gopacket.LayerType) matcher {
+	return func(pkt gopacket.Packet) bool {
+		return pkt.Layer(typ) != nil
+	}
+}
+
+var constants = map[string]uint32{
+	"tcpflags":                 13,
+	"tcp-fin":                  0x01,
+	"tcp-syn":                  0x02,
+	"tcp-rst":                  0x04,
+	"tcp-push":                 0x08,
+	"tcp-ack":                  0x10,
+	"tcp-urg":                  0x20,
+	"tcp-ece":                  0x40,
+	"tcp-cwr":                  0x80,
+	"icmptype":                 0,
+	"icmpcode":                 1,
+	"icmp-echoreply":           0,
+	"icmp-unreach":             3,
+	"icmp-sourcequench":        4,
+	"icmp-redirect":            5,
+	"icmp-echo":                8,
+	"icmp-routeradvert":        9,
+	"icmp-routersolicit":       10,
+	"icmp-timxceed":            11,
+	"icmp-paramprob":           12,
+	"icmp-tstamp":              13,
+	"icmp-tstampreply":         14,
+	"icmp-ireq":                15,
+	"icmp-ireqreply":           16,
+	"icmp-maskreq":             17,
+	"icmp-maskreply":           18,
+	"icmp6type":                0,
+	"icmp6code":                1,
+	"icmp6-destinationunreach": 1,
+	"icmp6-packettoobig":       2,
+	"icmp6-timeexceeded":       3,
+	"icmp6-parameterproblem":   4,
+	"icmp6-echo":               128,
+	"icmp6-echoreply":          129,
+	"icmp6-routersolicit":      133,
+	"icmp6-routeradvert":       134,
+	"icmp6-neighborsolicit":    135,
+	"icmp6-neighboradvert":     136,
+	"icmp6-redirect":           137,
+}
+
+// layerData maps the protocol names of data accessors to functions that
+// return the data of the protocol's header and payload.
+var layerData = map[string]func(gopacket.Packet) ([]byte, []byte, bool){
+	"ether": packetData,
+	"link":  packetData,
+	"ip":    layerBytes(layers.LayerTypeIPv4),
+	"ip6":   layerBytes(layers.LayerTypeIPv6),
+	"arp":   layerBytes(layers.LayerTypeARP),
+	"rarp":  layerBytes(layers.LayerTypeARP),
+	"tcp":   layerBytes(layers.LayerTypeTCP),
+	"udp":   layerBytes(layers.LayerTypeUDP),
+	"sctp":  layerBytes(layers.LayerTypeSCTP),
+	"icmp":  layerBytes(layers.LayerTypeICMPv4),
+	"icmp6": layerBytes(layers.LayerTypeICMPv6),
+	"igmp":  layerBytes(layers.LayerTypeIGMP),
+}
+
+func packetData(pkt gopacket.Packet) ([]byte, []byte, bool) {
+	return pkt.Data(), nil, true
+}
+
+func layerBytes(typ gopacket.LayerType) func(gopacket.Packet) ([]byte, []byte, bool) {
+	return func(pkt gopacket.Packet) ([]byte, []byte, bool) {
+		l := pkt.Layer(typ)
+		if l == nil {
+			return nil, nil, false
+		}
+		return l.LayerContents(), l.LayerPayload(), true
+	}
+}
+
+// loadMatcher returns a value loading size bytes in network byte order at
+// offset from the beginning of a protocol header.
+func loadMatcher(data func(gopacket.Packet) ([]byte, []byte, bool), offset value, size int) value {
+	return func(pkt gopacket.Packet) (uint32, bool) {
+		header, payload, ok := data(pkt)
+		if !ok {
+			return 0, false
+		}
+		off, ok := offset(pkt)
+		if !ok || uint64(off)+uint64(size) > uint64(len(header)+len(payload)) {
+			return 0, false
+		}
+		var n uint32
+		for i := int(off); i < int(off)+size; i++ {
+			if i < len(header) {
+				n = n<<8 | uint32(header[i])
+			} else {
+				n = n<<8 | uint32(payload[i-len(header)])
+			}
+		}
+		return n, true
+	}
+}
@@ -0,0 +1,662 @@
+package bpf
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gopacket/gopacket"
+)
+
+type matcher = func(gopacket.Packet) bool
+
+// value computes an arithmetic expression.  It returns false if the
+// expression cannot be computed for the packet, e.g., if it refers to data
+// beyond the end of the packet, in which case the packet does not match.
+type value func(gopacket.Packet) (uint32, bool)
+
+// qualifiers are the protocol, direction, and type qualifiers of a
+// primitive, e.g., "tcp", "src", and "port" in "tcp src port 80".
+type qualifiers struct {
+	proto string
+	dir   string
+	typ   string
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	// last holds the qualifiers of the previous primitive for
+	// abbreviations like "host a or b".
+	last *qualifiers
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekAt(n int) token {
+	if p.pos+n < len(p.tokens) {
+		return p.tokens[p.pos+n]
+	}
+	return p.tokens[len(p.tokens)-1]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is one of texts.
+func (p *parser) accept(texts ...string) (string, bool) {
+	tok := p.peek()
+	for _, text := range texts {
+		if tok.is(text) {
+			p.pos++
+			return text, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(text string) error {
+	if _, ok := p.accept(text); !ok {
+		return p.unexpected(p.peek())
+	}
+	return nil
+}
+
+func (p *parser) word() (token, error) {
+	tok := p.next()
+	if tok.kind != tokenWord {
+		return tok, p.unexpected(tok)
+	}
+	return tok, nil
+}
+
+func (p *parser) unexpected(tok token) error {
+	return fmt.Errorf("invalid filter expression: unexpected %s at offset %d", tok, tok.pos)
+}
+
+func (p *parser) errorf(tok token, format string, args ...interface{}) error {
+	return fmt.Errorf("invalid filter expression: %s at offset %d", fmt.Sprintf(format, args...), tok.pos)
+}
+
+func (p *parser) parseExpr() (matcher, error) {
+	m, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("or", "||"); !ok {
+			return m, nil
+		}
+		rhs, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		m = or(m, rhs)
+	}
+}
+
+func (p *parser) parseAnd() (matcher, error) {
+	m, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("and", "&&"); !ok {
+			return m, nil
+		}
+		rhs, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		m = and(m, rhs)
+	}
+}
+
+func (p *parser) parseNot() (matcher, error) {
+	if _, ok := p.accept("not", "!"); ok {
+		m, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(pkt gopacket.Packet) bool { return !m(pkt) }, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (matcher, error) {
+	// A relation may begin like a primitive ("tcp[13] & 2 != 0") or
+	// a parenthesized expression ("(len - 14) > 64") so try it first
+	// and back up if what follows is not a relation.
+	start := p.pos
+	m, committed, err := p.parseRelation()
+	if err == nil || committed {
+		return m, err
+	}
+	p.pos = start
+	tok := p.peek()
+	switch {
+	case tok.is("("):
+		p.next()
+		m, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return m, p.expect(")")
+	case tok.kind != tokenWord:
+		return nil, p.unexpected(tok)
+	case !isKeyword(tok.text) && p.last != nil:
+		return p.parseID(*p.last)
+	}
+	return p.parsePrimitive()
+}
+
+func (p *parser) parsePrimitive() (matcher, error) {
+	tok := p.peek()
+	switch tok.text {
+	case "less", "greater":
+		p.next()
+		n, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		if tok.text == "less" {
+			return func(pkt gopacket.Packet) bool { return packetLen(pkt) <= n }, nil
+		}
+		return func(pkt gopacket.Packet) bool { return packetLen(pkt) >= n }, nil
+	case "broadcast", "multicast":
+		p.next()
+		return castMatcher("ether", tok.text), nil
+	case "vlan", "mpls":
+		p.next()
+		var id *uint32
+		if next := p.peek(); next.kind == tokenWord && isNumber(next.text) {
+			n, err := p.number()
+			if err != nil {
+				return nil, err
+			}
+			id = &n
+		}
+		if tok.text == "vlan" {
+			return vlanMatcher(id), nil
+		}
+		return mplsMatcher(id), nil
+	}
+	var q qualifiers
+	if isProto(tok.text) {
+		q.proto = p.next().text
+		next := p.peek()
+		switch {
+		case next.is("broadcast") || next.is("multicast"):
+			p.next()
+			if !(q.proto == "ether" || (q.proto == "ip" || q.proto == "ip6") && next.text == "multicast") {
+				return nil, p.errorf(next, "%s %s is not supported", q.proto, next.text)
+			}
+			return castMatcher(q.proto, next.text), nil
+		case !isDir(next.text) && !isType(next.text):
+			if q.proto == "ether" {
+				return nil, p.unexpected(next)
+			}
+			return protoMatcher(q.proto), nil
+		}
+	}
+	if tok := p.peek(); isDir(tok.text) {
+		q.dir = p.next().text
+		if op := p.peek(); (op.is("or") || op.is("and")) && isDir(p.peekAt(1).text) {
+			p.next()
+			other := p.next()
+			if other.text == q.dir {
+				return nil, p.unexpected(other)
+			}
+			q.dir = "src " + op.text + " dst"
+		}
+	}
+	if tok := p.peek(); isType(tok.text) {
+		q.typ = p.next().text
+	}
+	return p.parseID(q)
+}
+
+// parseID parses the identifier of a primitive with qualifiers q.
+func (p *parser) parseID(q qualifiers) (matcher, error) {
+	tok, err := p.word()
+	if err != nil {
+		return nil, err
+	}
+	if q.typ == "" {
+		q.typ = "host"
+		if p.peek().is("/") || p.peek().is("mask") {
+			q.typ = "net"
+		}
+	}
+	last := q
+	p.last = &last
+	switch q.typ {
+	case "host":
+		if q.proto == "ether" {
+			mac, err := net.ParseMAC(tok.text)
+			if err != nil {
+				return nil, p.errorf(tok, "invalid MAC address %q", tok.text)
+			}
+			return etherHostMatcher(q.dir, mac), nil
+		}
+		if !isAddrProto(q.proto) {
+			return nil, p.errorf(tok, "%s host is not supported", q.proto)
+		}
+		ips, err := p.resolve(tok)
+		if err != nil {
+			return nil, err
+		}
+		var nets []*net.IPNet
+		for _, ip := range ips {
+			bits := 8 * len(ip)
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		}
+		return netMatcher(q.proto, q.dir, nets...), nil
+	case "net":
+		if !isAddrProto(q.proto) {
+			return nil, p.errorf(tok, "%s net is not supported", q.proto)
+		}
+		ipnet, err := p.parseNet(tok)
+		if err != nil {
+			return nil, err
+		}
+		return netMatcher(q.proto, q.dir, ipnet), nil
+	case "port", "portrange":
+		if !isPortProto(q.proto) {
+			return nil, p.errorf(tok, "%s %s is not supported", q.proto, q.typ)
+		}
+		lo, err := p.port(q.proto, tok)
+		if err != nil {
+			return nil, err
+		}
+		hi := lo
+		if q.typ == "portrange" {
+			if err := p.expect("-"); err != nil {
+				return nil, err
+			}
+			tok, err := p.word()
+			if err != nil {
+				return nil, err
+			}
+			if hi, err = p.port(q.proto, tok); err != nil {
+				return nil, err
+			}
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+		}
+		return portMatcher(q.proto, q.dir, lo, hi), nil
+	case "proto":
+		if q.dir != "" {
+			return nil, p.errorf(tok, "%s proto is not supported", q.dir)
+		}
+		return p.protoNumber(q.proto, tok)
+	}
+	return nil, p.unexpected(tok)
+}
+
+func (p *parser) number() (uint32, error) {
+	tok, err := p.word()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(tok.text, 0, 32)
+	if err != nil {
+		return 0, p.errorf(tok, "invalid number %q", tok.text)
+	}
+	return uint32(n), nil
+}
+
+func (p *parser) resolve(tok token) ([]net.IP, error) {
+	if ip := net.ParseIP(tok.text); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		return []net.IP{ip}, nil
+	}
+	if isNumber(tok.text) {
+		return nil, p.errorf(tok, "invalid host %q", tok.text)
+	}
+	addrs, err := net.LookupIP(tok.text)
+	if err != nil || len(addrs) == 0 {
+		return nil, p.errorf(tok, "unknown host %q", tok.text)
+	}
+	for i, ip := range addrs {
+		if ip4 := ip.To4(); ip4 != nil {
+			addrs[i] = ip4
+		}
+	}
+	return addrs, nil
+}
+
+// parseNet parses a network given as an address, which may be abbreviated
+// for IPv4 (e.g., "10.1" for 10.1.0.0/16), followed by an optional prefix
+// length or mask.
+func (p *parser) parseNet(tok token) (*net.IPNet, error) {
+	ip := net.ParseIP(tok.text)
+	bits := 128
+	if ip == nil {
+		var octets []byte
+		for _, s := range strings.Split(tok.text, ".") {
+			n, err := strconv.ParseUint(s, 10, 8)
+			if err != nil || len(octets) == 4 {
+				return nil, p.errorf(tok, "invalid network %q", tok.text)
+			}
+			octets = append(octets, byte(n))
+		}
+		bits = 8 * len(octets)
+		ip = net.IP(append(octets, make([]byte, 4-len(octets))...))
+	} else if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 32
+	}
+	mask := net.CIDRMask(bits, 8*len(ip))
+	if _, ok := p.accept("/"); ok {
+		n, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		if int(n) > 8*len(ip) {
+			return nil, p.errorf(tok, "invalid prefix length %d", n)
+		}
+		mask = net.CIDRMask(int(n), 8*len(ip))
+	} else if _, ok := p.accept("mask"); ok {
+		tok, err := p.word()
+		if err != nil {
+			return nil, err
+		}
+		m := net.ParseIP(tok.text)
+		if m == nil || (m.To4() == nil) != (len(ip) == net.IPv6len) {
+			return nil, p.errorf(tok, "invalid mask %q", tok.text)
+		}
+		if m4 := m.To4(); m4 != nil {
+			m = m4
+		}
+		mask = net.IPMask(m)
+	}
+	if !ip.Equal(ip.Mask(mask)) {
+		return nil, p.errorf(tok, "non-network bits set in %q", tok.text)
+	}
+	return &net.IPNet{IP: ip, Mask: mask}, nil
+}
+
+func (p *parser) port(proto string, tok token) (uint16, error) {
+	if n, err := strconv.ParseUint(tok.text, 0, 16); err == nil {
+		return uint16(n), nil
+	}
+	network := proto
+	if network != "udp" {
+		network = "tcp"
+	}
+	port, err := net.LookupPort(network, tok.text)
+	if err != nil {
+		return 0, p.errorf(tok, "unknown port %q", tok.text)
+	}
+	return uint16(port), nil
+}
+
+func (p *parser) protoNumber(proto string, tok token) (matcher, error) {
+	name := strings.TrimPrefix(tok.text, "\\")
+	if proto == "ether" {
+		typ, ok := etherTypes[name]
+		if !ok {
+			n, err := strconv.ParseUint(name, 0, 16)
+			if err != nil {
+				return nil, p.errorf(tok, "unknown ether proto %q", name)
+			}
+			typ = uint16(n)
+		}
+		return etherProtoMatcher(typ), nil
+	}
+	if proto != "" && proto != "ip" && proto != "ip6" {
+		return nil, p.errorf(tok, "%s proto is not supported", proto)
+	}
+	n, ok := ipProtos[name]
+	if !ok {
+		v, err := strconv.ParseUint(name, 0, 8)
+		if err != nil {
+			return nil, p.errorf(tok, "unknown ip proto %q", name)
+		}
+		n = uint8(v)
+	}
+	return ipProtoMatcher(proto, n), nil
+}
+
+// parseRelation parses a comparison of arithmetic expressions.  It returns
+// true if the tokens parsed so far can only be a relation so that errors
+// should not be retried as a primitive.
+func (p *parser) parseRelation() (matcher, bool, error) {
+	lhs, err := p.parseArith()
+	if err != nil {
+		return nil, false, err
+	}
+	op, ok := p.accept(">=", "<=", "==", "!=", ">", "<", "=")
+	if !ok {
+		return nil, false, p.unexpected(p.peek())
+	}
+	rhs, err := p.parseArith()
+	if err != nil {
+		return nil, true, err
+	}
+	cmp := comparisons[op]
+	return func(pkt gopacket.Packet) bool {
+		a, ok := lhs(pkt)
+		if !ok {
+			return false
+		}
+		b, ok := rhs(pkt)
+		return ok && cmp(a, b)
+	}, true, nil
+}
+
+var comparisons = map[string]func(a, b uint32) bool{
+	">":  func(a, b uint32) bool { return a > b },
+	"<":  func(a, b uint32) bool { return a < b },
+	">=": func(a, b uint32) bool { return a >= b },
+	"<=": func(a, b uint32) bool { return a <= b },
+	"=":  func(a, b uint32) bool { return a == b },
+	"==": func(a, b uint32) bool { return a == b },
+	"!=": func(a, b uint32) bool { return a != b },
+}
+
+// Binary operators by increasing precedence as in pcap-filter(7).
+var precedence = [][]string{
+	{"|", "^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+var arithmetic = map[string]func(a, b uint32) (uint32, bool){
+	"|":  func(a, b uint32) (uint32, bool) { return a | b, true },
+	"^":  func(a, b uint32) (uint32, bool) { return a ^ b, true },
+	"&":  func(a, b uint32) (uint32, bool) { return a & b, true },
+	"<<": func(a, b uint32) (uint32, bool) { return a << b, b < 32 },
+	">>": func(a, b uint32) (uint32, bool) { return a >> b, b < 32 },
+	"+":  func(a, b uint32) (uint32, bool) { return a + b, true },
+	"-":  func(a, b uint32) (uint32, bool) { return a - b, true },
+	"*":  func(a, b uint32) (uint32, bool) { return a * b, true },
+	"/":  func(a, b uint32) (uint32, bool) { return div(a, b) },
+	"%":  func(a, b uint32) (uint32, bool) { return mod(a, b) },
+}
+
+func div(a, b uint32) (uint32, bool) {
+	if b == 0 {
+		return 0, false
+	}
+	return a / b, true
+}
+
+func mod(a, b uint32) (uint32, bool) {
+	if b == 0 {
+		return 0, false
+	}
+	return a % b, true
+}
+
+func (p *parser) parseArith() (value, error) {
+	return p.parseBinary(0)
+}
+
+func (p *parser) parseBinary(level int) (value, error) {
+	if level == len(precedence) {
+		return p.parseUnary()
+	}
+	lhs, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(precedence[level]...)
+		if !ok {
+			return lhs, nil
+		}
+		rhs, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		lhs = binary(lhs, rhs, arithmetic[op])
+	}
+}
+
+func binary(lhs, rhs value, fn func(a, b uint32) (uint32, bool)) value {
+	return func(pkt gopacket.Packet) (uint32, bool) {
+		a, ok := lhs(pkt)
+		if !ok {
+			return 0, false
+		}
+		b, ok := rhs(pkt)
+		if !ok {
+			return 0, false
+		}
+		return fn(a, b)
+	}
+}
+
+func (p *parser) parseUnary() (value, error) {
+	if _, ok := p.accept("-"); ok {
+		v, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(pkt gopacket.Packet) (uint32, bool) {
+			n, ok := v(pkt)
+			return -n, ok
+		}, nil
+	}
+	return p.parseOperand()
+}
+
+func (p *parser) parseOperand() (value, error) {
+	tok := p.next()
+	if tok.is("(") {
+		v, err := p.parseArith()
+		if err != nil {
+			return nil, err
+		}
+		return v, p.expect(")")
+	}
+	if tok.kind != tokenWord {
+		return nil, p.unexpected(tok)
+	}
+	if tok.text == "len" {
+		return func(pkt gopacket.Packet) (uint32, bool) { return packetLen(pkt), true }, nil
+	}
+	if n, ok := constants[tok.text]; ok {
+		return constant(n), nil
+	}
+	if isNumber(tok.text) {
+		n, err := strconv.ParseUint(tok.text, 0, 32)
+		if err != nil {
+			return nil, p.errorf(tok, "invalid number %q", tok.text)
+		}
+		return constant(uint32(n)), nil
+	}
+	if !p.peek().is("[") {
+		return nil, p.unexpected(tok)
+	}
+	data, ok := layerData[tok.text]
+	if !ok {
+		return nil, p.errorf(tok, "%s[] is not supported", tok.text)
+	}
+	p.next()
+	offset, err := p.parseArith()
+	if err != nil {
+		return nil, err
+	}
+	size := uint32(1)
+	if _, ok := p.accept(":"); ok {
+		sizeTok := p.peek()
+		if size, err = p.number(); err != nil {
+			return nil, err
+		}
+		if size != 1 && size != 2 && size != 4 {
+			return nil, p.errorf(sizeTok, "data size must be 1, 2, or 4")
+		}
+	}
+	if err := p.expect("]"); err != nil {
+		return nil, err
+	}
+	return loadMatcher(data, offset, int(size)), nil
+}
+
+func constant(n uint32) value {
+	return func(gopacket.Packet) (uint32, bool) { return n, true }
+}
+
+func isNumber(s string) bool {
+	return s != "" && s[0] >= '0' && s[0] <= '9'
+}
+
+var keywords = map[string]bool{
+	"and": true, "or": true, "not": true, "less": true, "greater": true,
+	"broadcast": true, "multicast": true, "vlan": true, "mpls": true, "len": true,
+}
+
+func isKeyword(s string) bool {
+	return keywords[s] || isProto(s) || isDir(s) || isType(s)
+}
+
+func isProto(s string) bool {
+	switch s {
+	case "ether", "ip", "ip6", "arp", "rarp", "tcp", "udp", "sctp", "icmp", "icmp6", "igmp":
+		return true
+	}
+	return false
+}
+
+func isAddrProto(s string) bool {
+	switch s {
+	case "", "ip", "ip6", "arp", "rarp":
+		return true
+	}
+	return false
+}
+
+func isPortProto(s string) bool {
+	switch s {
+	case "", "tcp", "udp", "sctp":
+		return true
+	}
+	return false
+}
+
+func isDir(s string) bool {
+	return s == "src" || s == "dst"
+}
+
+func isType(s string) bool {
+	switch s {
+	case "host", "net", "port", "portrange", "proto":
+		return true
+	}
+	return false
+}
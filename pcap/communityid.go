@@ -0,0 +1,152 @@
+package pcap
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// CommunityID is a Community ID flow hash as defined by version 1 of the
+// specification at https://github.com/corelight/community-id-spec.
+type CommunityID [sha1.Size]byte
+
+const communityIDPrefix = "1:"
+
+// ParseCommunityID parses the string form of a Community ID, e.g.,
+// "1:LQU9qZlK+B5F3KDmev6m5PMibrg=".
+func ParseCommunityID(s string) (CommunityID, error) {
+	var id CommunityID
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, communityIDPrefix))
+	if err != nil || !strings.HasPrefix(s, communityIDPrefix) || len(b) != len(id) {
+		return id, fmt.Errorf("invalid community ID: %q", s)
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+func (c CommunityID) String() string {
+	return communityIDPrefix + base64.StdEncoding.EncodeToString(c[:])
+}
+
+// icmpCounterparts maps the ICMP and ICMPv6 message types that have a
+// counterpart (e.g., echo request and echo reply) to that counterpart.
+var (
+	icmpCounterparts = map[uint8]uint8{
+		0: 8, 8: 0, // echo
+		9: 10, 10: 9, // router advertisement and solicitation
+		13: 14, 14: 13, // timestamp
+		15: 16, 16: 15, // information
+		17: 18, 18: 17, // address mask
+	}
+	icmp6Counterparts = map[uint8]uint8{
+		128: 129, 129: 128, // echo
+		130: 131, 131: 130, // multicast listener query and report
+		133: 134, 134: 133, // router solicitation and advertisement
+		135: 136, 136: 135, // neighbor solicitation and advertisement
+		139: 140, 140: 139, // node information query and response
+		144: 145, 145: 144, // home agent address discovery
+	}
+)
+
+// ICMPPorts returns the Community ID ports of an ICMP (or, if v6 is true,
+// ICMPv6) message of type typ and code code.  As in Zeek, the ports of a
+// message type with a counterpart are the type and the counterpart type, so
+// both directions of an exchange hash alike, and otherwise are the type and
+// code.  The returned oneWay is true in the latter case.
+func ICMPPorts(typ, code uint8, v6 bool) (sport, dport uint16, oneWay bool) {
+	counterparts := icmpCounterparts
+	if v6 {
+		counterparts = icmp6Counterparts
+	}
+	if other, ok := counterparts[typ]; ok {
+		return uint16(typ), uint16(other), false
+	}
+	return uint16(typ), uint16(code), true
+}
+
+// ComputeCommunityID computes the Community ID of a flow.  For ICMP and
+// ICMPv6, sport and dport are those returned by ICMPPorts.  For other
+// protocols without ports, sport and dport should be zero.
+func ComputeCommunityID(seed uint16, src, dst net.IP, proto layers.IPProtocol, sport, dport uint16) CommunityID {
+	if ip := src.To4(); ip != nil {
+		src = ip
+	}
+	if ip := dst.To4(); ip != nil {
+		dst = ip
+	}
+	oneWay := false
+	switch proto {
+	case layers.IPProtocolICMPv4, layers.IPProtocolICMPv6:
+		_, _, oneWay = ICMPPorts(uint8(sport), uint8(dport), proto == layers.IPProtocolICMPv6)
+	}
+	// The flow tuple is ordered so that both directions of a flow hash
+	// alike.
+	if !oneWay {
+		if c := bytes.Compare(src, dst); c > 0 || (c == 0 && sport > dport) {
+			src, dst = dst, src
+			sport, dport = dport, sport
+		}
+	}
+	h := sha1.New()
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], seed)
+	h.Write(b[:])
+	h.Write(src)
+	h.Write(dst)
+	h.Write([]byte{byte(proto), 0})
+	if hasPorts(proto) {
+		binary.BigEndian.PutUint16(b[:], sport)
+		h.Write(b[:])
+		binary.BigEndian.PutUint16(b[:], dport)
+		h.Write(b[:])
+	}
+	var id CommunityID
+	h.Sum(id[:0])
+	return id
+}
+
+func hasPorts(proto layers.IPProtocol) bool {
+	switch proto {
+	case layers.IPProtocolTCP, layers.IPProtocolUDP, layers.IPProtocolSCTP, layers.IPProtocolICMPv4, layers.IPProtocolICMPv6:
+		return true
+	}
+	return false
+}
+
+// headerCommunityID computes the Community ID of the flow of an IP header.
+// It returns false if the header carries no TCP, UDP, SCTP, ICMP, or ICMPv6
+// layer.
+func headerCommunityID(h ipHeader, seed uint16) (CommunityID, bool) {
+	var proto layers.IPProtocol
+	var sport, dport uint16
+	switch t := h.transport.(type) {
+	case *layers.TCP:
+		proto, sport, dport = layers.IPProtocolTCP, uint16(t.SrcPort), uint16(t.DstPort)
+	case *layers.UDP:
+		proto, sport, dport = layers.IPProtocolUDP, uint16(t.SrcPort), uint16(t.DstPort)
+	case *layers.SCTP:
+		proto, sport, dport = layers.IPProtocolSCTP, uint16(t.SrcPort), uint16(t.DstPort)
+	case *layers.ICMPv4:
+		proto = layers.IPProtocolICMPv4
+		sport, dport, _ = ICMPPorts(t.TypeCode.Type(), t.TypeCode.Code(), false)
+	case *layers.ICMPv6:
+		proto = layers.IPProtocolICMPv6
+		sport, dport, _ = ICMPPorts(t.TypeCode.Type(), t.TypeCode.Code(), true)
+	default:
+		return CommunityID{}, false
+	}
+	return ComputeCommunityID(seed, h.src, h.dst, proto, sport, dport), true
+}
+
+func genCommunityIDFilter(id CommunityID, seed uint16) headerFilter {
+	return func(h ipHeader) bool {
+		hid, ok := headerCommunityID(h, seed)
+		return ok && hid == id
+	}
+}
@@ -0,0 +1,74 @@
+package pcap_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/brimdata/brimcap/pcap"
+	"github.com/brimdata/zed/pkg/nano"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommunityID(t *testing.T) {
+	echoSport, echoDport, _ := pcap.ICMPPorts(8, 0, false)
+	unreachSport, unreachDport, _ := pcap.ICMPPorts(3, 1, false)
+	nsSport, nsDport, _ := pcap.ICMPPorts(135, 0, true)
+	cases := []struct {
+		seed     uint16
+		src      string
+		dst      string
+		proto    layers.IPProtocol
+		sport    uint16
+		dport    uint16
+		expected string
+	}{
+		// From the baseline of the Community ID specification.
+		{0, "128.232.110.120", "66.35.250.204", layers.IPProtocolTCP, 34855, 80, "1:LQU9qZlK+B5F3KDmev6m5PMibrg="},
+		{0, "66.35.250.204", "128.232.110.120", layers.IPProtocolTCP, 80, 34855, "1:LQU9qZlK+B5F3KDmev6m5PMibrg="},
+		{1, "128.232.110.120", "66.35.250.204", layers.IPProtocolTCP, 34855, 80, "1:3V71V58M3Ksw/yuFALMcW0LAHvc="},
+		{0, "192.168.1.52", "8.8.8.8", layers.IPProtocolUDP, 54585, 53, "1:d/FP5EW3wiY1vCndhwleRRKHowQ="},
+		{0, "192.168.0.89", "192.168.0.1", layers.IPProtocolICMPv4, echoSport, echoDport, "1:X0snYXpgwiv9TZtqg64sgzUn6Dk="},
+		{0, "::ffff:192.168.0.89", "::ffff:192.168.0.1", layers.IPProtocolICMPv4, echoSport, echoDport, "1:X0snYXpgwiv9TZtqg64sgzUn6Dk="},
+		// One-way messages aren't reordered.
+		{0, "10.0.0.2", "10.0.0.1", layers.IPProtocolICMPv4, unreachSport, unreachDport, ""},
+		{0, "fe80::1", "fe80::2", layers.IPProtocolICMPv6, nsSport, nsDport, ""},
+	}
+	for _, c := range cases {
+		id := pcap.ComputeCommunityID(c.seed, net.ParseIP(c.src), net.ParseIP(c.dst), c.proto, c.sport, c.dport)
+		if c.expected != "" {
+			assert.Equal(t, c.expected, id.String())
+		}
+		parsed, err := pcap.ParseCommunityID(id.String())
+		require.NoError(t, err)
+		assert.Equal(t, id, parsed)
+	}
+	// Counterpart ICMP messages hash alike but one-way messages don't.
+	reply := pcap.ComputeCommunityID(0, net.ParseIP("192.168.0.1"), net.ParseIP("192.168.0.89"), layers.IPProtocolICMPv4, 0, 8)
+	assert.Equal(t, "1:X0snYXpgwiv9TZtqg64sgzUn6Dk=", reply.String())
+	fwd := pcap.ComputeCommunityID(0, net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1"), layers.IPProtocolICMPv4, unreachSport, unreachDport)
+	rev := pcap.ComputeCommunityID(0, net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), layers.IPProtocolICMPv4, unreachSport, unreachDport)
+	assert.NotEqual(t, fwd, rev)
+}
+
+func TestParseCommunityIDErrors(t *testing.T) {
+	for _, s := range []string{"", "1:", "LQU9qZlK+B5F3KDmev6m5PMibrg=", "2:LQU9qZlK+B5F3KDmev6m5PMibrg=", "1:LQU9qZlK", "1:not base64!"} {
+		_, err := pcap.ParseCommunityID(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestSearchCommunityID(t *testing.T) {
+	packets := tunnelPackets(t)
+	span := nano.Span{Ts: 0, Dur: nano.Duration(len(packets))}
+	inner := pcap.ComputeCommunityID(0, inner0, inner1, layers.IPProtocolTCP, 1234, 80)
+	outer := pcap.ComputeCommunityID(0, outer0, outer1, layers.IPProtocolUDP, 50000, 6081)
+	search := pcap.NewRangeSearch(span)
+	assert.Equal(t, []nano.Ts{0, 1, 2, 3, 4, 5}, searchPackets(t, search.WithCommunityID(inner, 0), packets))
+	assert.Equal(t, []nano.Ts{4}, searchPackets(t, search.WithCommunityID(outer, 0), packets))
+	assert.Nil(t, searchPackets(t, search.WithCommunityID(outer, 0).WithTunnel(pcap.TunnelInner), packets))
+	assert.Nil(t, searchPackets(t, search.WithCommunityID(inner, 1), packets))
+	flow := pcap.NewFlow(inner1, 80, inner0, 1234)
+	assert.Equal(t, []nano.Ts{0, 1}, searchPackets(t, pcap.NewTCPSearch(span, flow).WithCommunityID(inner, 0).WithTunnel(pcap.TunnelOuter), packets))
+}
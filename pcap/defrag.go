@@ -0,0 +1,147 @@
+package pcap
+
+import (
+	"net"
+
+	"github.com/brimdata/zed/pkg/nano"
+	"github.com/gopacket/gopacket/layers"
+)
+
+const (
+	// fragmentTimeout is how long the fragments of a datagram are tracked
+	// after its last fragment is seen.  It is the IPv6 reassembly timeout
+	// of RFC 8200.
+	fragmentTimeout = 60 * nano.Ts(nano.Second)
+	// maxPendingBytes bounds the size of the non-initial fragments held
+	// while waiting for the initial fragment of their datagram.
+	maxPendingBytes = 16 * 1024 * 1024
+)
+
+// fragmentKey identifies the datagram that an IP fragment belongs to.
+type fragmentKey struct {
+	src   [16]byte
+	dst   [16]byte
+	proto layers.IPProtocol
+	id    uint32
+}
+
+type fragment struct {
+	key    fragmentKey
+	offset uint16
+	more   bool
+}
+
+func newFragment(src, dst net.IP, proto layers.IPProtocol, id uint32, offset uint16, more bool) *fragment {
+	f := &fragment{key: fragmentKey{proto: proto, id: id}, offset: offset, more: more}
+	copy(f.key.src[:], src.To16())
+	copy(f.key.dst[:], dst.To16())
+	return f
+}
+
+// datagram is the state of a fragmented datagram.
+type datagram struct {
+	// last is the timestamp of the datagram's last fragment seen.
+	last nano.Ts
+	// initial is true once the datagram's initial fragment is seen, at which
+	// point matched tells whether the datagram matched the search.
+	initial bool
+	matched bool
+	// pending holds the non-initial fragments that arrived before the
+	// initial fragment.
+	pending [][]byte
+}
+
+// defragmenter tracks fragmented datagrams so that the non-initial fragments
+// of a datagram, which have no transport header to match a flow against,
+// match when the datagram's initial fragment does.
+type defragmenter struct {
+	datagrams    map[fragmentKey]*datagram
+	pendingBytes int
+	swept        nano.Ts
+}
+
+func newDefragmenter() *defragmenter {
+	return &defragmenter{datagrams: make(map[fragmentKey]*datagram)}
+}
+
+func (d *defragmenter) reset() {
+	d.datagrams = make(map[fragmentKey]*datagram)
+	d.pendingBytes = 0
+}
+
+func (d *defragmenter) lookup(key fragmentKey, ts nano.Ts) *datagram {
+	d.expire(ts)
+	dg, ok := d.datagrams[key]
+	if !ok {
+		dg = &datagram{}
+		d.datagrams[key] = dg
+	}
+	dg.last = ts
+	return dg
+}
+
+// initial records whether the datagrams with an initial fragment in headers
+// matched and returns the pending fragments of the datagrams that did, which
+// should be written after the initial fragment.
+func (d *defragmenter) initial(headers []ipHeader, ts nano.Ts, matched bool) [][]byte {
+	var out [][]byte
+	for _, h := range headers {
+		if h.frag == nil || h.frag.offset != 0 {
+			continue
+		}
+		dg := d.lookup(h.frag.key, ts)
+		dg.initial = true
+		dg.matched = dg.matched || matched
+		if dg.matched {
+			out = append(out, dg.pending...)
+		}
+		d.release(dg)
+	}
+	return out
+}
+
+// later handles the non-initial fragment in block whose datagram is given by
+// the outermost non-initial fragment header in headers.  It returns true if
+// the fragment matches because its datagram did.  Otherwise, the fragment is
+// either dropped or, if its datagram's initial fragment hasn't been seen yet,
+// held until it is.  The second return value is false if headers has no
+// non-initial fragment header.
+func (d *defragmenter) later(headers []ipHeader, ts nano.Ts, block []byte) (bool, bool) {
+	for _, h := range headers {
+		if h.frag == nil || h.frag.offset == 0 {
+			continue
+		}
+		dg := d.lookup(h.frag.key, ts)
+		if dg.initial {
+			return dg.matched, true
+		}
+		if d.pendingBytes+len(block) <= maxPendingBytes {
+			dg.pending = append(dg.pending, append([]byte(nil), block...))
+			d.pendingBytes += len(block)
+		}
+		return false, true
+	}
+	return false, false
+}
+
+func (d *defragmenter) release(dg *datagram) {
+	for _, b := range dg.pending {
+		d.pendingBytes -= len(b)
+	}
+	dg.pending = nil
+}
+
+// expire forgets the datagrams whose last fragment was seen more than
+// fragmentTimeout before ts.
+func (d *defragmenter) expire(ts nano.Ts) {
+	if ts < d.swept+fragmentTimeout {
+		return
+	}
+	for key, dg := range d.datagrams {
+		if dg.last+fragmentTimeout < ts {
+			d.release(dg)
+			delete(d.datagrams, key)
+		}
+	}
+	d.swept = ts
+}
@@ -0,0 +1,91 @@
+package pcap_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/brimdata/brimcap/pcap"
+	"github.com/brimdata/zed/pkg/nano"
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+// udpDatagram returns a UDP datagram of 64 bytes from port 5353 to port 53
+// with a checksum computed over network.
+func udpDatagram(t *testing.T, network gopacket.NetworkLayer) []byte {
+	udp := &layers.UDP{SrcPort: 5353, DstPort: 53}
+	payload := make([]byte, 56)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	return serialize(t, network.(gopacket.SerializableLayer), udp, gopacket.Payload(payload))[ipHeaderLen(network):]
+}
+
+func ipHeaderLen(network gopacket.NetworkLayer) int {
+	if _, ok := network.(*layers.IPv6); ok {
+		return 40
+	}
+	return 20
+}
+
+// ipv4Fragments splits datagram from inner0 to dst into IPv4 fragments of 24
+// bytes.
+func ipv4Fragments(t *testing.T, id uint16, dst net.IP, datagram []byte) [][]byte {
+	var frags [][]byte
+	for off := 0; off < len(datagram); off += 24 {
+		end := min(off+24, len(datagram))
+		ip := ipv4(layers.IPProtocolUDP, inner0, dst)
+		ip.Id = id
+		ip.FragOffset = uint16(off / 8)
+		if end < len(datagram) {
+			ip.Flags = layers.IPv4MoreFragments
+		}
+		frags = append(frags, serialize(t, ether(layers.EthernetTypeIPv4), ip, gopacket.Payload(datagram[off:end])))
+	}
+	return frags
+}
+
+// ipv6Fragments splits datagram into IPv6 fragments of 24 bytes.
+func ipv6Fragments(t *testing.T, id uint32, datagram []byte) [][]byte {
+	var frags [][]byte
+	for off := 0; off < len(datagram); off += 24 {
+		end := min(off+24, len(datagram))
+		hdr := make([]byte, 8)
+		hdr[0] = byte(layers.IPProtocolUDP)
+		offset := uint16(off/8) << 3
+		if end < len(datagram) {
+			offset |= 1
+		}
+		binary.BigEndian.PutUint16(hdr[2:], offset)
+		binary.BigEndian.PutUint32(hdr[4:], id)
+		ip := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolIPv6Fragment, SrcIP: src6, DstIP: dst6}
+		frags = append(frags, serialize(t, ether(layers.EthernetTypeIPv6), ip, gopacket.Payload(append(hdr, datagram[off:end]...))))
+	}
+	return frags
+}
+
+var (
+	src6 = net.ParseIP("2001:db8::1")
+	dst6 = net.ParseIP("2001:db8::2")
+)
+
+func TestSearchDefrag(t *testing.T) {
+	v4 := ipv4Fragments(t, 7, inner1, udpDatagram(t, ipv4(layers.IPProtocolUDP, inner0, inner1)))
+	other := ipv4Fragments(t, 7, outer1, udpDatagram(t, ipv4(layers.IPProtocolUDP, inner0, outer1)))
+	v6 := ipv6Fragments(t, 9, udpDatagram(t, &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP, SrcIP: src6, DstIP: dst6}))
+	// The second IPv4 fragment arrives before the first and fragments of
+	// another datagram with the same ID but a different destination are
+	// interleaved.
+	packets := [][]byte{v4[1], other[1], v4[0], other[0], v4[2], other[2], v6[0], v6[1], v6[2]}
+	span := nano.Span{Ts: 0, Dur: nano.Duration(len(packets))}
+	v4Flow := pcap.NewUDPSearch(span, pcap.NewFlow(inner0, 5353, inner1, 53))
+	v6Flow := pcap.NewUDPSearch(span, pcap.NewFlow(src6, 5353, dst6, 53))
+	assert.Equal(t, []nano.Ts{2}, searchPackets(t, v4Flow, packets))
+	assert.Equal(t, []nano.Ts{2, 0, 4}, searchPackets(t, v4Flow.WithDefrag(true), packets))
+	assert.Equal(t, []nano.Ts{6}, searchPackets(t, v6Flow, packets))
+	assert.Equal(t, []nano.Ts{6, 7, 8}, searchPackets(t, v6Flow.WithDefrag(true), packets))
+	id := pcap.ComputeCommunityID(0, inner0, inner1, layers.IPProtocolUDP, 5353, 53)
+	assert.Equal(t, []nano.Ts{2, 0, 4}, searchPackets(t, pcap.NewRangeSearch(span).WithCommunityID(id, 0).WithDefrag(true), packets))
+}
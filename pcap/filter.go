@@ -0,0 +1,43 @@
+package pcap
+
+import (
+	"context"
+	"io"
+
+	"github.com/brimdata/brimcap/pcap/pcapio"
+	"github.com/gopacket/gopacket"
+)
+
+// Filter copies the capture read from r to w dropping the packets that do not
+// match filter.  All other blocks are copied verbatim so the output keeps the
+// format and metadata of the input.
+func Filter(ctx context.Context, w io.Writer, r pcapio.Reader, filter PacketFilter) error {
+	opts := gopacket.DecodeOptions{Lazy: true, NoCopy: true}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		block, typ, err := r.Read()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if block == nil {
+			return nil
+		}
+		if typ == pcapio.TypePacket {
+			pktBuf, _, linkType, err := r.Packet(block)
+			if pktBuf == nil {
+				return err
+			}
+			if !filter(gopacket.NewPacket(pktBuf, linkType, opts)) {
+				continue
+			}
+		}
+		if _, err := w.Write(block); err != nil {
+			return err
+		}
+	}
+}
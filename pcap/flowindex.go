@@ -0,0 +1,313 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"net"
+	"slices"
+
+	"github.com/brimdata/brimcap/slicer"
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// FlowKey is the hash of a pair of IP addresses or of the 5-tuple of a
+// TCP, UDP, or SCTP flow.  It doesn't depend on the direction of the flow.
+type FlowKey uint64
+
+// PairKey returns the key of the IP pair of a and b.
+func PairKey(a, b net.IP) FlowKey {
+	a, b = a.To16(), b.To16()
+	if string(a) > string(b) {
+		a, b = b, a
+	}
+	return flowKey(0, a, 0, b, 0)
+}
+
+// TupleKey returns the key of the 5-tuple of flow for the IP protocol proto.
+func TupleKey(proto layers.IPProtocol, flow Flow) FlowKey {
+	s0, s1 := flow.S0, flow.S1
+	ip0, ip1 := s0.IP.To16(), s1.IP.To16()
+	if string(ip0) > string(ip1) || (string(ip0) == string(ip1) && s0.Port > s1.Port) {
+		s0, s1 = s1, s0
+		ip0, ip1 = ip1, ip0
+	}
+	return flowKey(proto, ip0, uint16(s0.Port), ip1, uint16(s1.Port))
+}
+
+func flowKey(proto layers.IPProtocol, ip0 net.IP, port0 uint16, ip1 net.IP, port1 uint16) FlowKey {
+	var b [37]byte
+	b[0] = byte(proto)
+	copy(b[1:17], ip0)
+	binary.BigEndian.PutUint16(b[17:], port0)
+	copy(b[19:35], ip1)
+	binary.BigEndian.PutUint16(b[35:], port1)
+	h := fnv.New64a()
+	h.Write(b[:])
+	// FNV leaves the high bits poorly mixed so finish with the
+	// finalizer of splitmix64 since BloomFilter uses both halves.
+	x := h.Sum64()
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return FlowKey(x ^ (x >> 31))
+}
+
+// headerKeys returns the keys of an IP header: the key of its IP pair and,
+// if it carries a TCP, UDP, or SCTP header, that of its 5-tuple.
+func headerKeys(h ipHeader) []FlowKey {
+	keys := []FlowKey{PairKey(h.src, h.dst)}
+	var proto layers.IPProtocol
+	var sport, dport int
+	switch t := h.transport.(type) {
+	case *layers.TCP:
+		proto, sport, dport = layers.IPProtocolTCP, int(t.SrcPort), int(t.DstPort)
+	case *layers.UDP:
+		proto, sport, dport = layers.IPProtocolUDP, int(t.SrcPort), int(t.DstPort)
+	case *layers.SCTP:
+		proto, sport, dport = layers.IPProtocolSCTP, int(t.SrcPort), int(t.DstPort)
+	default:
+		return keys
+	}
+	return append(keys, TupleKey(proto, NewFlow(h.src, sport, h.dst, dport)))
+}
+
+// FlowBin summarizes the flows of the packets of a section from Offset up to
+// the Offset of the next FlowBin of the section (or the end of the section).
+type FlowBin struct {
+	Offset uint64
+	Filter BloomFilter
+}
+
+// BloomFilter is a Bloom filter of FlowKeys.  It holds each key in K of the
+// bits of Bits.
+type BloomFilter struct {
+	K    int
+	Bits []byte
+}
+
+// bloomBitsPerKey and bloomK give a false positive rate of about 1%, which
+// bloomMinBytes keeps for bins with few keys.  The number of bytes is rounded
+// up to a power of two so filters can be folded (see union).
+const (
+	bloomBitsPerKey = 10
+	bloomK          = 7
+	bloomMinBytes   = 8
+)
+
+// NewBloomFilter returns a BloomFilter holding keys.
+func NewBloomFilter(keys []FlowKey) BloomFilter {
+	if len(keys) == 0 {
+		return BloomFilter{}
+	}
+	n := bloomMinBytes
+	for n*8 < len(keys)*bloomBitsPerKey {
+		n *= 2
+	}
+	f := BloomFilter{K: bloomK, Bits: make([]byte, n)}
+	for _, key := range keys {
+		f.each(key, func(bit uint64) bool {
+			f.Bits[bit/8] |= 1 << (bit % 8)
+			return true
+		})
+	}
+	return f
+}
+
+// Contains returns false if key is not in f.  If it returns true, key is
+// probably in f.
+func (f BloomFilter) Contains(key FlowKey) bool {
+	if len(f.Bits) == 0 {
+		return false
+	}
+	return f.each(key, func(bit uint64) bool {
+		return f.Bits[bit/8]&(1<<(bit%8)) != 0
+	})
+}
+
+// each calls fn with each of the K bits of key, derived from its halves by
+// enhanced double hashing, until fn returns false.
+func (f BloomFilter) each(key FlowKey, fn func(uint64) bool) bool {
+	m := uint64(len(f.Bits)) * 8
+	h1, h2 := uint64(key)&math.MaxUint32, uint64(key)>>32
+	for i := uint64(0); i < uint64(f.K); i++ {
+		if !fn(h1 % m) {
+			return false
+		}
+		h1 += h2
+		h2 += i + 1
+	}
+	return true
+}
+
+// union returns a filter holding the keys of f and g, folding the larger of
+// them in half until it's the size of the other, or false if their sizes or K
+// differ in a way that doesn't allow it.  Since each returns bits modulo the
+// size of the filter, a bit of a filter of 2m bits is bit b%m of the folded
+// one.
+func (f BloomFilter) union(g BloomFilter) (BloomFilter, bool) {
+	if len(g.Bits) == 0 {
+		return f, true
+	}
+	if len(f.Bits) == 0 {
+		return g, true
+	}
+	if f.K != g.K {
+		return BloomFilter{}, false
+	}
+	if len(f.Bits) < len(g.Bits) {
+		f, g = g, f
+	}
+	bits := slices.Clone(f.Bits)
+	for len(bits) > len(g.Bits) {
+		if len(bits)%2 != 0 {
+			return BloomFilter{}, false
+		}
+		half := len(bits) / 2
+		for i := range half {
+			bits[i] |= bits[half+i]
+		}
+		bits = bits[:half]
+	}
+	if len(bits) != len(g.Bits) {
+		return BloomFilter{}, false
+	}
+	for i := range bits {
+		bits[i] |= g.Bits[i]
+	}
+	return BloomFilter{K: f.K, Bits: bits}, true
+}
+
+// condenseFlowBins merges pairs of adjacent bins until there are at most
+// limit of them.  The bins of an index resumed by UpdateIndexFile follow
+// those of its last section this way.  If the filters of a pair can't be
+// merged, bins is returned as is.
+func condenseFlowBins(bins []FlowBin, limit int) []FlowBin {
+	for len(bins) > max(limit, 1) {
+		var out []FlowBin
+		for i := 0; i < len(bins); i += 2 {
+			bin := bins[i]
+			if i+1 < len(bins) {
+				var ok bool
+				if bin.Filter, ok = bin.Filter.union(bins[i+1].Filter); !ok {
+					return bins
+				}
+			}
+			out = append(out, bin)
+		}
+		bins = out
+	}
+	return bins
+}
+
+// flowIndexer collects the keys of the packets of a section into at most
+// limit bins.  Like ranger.NewEnvelope, each bin covers the same number of
+// packets, which doubles whenever the number of bins exceeds the limit.
+type flowIndexer struct {
+	limit int
+	quota int
+	opts  gopacket.DecodeOptions
+	bins  []flowKeys
+}
+
+type flowKeys struct {
+	offset  uint64
+	packets int
+	keys    map[FlowKey]struct{}
+}
+
+func newFlowIndexer(limit int) *flowIndexer {
+	return &flowIndexer{
+		limit: max(limit, 1),
+		quota: 1,
+		opts:  gopacket.DecodeOptions{Lazy: true, NoCopy: true},
+	}
+}
+
+// add adds the packet at offset off.  A packet that can't begin a slice,
+// like a simple packet, is added to the current bin.
+func (f *flowIndexer) add(off uint64, pkt []byte, linkType layers.LinkType, start bool) {
+	n := len(f.bins)
+	if start && (n == 0 || f.bins[n-1].packets >= f.quota) {
+		f.bins = append(f.bins, flowKeys{offset: off, keys: make(map[FlowKey]struct{})})
+		if len(f.bins) > f.limit {
+			f.condense()
+		}
+		n = len(f.bins)
+	}
+	if n == 0 {
+		return
+	}
+	bin := &f.bins[n-1]
+	bin.packets++
+	for _, h := range ipHeaders(gopacket.NewPacket(pkt, linkType, f.opts)) {
+		for _, key := range headerKeys(h) {
+			bin.keys[key] = struct{}{}
+		}
+	}
+}
+
+// condense merges pairs of adjacent bins and doubles the quota.
+func (f *flowIndexer) condense() {
+	out := f.bins[:0]
+	for i := 0; i < len(f.bins); i += 2 {
+		bin := f.bins[i]
+		if i+1 < len(f.bins) {
+			next := f.bins[i+1]
+			bin.packets += next.packets
+			for key := range next.keys {
+				bin.keys[key] = struct{}{}
+			}
+		}
+		out = append(out, bin)
+	}
+	f.bins = out
+	f.quota *= 2
+}
+
+func (f *flowIndexer) flowBins() []FlowBin {
+	var bins []FlowBin
+	for _, bin := range f.bins {
+		keys := make([]FlowKey, 0, len(bin.keys))
+		for key := range bin.keys {
+			keys = append(keys, key)
+		}
+		bins = append(bins, FlowBin{Offset: bin.offset, Filter: NewBloomFilter(keys)})
+	}
+	return bins
+}
+
+// pruneSlice returns the parts of slice, a packet slice of a section with
+// flow bins bins, covered by the bins that may hold any of keys.  If keys is
+// nil, slice is returned whole.
+func pruneSlice(slice slicer.Slice, bins []FlowBin, keys []FlowKey) []slicer.Slice {
+	if len(bins) == 0 || keys == nil {
+		return []slicer.Slice{slice}
+	}
+	start, end := slice.Offset, slice.Offset+slice.Length
+	var slices []slicer.Slice
+	if start < bins[0].Offset {
+		slices = append(slices, slicer.Slice{Offset: start, Length: min(end, bins[0].Offset) - start})
+	}
+	for i, bin := range bins {
+		x1 := uint64(math.MaxUint64)
+		if i+1 < len(bins) {
+			x1 = bins[i+1].Offset
+		}
+		lo, hi := max(start, bin.Offset), min(end, x1)
+		if lo >= hi || !bin.Filter.containsAny(keys) {
+			continue
+		}
+		slices = append(slices, slicer.Slice{Offset: lo, Length: hi - lo})
+	}
+	return slices
+}
+
+func (f BloomFilter) containsAny(keys []FlowKey) bool {
+	for _, key := range keys {
+		if f.Contains(key) {
+			return true
+		}
+	}
+	return false
+}
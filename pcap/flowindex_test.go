@@ -0,0 +1,107 @@
+package pcap_test
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/brimdata/brimcap/pcap"
+	"github.com/brimdata/brimcap/pcap/pcapio"
+	"github.com/brimdata/zed/pkg/nano"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBloomFilter(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	keys := make([]pcap.FlowKey, 1000)
+	for i := range keys {
+		keys[i] = pcap.FlowKey(rng.Uint64())
+	}
+	f := pcap.NewBloomFilter(keys)
+	for _, key := range keys {
+		assert.True(t, f.Contains(key))
+	}
+	var positives int
+	for i := 0; i < 10000; i++ {
+		if f.Contains(pcap.FlowKey(rng.Uint64())) {
+			positives++
+		}
+	}
+	assert.Less(t, positives, 300)
+	assert.False(t, pcap.NewBloomFilter(nil).Contains(keys[0]))
+}
+
+func TestFlowKeys(t *testing.T) {
+	flow := pcap.NewFlow(inner0, 1234, inner1, 80)
+	reverse := pcap.NewFlow(inner1, 80, inner0, 1234)
+	assert.Equal(t, pcap.TupleKey(layers.IPProtocolTCP, flow), pcap.TupleKey(layers.IPProtocolTCP, reverse))
+	assert.NotEqual(t, pcap.TupleKey(layers.IPProtocolTCP, flow), pcap.TupleKey(layers.IPProtocolUDP, flow))
+	assert.Equal(t, pcap.PairKey(inner0, inner1), pcap.PairKey(inner1, inner0.To16()))
+}
+
+func TestGenerateSlicesFlows(t *testing.T) {
+	tcp := func(src, dst net.IP, sport, dport layers.TCPPort) []byte {
+		return serialize(t, ether(layers.EthernetTypeIPv4), ipv4(layers.IPProtocolTCP, src, dst),
+			&layers.TCP{SrcPort: sport, DstPort: dport, ACK: true})
+	}
+	// The flow of inner0 and inner1 is followed by that of inner0 and
+	// outer1 and by tunneled packets of the former, one packet per bin.
+	packets := [][]byte{
+		tcp(inner0, inner1, 1234, 80),
+		tcp(inner1, inner0, 80, 1234),
+		tcp(inner0, outer1, 1234, 80),
+		tcp(outer1, inner0, 80, 1234),
+	}
+	packets = append(packets, tunnelPackets(t)[1:]...)
+	var buf bytes.Buffer
+	w := pcapio.NewNgWriter(&buf)
+	require.NoError(t, w.WriteInterface(pcapio.NgInterface{LinkType: layers.LinkTypeEthernet, TimestampResolution: 9}))
+	for i, data := range packets {
+		p := pcapio.Packet{Ts: nano.Ts(i), LinkType: layers.LinkTypeEthernet, Length: len(data), Data: data}
+		require.NoError(t, w.WritePacket(p))
+	}
+	index, err := pcap.CreateIndexWithOptions(bytes.NewReader(buf.Bytes()), pcap.IndexOptions{Size: len(packets), Flows: true})
+	require.NoError(t, err)
+	require.Len(t, index[0].Flows, len(packets))
+
+	span := nano.Span{Ts: 0, Dur: nano.Duration(len(packets))}
+	flow0 := pcap.NewTCPSearch(span, pcap.NewFlow(inner0, 1234, inner1, 80))
+	flow1 := pcap.NewTCPSearch(span, pcap.NewFlow(inner0, 1234, outer1, 80))
+	absent := pcap.NewUDPSearch(span, pcap.NewFlow(inner0, 1234, inner1, 80))
+	search := func(search pcap.Search) []nano.Ts {
+		slicer, err := pcap.NewSlicer(bytes.NewReader(buf.Bytes()), index, search)
+		require.NoError(t, err)
+		if slicer == nil {
+			return nil
+		}
+		r, err := pcapio.NewReader(slicer)
+		require.NoError(t, err)
+		var out bytes.Buffer
+		err = search.Run(context.Background(), &out, r)
+		if err == pcap.ErrNoPcapsFound {
+			return nil
+		}
+		require.NoError(t, err)
+		return readTimestamps(t, &out)
+	}
+	assert.Equal(t, []nano.Ts{0, 1, 4, 5, 6, 7, 8}, search(flow0))
+	assert.Equal(t, []nano.Ts{2, 3}, search(flow1))
+	assert.Equal(t, []nano.Ts{0, 1, 2, 3, 4, 5, 6, 7, 8}, search(pcap.Union(flow0, flow1)))
+	assert.Equal(t, []nano.Ts{2, 3}, search(pcap.Union(flow1, absent)))
+	assert.Equal(t, []nano.Ts{0, 1, 4, 5, 6, 7, 8}, search(flow0.WithDefrag(true)))
+
+	slices, err := pcap.GenerateSlices(index, absent)
+	require.NoError(t, err)
+	assert.Nil(t, slices)
+	// Only the section header and interface blocks and the two adjacent
+	// bins of the flow of inner0 and outer1 are read.
+	slices, err = pcap.GenerateSlices(index, flow1)
+	require.NoError(t, err)
+	require.Len(t, slices, 3)
+	assert.Equal(t, index[0].Flows[2].Offset, slices[2].Offset)
+	assert.Equal(t, index[0].Flows[4].Offset, slices[2].Offset+slices[2].Length)
+}
@@ -3,16 +3,21 @@ package pcap
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"unsafe"
 
+	"github.com/brimdata/brimcap/decompress"
 	"github.com/brimdata/brimcap/pcap/pcapio"
 	"github.com/brimdata/brimcap/ranger"
 	"github.com/brimdata/brimcap/slicer"
 	"github.com/brimdata/zed/pkg/nano"
+	"github.com/brimdata/zed/pkg/peeker"
 )
 
+// Index is a time index of a pcap made of its sections in order.  The offsets
+// in the index of a compressed pcap refer to the decompressed pcap.
 type Index []Section
 
 // Span returns the entire time span covered by the index.
@@ -35,8 +40,28 @@ func (i Index) Span() nano.Span {
 // there is just one section at the beginning of the file.  For nextgen pcaps,
 // there can be multiple sections.
 type Section struct {
+	// Blocks holds the blocks other than packets and interface
+	// statistics, which are read before the packets of any slice of the
+	// section (see GenerateSlices).
 	Blocks []slicer.Slice
 	Index  ranger.Envelope
+	// Flows, if the index was created with IndexOptions.Flows, holds
+	// summaries of the flows of the section's packets that let searches
+	// skip packets of other flows (see GenerateSlices).
+	Flows []FlowBin `json:",omitempty"`
+	// Checkpoints, if the pcap is compressed, holds the points within the
+	// section from which decompression can be restarted (see
+	// decompress.Reader).
+	Checkpoints []decompress.Checkpoint `json:",omitempty"`
+}
+
+// checkpoints returns the decompression checkpoints of all of the sections.
+func (i Index) checkpoints() []decompress.Checkpoint {
+	var checkpoints []decompress.Checkpoint
+	for _, s := range i {
+		checkpoints = append(checkpoints, s.Checkpoints...)
+	}
+	return checkpoints
 }
 
 const (
@@ -55,13 +80,84 @@ func CreateIndex(r io.Reader, size int) (Index, error) {
 }
 
 func CreateIndexWithWarnings(r io.Reader, size int, w pcapio.Warner) (Index, error) {
-	reader, err := pcapio.NewReaderWithWarnings(r, w)
+	return CreateIndexWithOptions(r, IndexOptions{Size: size, Warner: w})
+}
+
+// IndexOptions are the options of CreateIndexWithOptions.
+type IndexOptions struct {
+	// Size is the number of bins the index should contain.
+	Size int
+	// Flows adds a summary of the flows of each section's packets, which
+	// takes decoding every packet, bounded by Size bins.
+	Flows  bool
+	Warner pcapio.Warner
+}
+
+func CreateIndexWithOptions(r io.Reader, opts IndexOptions) (Index, error) {
+	index, _, err := createIndex(r, opts)
+	return index, err
+}
+
+// createIndex is like CreateIndexWithOptions but also returns the indexer,
+// which tells where indexing stopped and how many packets it read.
+func createIndex(r io.Reader, opts IndexOptions) (Index, *indexer, error) {
+	dr, err := decompress.NewReader(r)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	var offsets []ranger.Point
-	var index Index
-	var section *Section
+	reader, err := pcapio.NewDecompressedReader(dr, opts.Warner)
+	if err != nil {
+		return nil, nil, err
+	}
+	x := &indexer{opts: opts, reader: reader, compression: dr.Format}
+	if err := x.run(0, 0); err != nil {
+		return nil, nil, err
+	}
+	if len(x.sections) == 0 {
+		return nil, nil, ErrNoPcapsFound
+	}
+	if dr.Format != decompress.None {
+		addCheckpoints(x.sections, dr.Checkpoints())
+	}
+	return x.sections, x, nil
+}
+
+// addCheckpoints adds each of checkpoints to the section it falls in.
+func addCheckpoints(sections []Section, checkpoints []decompress.Checkpoint) {
+	var i int
+	for _, c := range checkpoints {
+		for i+1 < len(sections) && sections[i+1].Blocks[0].Offset <= c.Offset {
+			i++
+		}
+		sections[i].Checkpoints = append(sections[i].Checkpoints, c)
+	}
+}
+
+// indexer collects the sections of an index from the blocks read by reader.
+type indexer struct {
+	opts    IndexOptions
+	reader  pcapio.Reader
+	offsets []ranger.Point
+	// sections holds the completed sections and section the current one.
+	sections []Section
+	section  *Section
+	flows    *flowIndexer
+	// end is the offset after the last block indexed and packets the
+	// number of packets indexed.
+	end     uint64
+	packets uint64
+	// compression is the compression format of the pcap.
+	compression decompress.Format
+}
+
+// run indexes the blocks read by reader until the end of its input or a
+// truncated block.  The blocks in the first skip bytes of the input are read
+// but not indexed, and base is added to the offsets of the others (less skip)
+// so an index can be resumed from the middle of a pcap (see UpdateIndexFile).
+func (x *indexer) run(skip, base uint64) error {
+	size := x.opts.Size
+	reader := x.reader
+	x.end = base
 	for {
 		off := reader.Offset()
 		block, typ, err := reader.Read()
@@ -69,80 +165,144 @@ func CreateIndexWithWarnings(r io.Reader, size int, w pcapio.Warner) (Index, err
 			if err == io.EOF {
 				break
 			}
-			return nil, err
+			if errors.Is(err, peeker.ErrTruncated) && off >= skip {
+				// The pcap may still be being written so the
+				// truncated block is left for a later update.
+				if x.opts.Warner != nil {
+					x.opts.Warner.Warn(fmt.Sprintf("truncated block at offset %d not indexed", base+off-skip))
+				}
+				break
+			}
+			return err
 		}
 		if block == nil {
 			break
 		}
+		if off < skip {
+			continue
+		}
+		off += base - skip
+		x.end = off + uint64(len(block))
 		switch typ {
 		case pcapio.TypePacket:
-			pkt, ts, _, err := reader.Packet(block)
+			pkt, ts, linkType, err := reader.Packet(block)
 			if pkt == nil {
-				return nil, err
+				return err
+			}
+			x.packets++
+			simple := false
+			if ng, ok := reader.(*pcapio.NgReader); ok {
+				simple = ng.IsSimplePacket(block)
+			}
+			if x.flows != nil {
+				x.flows.add(off, pkt, linkType, !simple)
+			}
+			if simple {
+				// A slice can't begin with a simple packet since
+				// it takes its timestamp from the blocks before
+				// it so simple packets are covered by the bin of
+				// the preceding enhanced packet.
+				continue
 			}
 			y := uint64(ts)
-			offsets = append(offsets, ranger.Point{X: off, Y: y})
+			x.offsets = append(x.offsets, ranger.Point{X: off, Y: y})
 			// In order to avoid running out of memory for large pcap sections,
 			// condense offsets with ranger.NewEnvelope once offsetThresh has
 			// been reached.
-			if len(offsets) > offsetThresh {
-				env := ranger.NewEnvelope(offsets, size)
-				section.Index = env.Merge(section.Index)
-				offsets = offsets[:0]
+			if len(x.offsets) > offsetThresh {
+				env := ranger.NewEnvelope(x.offsets, size)
+				x.section.Index = env.Merge(x.section.Index)
+				x.offsets = x.offsets[:0]
 			}
 
 		case pcapio.TypeSection:
 			// end previous section and start a new one
-			if section == nil && offsets != nil {
+			if x.section == nil && x.offsets != nil {
 				err := errors.New("missing section header")
-				return nil, pcapio.NewErrInvalidPcap(err)
+				return pcapio.NewErrInvalidPcap(err)
 			}
-			if section != nil {
-				if offsets != nil {
-					env := ranger.NewEnvelope(offsets, size)
-					section.Index = env.Merge(section.Index)
-				}
-				index = append(index, *section)
+			if x.section != nil {
+				x.endSection()
 			}
 			slice := slicer.Slice{
 				Offset: off,
 				Length: uint64(len(block)),
 			}
-			section = &Section{
+			x.section = &Section{
 				Blocks: []slicer.Slice{slice},
 			}
-			offsets = offsets[:0]
+			x.offsets = x.offsets[:0]
+			if x.opts.Flows {
+				x.flows = newFlowIndexer(size)
+			}
+
+		case pcapio.TypeInterfaceStatistics:
+			// Statistics describe the whole capture and aren't
+			// meaningful for a slice of it.
 
 		default:
-			if section == nil {
+			// Interface descriptions, name resolution records,
+			// and decryption secrets are read along with every
+			// slice of the section.  A search writes the name
+			// resolution records whole but only the TLS key log
+			// lines of the sessions it matches (see
+			// SearchReader), so secrets needn't be indexed apart.
+			if x.section == nil {
 				err := errors.New("missing section header")
-				return nil, pcapio.NewErrInvalidPcap(err)
+				return pcapio.NewErrInvalidPcap(err)
 			}
 			slice := slicer.Slice{
 				Offset: off,
 				Length: uint64(len(block)),
 			}
-			section.Blocks = append(section.Blocks, slice)
+			x.section.Blocks = append(x.section.Blocks, slice)
 		}
 	}
 	// end last section
-	if section != nil && offsets != nil {
-		env := ranger.NewEnvelope(offsets, size)
-		section.Index = env.Merge(section.Index)
-		index = append(index, *section)
+	if x.section != nil && (x.offsets != nil || len(x.section.Index) > 0) {
+		x.endSection()
+	}
+	return nil
+}
+
+// endSection adds the packets collected for the current section to its
+// bins and appends it to the completed sections.
+func (x *indexer) endSection() {
+	if x.offsets != nil {
+		env := ranger.NewEnvelope(x.offsets, x.opts.Size)
+		if len(x.section.Index)+len(env) <= x.opts.Size {
+			// The bins of a resumed section are extended
+			// without coarsening them while there's room.
+			x.section.Index = append(x.section.Index, env...)
+		} else {
+			x.section.Index = env.Merge(x.section.Index)
+		}
 	}
-	if len(index) == 0 {
-		return nil, ErrNoPcapsFound
+	if x.flows != nil {
+		x.section.Flows = condenseFlowBins(append(x.section.Flows, x.flows.flowBins()...), x.opts.Size)
 	}
-	return index, nil
+	x.sections = append(x.sections, *x.section)
+	x.section = nil
 }
 
+// LoadIndex loads the index in the index file format (see WriteIndexFile) or
+// in JSON at path.
 func LoadIndex(path string) (Index, error) {
+	_, index, err := LoadIndexFile(path)
+	return index, err
+}
+
+// LoadIndexFile is like LoadIndex but also returns the header of the index,
+// which is zero for an index in JSON.
+func LoadIndexFile(path string) (IndexHeader, Index, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
-		return nil, err
+		return IndexHeader{}, nil, err
+	}
+	if IsIndexFile(b) {
+		return ReadIndexFile(b)
 	}
 	var index Index
 	err = json.Unmarshal(b, &index)
-	return index, err
+	return IndexHeader{}, index, err
 }
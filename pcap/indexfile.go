@@ -0,0 +1,344 @@
+package pcap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/brimdata/brimcap/decompress"
+	"github.com/brimdata/brimcap/pcap/pcapio"
+	"github.com/brimdata/zed/pkg/nano"
+)
+
+// IndexVersion is the version of the index file format written by
+// WriteIndexFile.  It changes whenever the encoding of an index or the
+// meaning of its contents does.
+const IndexVersion = 1
+
+// indexMagic begins an index file.  An index file continues with its
+// version as a little-endian uint32, the gob encodings of its IndexHeader
+// and its Index, and the CRC-32 (IEEE) of all that as a little-endian
+// uint32.
+var indexMagic = []byte("BRIMCAPX")
+
+// ErrStaleIndex is an error indicating an index doesn't describe the current
+// contents of its pcap.
+var ErrStaleIndex = errors.New("stale index")
+
+// IndexHeader describes the pcap an index was created for and how.
+//
+// IndexHeader and Index are written with encoding/gob, which identifies
+// struct fields by name, so renaming or changing the type of one of their
+// fields (or of the types they hold) changes the index file format and
+// requires bumping IndexVersion.
+type IndexHeader struct {
+	// Version is the version of the index file format or zero for an
+	// index read from JSON, which has no header.
+	Version int
+	// PcapPath is the absolute path of the pcap if it was indexed from a
+	// file.
+	PcapPath string
+	// PcapSize and PcapModTime are the size and modification time of the
+	// pcap when it was indexed.  PcapModTime is zero if the pcap wasn't
+	// read from a regular file.
+	PcapSize    int64
+	PcapModTime nano.Ts
+	// PcapHash is the SHA-256 hash of the pcap's contents.  It names
+	// the pcap's entry in a brimcap root but is informational otherwise:
+	// Check doesn't hash the pcap again.
+	PcapHash []byte
+	// Indexed is the offset after the last block indexed, where
+	// UpdateIndexFile resumes indexing.  PcapHashState is the state of
+	// PcapHash for resuming it and PcapTailHash the SHA-256 hash of the
+	// tailSize bytes before Indexed for telling if the pcap was rewritten
+	// rather than appended to.
+	Indexed       uint64
+	PcapHashState []byte
+	PcapTailHash  []byte
+	// Packets is the number of packets indexed.
+	Packets uint64
+	// Compression is the compression format of the pcap, whose offsets in
+	// the index refer to the decompressed pcap.
+	Compression decompress.Format
+	// Limit and Flows are the IndexOptions the index was created with.
+	Limit int
+	Flows bool
+	// Created is when the index was created.
+	Created nano.Ts
+}
+
+// newIndexHeader returns the header of an index created with opts of the pcap
+// at path (which may be empty) read from f, whose first size bytes, with hash
+// hash, were read and indexed by x.  If info is not nil, it is that of the
+// pcap when it was opened for indexing.
+func newIndexHeader(f *os.File, path string, info fs.FileInfo, opts IndexOptions, x *indexer, size int64, hash hash.Hash) (IndexHeader, error) {
+	h := IndexHeader{
+		Version:  IndexVersion,
+		PcapPath: path,
+		PcapSize: size,
+		PcapHash: hash.Sum(nil),
+		Indexed:  x.end,
+		Packets:  x.packets,
+		Limit:    opts.Size,
+		Flows:    opts.Flows,
+		Created:  nano.Now(),
+
+		Compression: x.compression,
+	}
+	if info == nil || !info.Mode().IsRegular() {
+		return h, nil
+	}
+	h.PcapModTime = nano.TimeToTs(info.ModTime())
+	var err error
+	if h.PcapHashState, err = hash.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+		return IndexHeader{}, err
+	}
+	h.PcapTailHash, err = tailHash(f, x.end)
+	return h, err
+}
+
+// tailSize is the number of bytes hashed by tailHash.
+const tailSize = 4096
+
+// tailHash returns the SHA-256 hash of the tailSize bytes of f before end.
+func tailHash(f *os.File, end uint64) ([]byte, error) {
+	off := end - min(end, tailSize)
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(f, int64(off), int64(end-off))); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// CreateIndexFile indexes the pcap read from f with opts, returning the
+// index along with its header.  If f is a regular file, path is its
+// absolute path.
+func CreateIndexFile(f *os.File, path string, opts IndexOptions) (IndexHeader, Index, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return IndexHeader{}, nil, err
+	}
+	hash := sha256.New()
+	var size countWriter
+	r := io.TeeReader(f, io.MultiWriter(hash, &size))
+	index, x, err := createIndex(r, opts)
+	if err != nil {
+		return IndexHeader{}, nil, err
+	}
+	// Hash whatever follows the last block, too.
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return IndexHeader{}, nil, err
+	}
+	header, err := newIndexHeader(f, path, info, opts, x, int64(size), hash)
+	return header, index, err
+}
+
+// UpdateIndexFile is like CreateIndexFile but, if the pcap read from f has
+// only been appended to since it was indexed with header and index, reads
+// and indexes just the blocks appended, extending the bins of its last
+// section (see ranger.Envelope.Merge) and adding sections after it.  A pcap
+// that is compressed, that was indexed with options other than opts or from
+// other than a regular file, or whose indexed contents have changed is
+// indexed from the beginning.
+func UpdateIndexFile(f *os.File, path string, header IndexHeader, index Index, opts IndexOptions) (IndexHeader, Index, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return IndexHeader{}, nil, err
+	}
+	if !header.resumable(f, info, index, opts) {
+		return CreateIndexFile(f, path, opts)
+	}
+	if header.Check(path, info) == nil {
+		return header, index, nil
+	}
+	hash := sha256.New()
+	if err := hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(header.PcapHashState); err != nil {
+		return CreateIndexFile(f, path, opts)
+	}
+	// The blocks of the last section before the first packet (e.g., its
+	// section header and interface blocks) are read again to set up the
+	// reader, followed by the input after the last block indexed.
+	last := index[len(index)-1]
+	last.Blocks, last.Flows = slices.Clone(last.Blocks), slices.Clone(last.Flows)
+	var prefix bytes.Buffer
+	for _, b := range last.Blocks {
+		if _, err := io.Copy(&prefix, io.NewSectionReader(f, int64(b.Offset), int64(b.Length))); err != nil {
+			return IndexHeader{}, nil, err
+		}
+	}
+	skip := uint64(prefix.Len())
+	size := countWriter(header.Indexed)
+	// The bytes from Indexed up to PcapSize were hashed already.
+	hashed := &skipWriter{w: hash, skip: header.PcapSize - int64(header.Indexed)}
+	r := io.TeeReader(io.NewSectionReader(f, int64(header.Indexed), math.MaxInt64-int64(header.Indexed)), io.MultiWriter(hashed, &size))
+	reader, err := pcapio.NewReaderWithWarnings(io.MultiReader(&prefix, r), opts.Warner)
+	if err != nil {
+		return IndexHeader{}, nil, err
+	}
+	switch reader.(type) {
+	case *pcapio.PcapReader, *pcapio.NgReader:
+	default:
+		// Only pcap and pcap-ng indexes are resumed.
+		return CreateIndexFile(f, path, opts)
+	}
+	x := &indexer{
+		opts:     opts,
+		reader:   reader,
+		sections: slices.Clone(index[:len(index)-1]),
+		section:  &last,
+		packets:  header.Packets,
+	}
+	if opts.Flows {
+		// The flow bins of the packets appended follow those of the
+		// last section, whose Bloom filters can't be merged, so they
+		// get the room left.
+		x.flows = newFlowIndexer(opts.Size - len(last.Flows))
+	}
+	if err := x.run(skip, header.Indexed); err != nil {
+		return IndexHeader{}, nil, err
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return IndexHeader{}, nil, err
+	}
+	header, err = newIndexHeader(f, path, info, opts, x, int64(size), hash)
+	return header, x.sections, err
+}
+
+// resumable returns true if the index with header h can be updated with
+// UpdateIndexFile.
+func (h IndexHeader) resumable(f *os.File, info fs.FileInfo, index Index, opts IndexOptions) bool {
+	if h.Version != IndexVersion || h.PcapModTime == 0 || h.PcapHashState == nil || !info.Mode().IsRegular() ||
+		h.Compression != decompress.None || len(index) == 0 ||
+		info.Size() < h.PcapSize || h.Limit != opts.Size || h.Flows != opts.Flows {
+		return false
+	}
+	tail, err := tailHash(f, h.Indexed)
+	return err == nil && bytes.Equal(tail, h.PcapTailHash)
+}
+
+type countWriter int64
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	*c += countWriter(len(b))
+	return len(b), nil
+}
+
+// skipWriter writes to w all but the first skip bytes written to it.
+type skipWriter struct {
+	w    io.Writer
+	skip int64
+}
+
+func (s *skipWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	k := min(int64(n), s.skip)
+	s.skip -= k
+	if _, err := s.w.Write(b[k:]); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Check returns an error wrapping ErrStaleIndex if the pcap, at the absolute
+// path path (which may be empty if unknown) and with info, isn't the one
+// indexed or changed in size or modification time since it was indexed.  A
+// pcap replaced by another of the same size and modification time (e.g.,
+// copied with cp -p) goes unnoticed since its contents aren't hashed again.
+// An index without a header (i.e., read from JSON, as written by older
+// brimcaps) or of a pcap not read from a regular file can't be checked and is
+// always accepted.
+func (h IndexHeader) Check(path string, info fs.FileInfo) error {
+	if h.Version == 0 || h.PcapModTime == 0 {
+		return nil
+	}
+	if path != "" && h.PcapPath != "" && path != h.PcapPath {
+		return fmt.Errorf("%w: index is of %s", ErrStaleIndex, h.PcapPath)
+	}
+	if size := info.Size(); size != h.PcapSize {
+		return fmt.Errorf("%w: pcap is %d bytes but was %d bytes when indexed", ErrStaleIndex, size, h.PcapSize)
+	}
+	if ts := nano.TimeToTs(info.ModTime()); ts != h.PcapModTime {
+		return fmt.Errorf("%w: pcap was modified at %s after being indexed at %s", ErrStaleIndex, ts.Time().UTC().Format(time.RFC3339Nano), h.PcapModTime.Time().UTC().Format(time.RFC3339Nano))
+	}
+	return nil
+}
+
+// IndexedSize returns the number of bytes of a pcap of size bytes that its
+// index, with header h, covers.  That excludes a block that was still being
+// written at the end of an uncompressed pcap when it was indexed (see
+// UpdateIndexFile), which can't be read.
+func IndexedSize(h IndexHeader, size int64) int64 {
+	if h.Version == 0 || h.Compression != decompress.None {
+		return size
+	}
+	return min(size, int64(h.Indexed))
+}
+
+// WriteIndexFile writes index with header h in the index file format.
+func WriteIndexFile(w io.Writer, h IndexHeader, index Index) error {
+	var buf bytes.Buffer
+	buf.Write(indexMagic)
+	binary.Write(&buf, binary.LittleEndian, uint32(IndexVersion))
+	enc := gob.NewEncoder(&buf)
+	h.Version = IndexVersion
+	if err := enc.Encode(h); err != nil {
+		return err
+	}
+	if err := enc.Encode(indexBody{Sections: index}); err != nil {
+		return err
+	}
+	binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// IsIndexFile returns true if b begins like an index file.
+func IsIndexFile(b []byte) bool {
+	return bytes.HasPrefix(b, indexMagic)
+}
+
+// ReadIndexFile decodes an index file held in b.
+func ReadIndexFile(b []byte) (IndexHeader, Index, error) {
+	if !IsIndexFile(b) {
+		return IndexHeader{}, nil, errors.New("not a brimcap index file")
+	}
+	if len(b) < len(indexMagic)+8 {
+		return IndexHeader{}, nil, errors.New("index file is truncated")
+	}
+	if v := binary.LittleEndian.Uint32(b[len(indexMagic):]); v != IndexVersion {
+		return IndexHeader{}, nil, fmt.Errorf("index file version %d is not supported (expected version %d): re-create the index", v, IndexVersion)
+	}
+	n := len(b) - 4
+	if crc32.ChecksumIEEE(b[:n]) != binary.LittleEndian.Uint32(b[n:]) {
+		return IndexHeader{}, nil, errors.New("index file is corrupt: checksum mismatch")
+	}
+	dec := gob.NewDecoder(bytes.NewReader(b[len(indexMagic)+4 : n]))
+	var h IndexHeader
+	if err := dec.Decode(&h); err != nil {
+		return IndexHeader{}, nil, fmt.Errorf("index file is corrupt: %w", err)
+	}
+	var body indexBody
+	if err := dec.Decode(&body); err != nil {
+		return IndexHeader{}, nil, fmt.Errorf("index file is corrupt: %w", err)
+	}
+	return h, body.Sections, nil
+}
+
+// indexBody is the encoding of an Index in an index file.  The sections are
+// held in a struct so that fields can be added alongside them without
+// changing the format.
+type indexBody struct {
+	Sections []Section
+}
@@ -0,0 +1,130 @@
+package pcap_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brimdata/brimcap/pcap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "in.pcap")
+	b, err := os.ReadFile("../cmd/brimcap/ztests/in.pcap")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, b, 0644))
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	opts := pcap.IndexOptions{Size: 4, Flows: true}
+	header, index, err := pcap.CreateIndexFile(f, path, opts)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(b)), header.PcapSize)
+	assert.Equal(t, uint64(9), header.Packets)
+	assert.Len(t, header.PcapHash, 32)
+	assert.Equal(t, 4, header.Limit)
+	assert.True(t, header.Flows)
+
+	var buf bytes.Buffer
+	require.NoError(t, pcap.WriteIndexFile(&buf, header, index))
+	h, i, err := pcap.ReadIndexFile(buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, pcap.IndexVersion, h.Version)
+	assert.Equal(t, header.PcapHash, h.PcapHash)
+	assert.Equal(t, index.Span(), i.Span())
+	assert.Equal(t, index[0].Flows, i[0].Flows)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.NoError(t, h.Check(path, info))
+	assert.NoError(t, h.Check("", info))
+	assert.ErrorIs(t, h.Check(path+".moved", info), pcap.ErrStaleIndex)
+	assert.NoError(t, pcap.IndexHeader{}.Check(path, info))
+	require.NoError(t, os.Chtimes(path, time.Time{}, info.ModTime().Add(time.Second)))
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.ErrorIs(t, h.Check(path, info), pcap.ErrStaleIndex)
+	require.NoError(t, os.WriteFile(path, b[:len(b)-1], 0644))
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.ErrorIs(t, h.Check(path, info), pcap.ErrStaleIndex)
+
+	corrupt := bytes.Clone(buf.Bytes())
+	corrupt[len(corrupt)/2] ^= 0xff
+	_, _, err = pcap.ReadIndexFile(corrupt)
+	assert.EqualError(t, err, "index file is corrupt: checksum mismatch")
+	future := bytes.Clone(buf.Bytes())
+	future[8] = pcap.IndexVersion + 1
+	_, _, err = pcap.ReadIndexFile(future)
+	assert.EqualError(t, err, "index file version 2 is not supported (expected version 1): re-create the index")
+}
+
+func TestUpdateIndexFile(t *testing.T) {
+	for _, name := range []string{"in.pcap", "ng.pcap"} {
+		t.Run(name, func(t *testing.T) {
+			b, err := os.ReadFile(filepath.Join("../cmd/brimcap/ztests", name))
+			require.NoError(t, err)
+			path := filepath.Join(t.TempDir(), name)
+			index := func(update func(*os.File) (pcap.IndexHeader, pcap.Index, error)) (pcap.IndexHeader, pcap.Index) {
+				f, err := os.Open(path)
+				require.NoError(t, err)
+				defer f.Close()
+				header, index, err := update(f)
+				require.NoError(t, err)
+				return header, index
+			}
+			// Index the pcap while it's written, ending mid-block.
+			grow := func(opts pcap.IndexOptions) (pcap.IndexHeader, pcap.Index) {
+				require.NoError(t, os.WriteFile(path, b[:len(b)/3], 0644))
+				header, idx := index(func(f *os.File) (pcap.IndexHeader, pcap.Index, error) {
+					return pcap.CreateIndexFile(f, path, opts)
+				})
+				for _, n := range []int{len(b) / 2, len(b)} {
+					require.NoError(t, os.WriteFile(path, b[:n], 0644))
+					header, idx = index(func(f *os.File) (pcap.IndexHeader, pcap.Index, error) {
+						return pcap.UpdateIndexFile(f, path, header, idx, opts)
+					})
+				}
+				return header, idx
+			}
+			create := func(opts pcap.IndexOptions) (pcap.IndexHeader, pcap.Index) {
+				return index(func(f *os.File) (pcap.IndexHeader, pcap.Index, error) {
+					return pcap.CreateIndexFile(f, path, opts)
+				})
+			}
+
+			// With room for a bin per packet, the index is the same as
+			// one created at once.
+			opts := pcap.IndexOptions{Size: 100}
+			header, idx := grow(opts)
+			expected, expectedIndex := create(opts)
+			assert.Equal(t, expected.PcapHash, header.PcapHash)
+			assert.Equal(t, expected.Indexed, header.Indexed)
+			assert.Equal(t, expected.Packets, header.Packets)
+			assert.Equal(t, expectedIndex, idx)
+
+			// Otherwise, bins are merged to stay within the limit.
+			opts = pcap.IndexOptions{Size: 2, Flows: true}
+			header, idx = grow(opts)
+			expected, expectedIndex = create(opts)
+			assert.Equal(t, expected.PcapHash, header.PcapHash)
+			assert.Equal(t, expectedIndex.Span(), idx.Span())
+			assert.LessOrEqual(t, len(idx[0].Index), 2)
+			assert.LessOrEqual(t, len(idx[0].Flows), 2)
+
+			// A pcap that was rewritten is indexed from the beginning.
+			rewritten := bytes.Clone(b)
+			rewritten[len(b)-10] ^= 0xff
+			require.NoError(t, os.WriteFile(path, append(rewritten, b[len(b)-10:]...), 0644))
+			expected, _ = create(opts)
+			header, _ = index(func(f *os.File) (pcap.IndexHeader, pcap.Index, error) {
+				return pcap.UpdateIndexFile(f, path, header, idx, opts)
+			})
+			assert.Equal(t, expected.PcapHash, header.PcapHash)
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package pcapio
+
+import (
+	"io"
+
+	"github.com/brimdata/brimcap/recorder"
+)
+
+// ConvertForLibpcap returns an io.ReadCloser holding the capture read from r
+// in a format that libpcap-based tools like Zeek and Suricata can read.  Snoop
+// and ERF captures are converted on the fly to pcap-ng while any other input,
+// including legacy pcap and pcap-ng, is returned unchanged.  The returned
+// boolean indicates whether the capture was converted.  The caller must close
+// the returned io.ReadCloser to stop a conversion that is not read to the end.
+func ConvertForLibpcap(r io.Reader) (io.ReadCloser, bool, error) {
+	record := recorder.NewRecorder(r)
+	track := recorder.NewTrack(record)
+	for _, format := range formats {
+		track.Reset()
+		if _, err := format.new(newPeeker(track)); err != nil {
+			continue
+		}
+		if format.name == "pcap" || format.name == "pcapng" {
+			break
+		}
+		reader, err := format.new(newPeeker(record))
+		if err != nil {
+			return nil, false, err
+		}
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(Copy(NewNgWriter(pw), reader))
+		}()
+		return pr, true, nil
+	}
+	return io.NopCloser(record), false, nil
+}
+
+// Copy writes the capture read from r to w.  Sections and interfaces are
+// described to w as they are read (for legacy pcap and snoop input, a single
+// section with one interface describing the file) and each packet is written
+// on the interface it was read from.  Decryption secrets are copied if w
+// implements SecretsWriter.  Name resolution and interface statistics blocks
+// are not copied.  If w cannot represent the capture, Copy fails with an
+// error wrapping ErrUnrepresentable.
+func Copy(w Writer, r Reader) error {
+	for {
+		block, typ, err := r.Read()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if block == nil {
+			return nil
+		}
+		switch typ {
+		case TypeSection:
+			var info NgSectionInfo
+			if ng, ok := r.(*NgReader); ok {
+				info = ng.SectionHeader(block)
+			}
+			if err = w.WriteSection(info); err == nil {
+				if intf, ok := fileInterface(r); ok {
+					err = w.WriteInterface(intf)
+				}
+			}
+		case TypeInterface:
+			if ng, ok := r.(*NgReader); ok {
+				err = w.WriteInterface(ng.ifaces[len(ng.ifaces)-1])
+			}
+		case TypeDecryptionSecrets:
+			err = copySecrets(w, r, block)
+		case TypePacket:
+			var pkt Packet
+			pkt, err = DecodePacket(r, block)
+			if err == nil {
+				err = w.WritePacket(pkt)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// SecretsWriter is implemented by Writers that can hold decryption secrets.
+type SecretsWriter interface {
+	WriteDecryptionSecrets(NgDecryptionSecrets) error
+}
+
+func copySecrets(w Writer, r Reader, block []byte) error {
+	sw, ok := w.(SecretsWriter)
+	if !ok {
+		return nil
+	}
+	secrets, err := r.(*NgReader).DecryptionSecrets(block)
+	if err != nil {
+		return err
+	}
+	return sw.WriteDecryptionSecrets(secrets)
+}
+
+// fileInterface returns the interface that describes every packet of a
+// format with a single link type per file.
+func fileInterface(r Reader) (NgInterface, bool) {
+	switch r := r.(type) {
+	case *PcapReader:
+		resolution := NgResolution(6)
+		if r.nanoSecsFactor == 1 {
+			resolution = 9
+		}
+		return NgInterface{
+			LinkType:            r.LinkType,
+			SnapLength:          r.snaplen,
+			TimestampResolution: resolution,
+		}, true
+	case *SnoopReader:
+		return NgInterface{
+			LinkType:            r.LinkType,
+			TimestampResolution: 6,
+		}, true
+	}
+	return NgInterface{}, false
+}
@@ -0,0 +1,102 @@
+package pcapio
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+
+	"github.com/brimdata/zed/pkg/nano"
+)
+
+// Deduper detects duplicate packets like editcap -w: a packet is a duplicate
+// if an earlier packet with the same captured data and original length was
+// seen within the window before (or, for input that is not quite in
+// timestamp order, after) it.  Only the first of a run of duplicates is
+// remembered so that duplicates are always measured from the packet kept.
+type Deduper struct {
+	window nano.Duration
+	seen   map[[md5.Size]byte]nano.Ts
+	// queue holds the packets remembered in seen in the order they were
+	// seen so they can be forgotten once out of the window.
+	queue []dedupEntry
+	max   nano.Ts
+}
+
+type dedupEntry struct {
+	ts   nano.Ts
+	hash [md5.Size]byte
+}
+
+// NewDeduper returns a Deduper that detects duplicates within window.
+func NewDeduper(window nano.Duration) *Deduper {
+	return &Deduper{window: window, seen: make(map[[md5.Size]byte]nano.Ts)}
+}
+
+// Duplicate returns true if the packet with timestamp ts, captured data data,
+// and original length length duplicates an earlier packet.  Otherwise, the
+// packet is remembered.
+func (d *Deduper) Duplicate(ts nano.Ts, data []byte, length int) bool {
+	d.expire(ts)
+	h := md5.New()
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(length))
+	h.Write(b[:])
+	h.Write(data)
+	var hash [md5.Size]byte
+	h.Sum(hash[:0])
+	if prev, ok := d.seen[hash]; ok && abs(ts-prev) <= nano.Ts(d.window) {
+		return true
+	}
+	d.seen[hash] = ts
+	d.queue = append(d.queue, dedupEntry{ts, hash})
+	return false
+}
+
+// expire forgets the packets that are out of the window of the latest
+// packet seen.
+func (d *Deduper) expire(ts nano.Ts) {
+	d.max = max(d.max, ts)
+	n := 0
+	for ; n < len(d.queue) && d.queue[n].ts < d.max-nano.Ts(d.window); n++ {
+		e := d.queue[n]
+		if d.seen[e.hash] == e.ts {
+			delete(d.seen, e.hash)
+		}
+	}
+	d.queue = d.queue[n:]
+}
+
+func abs(ts nano.Ts) nano.Ts {
+	if ts < 0 {
+		return -ts
+	}
+	return ts
+}
+
+// DedupWriter is a Writer that drops the packets that duplicate earlier ones
+// (see Deduper) before writing to an underlying Writer.
+type DedupWriter struct {
+	Writer
+	deduper *Deduper
+}
+
+// NewDedupWriter returns a DedupWriter writing to w that drops duplicates
+// within window.
+func NewDedupWriter(w Writer, window nano.Duration) *DedupWriter {
+	return &DedupWriter{Writer: w, deduper: NewDeduper(window)}
+}
+
+func (w *DedupWriter) WritePacket(p Packet) error {
+	if w.deduper.Duplicate(p.Ts, p.Data, p.Length) {
+		return nil
+	}
+	return w.Writer.WritePacket(p)
+}
+
+// WriteDecryptionSecrets passes secrets to the underlying Writer if it is a
+// SecretsWriter.
+func (w *DedupWriter) WriteDecryptionSecrets(secrets NgDecryptionSecrets) error {
+	if sw, ok := w.Writer.(SecretsWriter); ok {
+		return sw.WriteDecryptionSecrets(secrets)
+	}
+	return nil
+}
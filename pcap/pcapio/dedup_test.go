@@ -0,0 +1,38 @@
+package pcapio_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/brimdata/brimcap/pcap/pcapio"
+	"github.com/brimdata/zed/pkg/nano"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeduper(t *testing.T) {
+	d := pcapio.NewDeduper(10)
+	a, b := []byte("abc"), []byte("abd")
+	assert.False(t, d.Duplicate(100, a, 60))
+	assert.False(t, d.Duplicate(101, b, 60))
+	assert.False(t, d.Duplicate(102, a, 61), "different original length")
+	assert.True(t, d.Duplicate(105, a, 60))
+	assert.True(t, d.Duplicate(98, b, 60), "out of order within window")
+	// Duplicates are measured from the first packet of a run.
+	assert.True(t, d.Duplicate(110, a, 60))
+	assert.False(t, d.Duplicate(111, a, 60))
+	assert.True(t, d.Duplicate(121, a, 60))
+	assert.False(t, d.Duplicate(200, b, 60))
+}
+
+func TestDedupWriter(t *testing.T) {
+	p0, p1 := testPackets[0], testPackets[1]
+	dup := p0
+	dup.Ts += nano.Ts(nano.Millisecond)
+	var buf bytes.Buffer
+	w := pcapio.NewDedupWriter(pcapio.NewNgWriter(&buf), nano.Second)
+	for _, p := range []pcapio.Packet{p0, dup, p1} {
+		require.NoError(t, w.WritePacket(p))
+	}
+	assert.Equal(t, []pcapio.Packet{p0, p1}, readAll(t, &buf))
+}
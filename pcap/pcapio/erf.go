@@ -0,0 +1,206 @@
+package pcapio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/brimdata/zed/pkg/nano"
+	"github.com/brimdata/zed/pkg/peeker"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// ErfReader implements the Reader interface to read packet data in the
+// Extensible Record Format written by Endace DAG cards.  ERF has no file
+// header so Read first returns an empty TypeSection block followed by a
+// TypePacket block for each record.  Since there is no magic, a stream is
+// recognized as ERF by checking that the leading records are well formed.
+//
+// The record header timestamp is a little-endian 32.32 fixed-point number
+// of seconds while the remaining header fields are big-endian.  Records of
+// types other than Ethernet, IPv4, and IPv6 (e.g., HDLC or ATM) cannot be
+// decoded so Read skips them with a warning.
+type ErfReader struct {
+	src     source
+	offset  uint64
+	started bool
+	warner  Warner
+	skipped map[byte]bool
+}
+
+const (
+	erfHeaderLen    = 16
+	erfExtHeaderLen = 8
+	// erfProbeRecords is the number of records checked by NewErfReader.
+	erfProbeRecords = 4
+)
+
+const (
+	erfTypeEth          = 2
+	erfTypeColorEth     = 11
+	erfTypeDSMColorEth  = 16
+	erfTypeColorHashEth = 20
+	erfTypeIPv4         = 22
+	erfTypeIPv6         = 23
+	erfTypeMax          = 48
+)
+
+// NewErfReader returns a new reader object, for reading packet data from
+// the given reader in ERF format.
+func NewErfReader(r io.Reader) (*ErfReader, error) {
+	return newErfReader(newPeeker(r))
+}
+
+func newErfReader(src source) (*ErfReader, error) {
+	reader := &ErfReader{src: src}
+	if err := reader.probe(); err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// probe checks that the leading records are well formed without consuming
+// them.
+func (r *ErfReader) probe() error {
+	var off int
+	for k := 0; k < erfProbeRecords; k++ {
+		b, err := r.src.Peek(off + erfHeaderLen)
+		if err != nil {
+			if k > 0 && (err == io.EOF || err == peeker.ErrTruncated) && len(b) == off {
+				return nil
+			}
+			if err == io.EOF || err == peeker.ErrTruncated {
+				return errInvalidf("erf file is too small to be valid")
+			}
+			return err
+		}
+		hdr := b[off:]
+		typ := hdr[8] & 0x7f
+		if typ == 0 || typ > erfTypeMax {
+			return errInvalidf("unknown erf record type %d", typ)
+		}
+		rlen := int(binary.BigEndian.Uint16(hdr[10:12]))
+		if rlen < erfHeaderLen {
+			return errInvalidf("erf record length too small: %d", rlen)
+		}
+		if binary.BigEndian.Uint16(hdr[14:16]) == 0 {
+			return errInvalidf("erf record has zero wire length")
+		}
+		off += rlen
+	}
+	return nil
+}
+
+func (r *ErfReader) SetWarningHandler(warner Warner) {
+	r.warner = warner
+}
+
+func (r *ErfReader) Read() ([]byte, BlockType, error) {
+	if !r.started {
+		r.started = true
+		return []byte{}, TypeSection, nil
+	}
+	for {
+		hdr, err := r.src.Peek(erfHeaderLen)
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return nil, 0, err
+		}
+		n := int(binary.BigEndian.Uint16(hdr[10:12]))
+		if n < erfHeaderLen {
+			return nil, 0, errInvalidf("erf record length too small: %d", n)
+		}
+		block, err := r.src.Read(n)
+		if err != nil {
+			return nil, 0, err
+		}
+		r.offset += uint64(n)
+		if typ := block[8] & 0x7f; erfLinkType(typ) == 0 {
+			r.skip(typ)
+			continue
+		}
+		return block, TypePacket, nil
+	}
+}
+
+// skip warns about the first record of each type that cannot be decoded.
+func (r *ErfReader) skip(typ byte) {
+	if r.skipped[typ] {
+		return
+	}
+	if r.skipped == nil {
+		r.skipped = make(map[byte]bool)
+	}
+	r.skipped[typ] = true
+	if r.warner != nil {
+		r.warner.Warn(fmt.Sprintf("skipping erf records of unsupported type %d", typ))
+	}
+}
+
+// erfLinkType returns the link type of the packets in records of type typ or
+// zero if they cannot be decoded.
+func erfLinkType(typ byte) layers.LinkType {
+	switch typ {
+	case erfTypeEth, erfTypeColorEth, erfTypeDSMColorEth, erfTypeColorHashEth:
+		return layers.LinkTypeEthernet
+	case erfTypeIPv4:
+		return layers.LinkTypeIPv4
+	case erfTypeIPv6:
+		return layers.LinkTypeIPv6
+	}
+	return 0
+}
+
+func (r *ErfReader) Packet(block []byte) ([]byte, nano.Ts, layers.LinkType, error) {
+	if len(block) < erfHeaderLen {
+		return nil, 0, 0, errInvalidf("packet buffer length less than minimum packet size")
+	}
+	typ := block[8]
+	hdrLen := erfHeaderLen
+	// Skip any extension headers.  Each one begins with a byte whose high
+	// bit indicates whether another extension header follows.
+	for more := typ&0x80 != 0; more; {
+		if len(block) < hdrLen+erfExtHeaderLen {
+			return nil, 0, 0, errInvalidf("truncated erf extension header")
+		}
+		more = block[hdrLen]&0x80 != 0
+		hdrLen += erfExtHeaderLen
+	}
+	linkType := erfLinkType(typ & 0x7f)
+	switch linkType {
+	case layers.LinkTypeEthernet:
+		// Ethernet records have two bytes of offset and padding
+		// preceding the frame.
+		hdrLen += 2
+	case 0:
+		return nil, 0, 0, errInvalidf("unsupported erf record type %d", typ&0x7f)
+	}
+	if len(block) < hdrLen {
+		return nil, 0, 0, errInvalidf("invalid capture length")
+	}
+	pkt := block[hdrLen:]
+	// Records may be padded beyond the end of the packet.
+	if wlen := r.origLen(block); len(pkt) > wlen {
+		pkt = pkt[:wlen]
+	}
+	ts := binary.LittleEndian.Uint64(block[0:8])
+	secs := ts >> 32
+	frac := (ts&0xffffffff*1_000_000_000 + 1<<31) >> 32
+	return pkt, nano.Ts(secs*1_000_000_000 + frac), linkType, nil
+}
+
+// Loss returns the number of packets lost between the previous record and
+// this record as reported by the loss counter in the record header.
+func (r *ErfReader) Loss(block []byte) uint16 {
+	return binary.BigEndian.Uint16(block[12:14])
+}
+
+func (r *ErfReader) origLen(block []byte) int {
+	return int(binary.BigEndian.Uint16(block[14:16]))
+}
+
+func (r *ErfReader) Offset() uint64 {
+	return r.offset
+}
@@ -0,0 +1,194 @@
+package pcapio
+
+import (
+	"container/heap"
+	"io"
+)
+
+// Merge writes the packets read from readers to w in timestamp order as a
+// single section.  Like mergecap, Merge assumes that the packets of each
+// reader are in timestamp order and repeatedly writes the earliest of the
+// readers' next packets, taking packets with equal timestamps in the order
+// of readers so that the output is deterministic.
+//
+// Interfaces are reconciled across readers and sections: each distinct
+// interface description (ignoring statistics) is written once, just before
+// the first packet captured on it.  Decryption secrets are copied if w
+// implements SecretsWriter.  Name resolution and interface statistics blocks
+// are not copied.
+func Merge(w Writer, readers ...Reader) error {
+	if err := w.WriteSection(NgSectionInfo{}); err != nil {
+		return err
+	}
+	m := &merger{w: w, ifaces: make(map[NgInterface]int)}
+	for k, r := range readers {
+		in := &mergeInput{reader: r, order: k}
+		ok, err := m.advance(in)
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Push(&m.inputs, in)
+		}
+	}
+	for len(m.inputs) > 0 {
+		in := m.inputs[0]
+		if err := m.write(in); err != nil {
+			return err
+		}
+		ok, err := m.advance(in)
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&m.inputs, 0)
+		} else {
+			heap.Pop(&m.inputs)
+		}
+	}
+	return nil
+}
+
+// NewMergeWriter returns a Writer to w for the output of Merge of readers.
+// If readers are all legacy pcaps with the same link type, snap length, and
+// timestamp resolution, it is a legacy pcap Writer so that merging legacy
+// pcaps yields a legacy pcap.  Otherwise, it is a pcap-ng Writer.
+func NewMergeWriter(w io.Writer, readers ...Reader) Writer {
+	if len(readers) == 0 {
+		return NewNgWriter(w)
+	}
+	first, _ := fileInterface(readers[0])
+	for _, r := range readers {
+		intf, _ := fileInterface(r)
+		if _, ok := r.(*PcapReader); !ok || intf != first {
+			return NewNgWriter(w)
+		}
+	}
+	return NewPcapWriter(w)
+}
+
+type merger struct {
+	w      Writer
+	inputs mergeHeap
+	// ifaces maps interface descriptions to the number of the output
+	// interface written for them.
+	ifaces map[NgInterface]int
+}
+
+type mergeInput struct {
+	reader Reader
+	order  int
+	packet Packet
+	// outputs maps the input interfaces of the current section to the
+	// numbers of their output interfaces.
+	outputs map[int]int
+}
+
+// advance reads the next packet of in, copying any decryption secrets that
+// precede it.  It returns false at the end of the input.
+func (m *merger) advance(in *mergeInput) (bool, error) {
+	for {
+		block, typ, err := in.reader.Read()
+		if err != nil {
+			if err == io.EOF {
+				return false, nil
+			}
+			return false, err
+		}
+		if block == nil {
+			return false, nil
+		}
+		switch typ {
+		case TypeSection:
+			in.outputs = nil
+		case TypeDecryptionSecrets:
+			if err := copySecrets(m.w, in.reader, block); err != nil {
+				return false, err
+			}
+		case TypePacket:
+			pkt, err := DecodePacket(in.reader, block)
+			if err != nil {
+				return false, err
+			}
+			in.packet = pkt
+			return true, nil
+		}
+	}
+}
+
+// write writes the next packet of in on its output interface, writing the
+// interface first if it is not yet described.
+func (m *merger) write(in *mergeInput) error {
+	pkt := in.packet
+	key, intf := packetInterface(in.reader, pkt)
+	ifno, ok := in.outputs[key]
+	if !ok {
+		desc := intf.description()
+		if ifno, ok = m.ifaces[desc]; !ok {
+			if err := m.w.WriteInterface(desc); err != nil {
+				return err
+			}
+			ifno = len(m.ifaces)
+			m.ifaces[desc] = ifno
+		}
+		if in.outputs == nil {
+			in.outputs = make(map[int]int)
+		}
+		in.outputs[key] = ifno
+	}
+	pkt.Interface = ifno
+	return m.w.WritePacket(pkt)
+}
+
+// packetInterface returns the interface a packet read from r was captured on
+// along with a key that identifies the interface within r's current section.
+func packetInterface(r Reader, pkt Packet) (int, NgInterface) {
+	if ng, ok := r.(*NgReader); ok && pkt.Interface < len(ng.ifaces) {
+		return pkt.Interface, ng.ifaces[pkt.Interface]
+	}
+	if intf, ok := fileInterface(r); ok {
+		return 0, intf
+	}
+	// ERF records carry their own link type and have timestamps finer
+	// than nanoseconds.
+	return int(pkt.LinkType), NgInterface{LinkType: pkt.LinkType, TimestampResolution: 9}
+}
+
+// description returns the interface without the fields that do not
+// describe it, i.e., its statistics and derived state, so that equal
+// descriptions compare equal.
+func (i NgInterface) description() NgInterface {
+	return NgInterface{
+		Name:                i.Name,
+		Comment:             i.Comment,
+		Description:         i.Description,
+		Filter:              i.Filter,
+		OS:                  i.OS,
+		LinkType:            i.LinkType,
+		TimestampResolution: i.TimestampResolution,
+		TimestampOffset:     i.TimestampOffset,
+		SnapLength:          i.SnapLength,
+	}
+}
+
+type mergeHeap []*mergeInput
+
+func (h mergeHeap) Len() int { return len(h) }
+
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].packet.Ts != h[j].packet.Ts {
+		return h[i].packet.Ts < h[j].packet.Ts
+	}
+	return h[i].order < h[j].order
+}
+
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*mergeInput)) }
+
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,75 @@
+package pcapio_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/brimdata/brimcap/pcap/pcapio"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeNg(t *testing.T, intf pcapio.NgInterface, pkts ...pcapio.Packet) pcapio.Reader {
+	var buf bytes.Buffer
+	w := pcapio.NewNgWriter(&buf)
+	require.NoError(t, w.WriteInterface(intf))
+	for _, p := range pkts {
+		require.NoError(t, w.WritePacket(p))
+	}
+	r, err := pcapio.NewReader(&buf)
+	require.NoError(t, err)
+	return r
+}
+
+func TestMerge(t *testing.T) {
+	eth := pcapio.NgInterface{LinkType: layers.LinkTypeEthernet, TimestampResolution: 9}
+	raw := pcapio.NgInterface{LinkType: layers.LinkTypeRaw, TimestampResolution: 9}
+	p0, p1, p2 := testPackets[0], testPackets[1], testPackets[2]
+	p2.Interface = 0
+	r0 := writeNg(t, eth, p0, p2)
+	r1 := writeNg(t, raw, p2)
+	r2 := writeNg(t, eth, p1)
+	var buf bytes.Buffer
+	require.NoError(t, pcapio.Merge(pcapio.NewNgWriter(&buf), r0, r1, r2))
+	pkts := readAll(t, &buf)
+	require.Len(t, pkts, 4)
+	// r0 and r2 share an interface while r1's is written second.
+	p2.Interface = 1
+	p2.Options = noOptions
+	assert.Equal(t, []pcapio.Packet{p0, p1, p2, p2}, pkts)
+}
+
+func writePcap(t *testing.T, intf pcapio.NgInterface, pkts ...pcapio.Packet) pcapio.Reader {
+	var buf bytes.Buffer
+	w := pcapio.NewPcapWriter(&buf)
+	require.NoError(t, w.WriteInterface(intf))
+	for _, p := range pkts {
+		require.NoError(t, w.WritePacket(p))
+	}
+	r, err := pcapio.NewReader(&buf)
+	require.NoError(t, err)
+	return r
+}
+
+func TestNewMergeWriter(t *testing.T) {
+	eth := pcapio.NgInterface{LinkType: layers.LinkTypeEthernet, TimestampResolution: 9, SnapLength: 65535}
+	micro := eth
+	micro.TimestampResolution = 6
+	p0, p1 := testPackets[0], testPackets[1]
+	legacy := func() []pcapio.Reader {
+		return []pcapio.Reader{writePcap(t, eth, p1), writePcap(t, eth, p0)}
+	}
+	assert.IsType(t, &pcapio.PcapWriter{}, pcapio.NewMergeWriter(nil, legacy()...))
+	assert.IsType(t, &pcapio.NgWriter{}, pcapio.NewMergeWriter(nil, writePcap(t, eth, p0), writePcap(t, micro, p1)))
+	assert.IsType(t, &pcapio.NgWriter{}, pcapio.NewMergeWriter(nil, writePcap(t, eth, p0), writeNg(t, eth, p1)))
+	assert.IsType(t, &pcapio.NgWriter{}, pcapio.NewMergeWriter(nil))
+	// Merging legacy pcaps yields a legacy pcap.
+	var buf bytes.Buffer
+	readers := legacy()
+	require.NoError(t, pcapio.Merge(pcapio.NewMergeWriter(&buf, readers...), readers...))
+	r, err := pcapio.NewReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.IsType(t, &pcapio.PcapReader{}, r)
+	assert.Equal(t, []pcapio.Packet{p0, p1}, readAll(t, &buf))
+}
@@ -11,10 +11,12 @@
 package pcapio
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"net"
 	"time"
 
 	"github.com/brimdata/zed/pkg/nano"
@@ -24,23 +26,39 @@ import (
 
 const PacketBlockHeaderLen = 28
 
+const simplePacketBlockHeaderLen = 12
+
 // NgReader wraps an underlying bufio.NgReader to read packet data in pcapng.
 type NgReader struct {
-	*peeker.Reader
+	src       source
 	ifaces    []NgInterface
 	first     []byte
 	bigEndian bool
 	offset    uint64
 	warner    Warner
+	// last is the timestamp of the most recent enhanced packet or
+	// interface statistics block in the current section and simple is
+	// the timestamp assigned to the simple packet block most recently
+	// returned by Read.
+	last   ngTimestamp
+	simple ngTimestamp
+}
+
+// ngTimestamp is a timestamp in the units of an interface.
+type ngTimestamp struct {
+	ifno int
+	ts   uint64
 }
 
 // NewNgReader initializes a new writer, reads the first section header,
 // and if necessary according to the options the first interface.
 func NewNgReader(r io.Reader) (*NgReader, error) {
-	ret := &NgReader{
-		Reader: peeker.NewReader(r, 32*1024, 1024*1024),
-	}
-	hdr, err := ret.Peek(12)
+	return newNgReader(newPeeker(r))
+}
+
+func newNgReader(src source) (*NgReader, error) {
+	ret := &NgReader{src: src}
+	hdr, err := ret.src.Peek(12)
 	if err != nil {
 		if err == peeker.ErrTruncated {
 			err = errInvalidf("pcap-ng file is too small to be valid")
@@ -78,6 +96,9 @@ func (r *NgReader) warn(format string, a ...interface{}) {
 }
 
 func (r *NgReader) parsePacket(block []byte) ([]byte, int, error) {
+	if r.IsSimplePacket(block) {
+		return r.parseSimplePacket(block)
+	}
 	if len(block) < PacketBlockHeaderLen {
 		return nil, 0, errInvalidf("packet buffer length less than minimum packet size")
 	}
@@ -93,36 +114,338 @@ func (r *NgReader) parsePacket(block []byte) ([]byte, int, error) {
 	return packet[:caplen], ifno, nil
 }
 
-// Packet returns the captured portion of a packet from an enhanced packet
-// block returned by Read() (i.e., with BlockType equal to TypePacket) beginning
-// with the link-layer header.  It also extracts the capture timestamp and link
-// layer type and returns those values along with the packet.  If an error is
-// encountered, zero values are returned for the three values.  PCAP-NG simple
-// packet types aren't supported yet (we presume this type of trace is rare and
-// does not fit our use case here as these traces do not include capture timestamps
-// and the point here is to pull our ranges of packets from a large pcap based
-// on timestamp).  We also do not support the original deprecated PCAP-NG packet
-// format but could add support if users request this (it would only be because
-// old pcaps with this deprecated format are sitting around).
+// IsSimplePacket returns true if block is a simple packet block.
+func (r *NgReader) IsSimplePacket(block []byte) bool {
+	return len(block) >= 4 && ngBlockType(r.getUint32(block[:4])) == ngBlockTypeSimplePacket
+}
+
+// parseSimplePacket parses a simple packet block, which implicitly refers to
+// the first interface of the section and whose capture length is the
+// original length truncated to the interface's snap length.
+func (r *NgReader) parseSimplePacket(block []byte) ([]byte, int, error) {
+	if len(block) < simplePacketBlockHeaderLen+4 {
+		return nil, 0, errInvalidf("packet buffer length less than minimum packet size")
+	}
+	if len(r.ifaces) == 0 {
+		return nil, 0, errInvalidf("simple packet block without interface")
+	}
+	caplen := int(r.getUint32(block[8:12]))
+	if snaplen := int(r.ifaces[0].SnapLength); snaplen != 0 && caplen > snaplen {
+		caplen = snaplen
+	}
+	packet := block[simplePacketBlockHeaderLen : len(block)-4]
+	if len(packet) < caplen {
+		return nil, 0, errInvalidf("invalid capture length")
+	}
+	return packet[:caplen], 0, nil
+}
+
+// Packet returns the captured portion of a packet from an enhanced or simple
+// packet block returned by Read() (i.e., with BlockType equal to TypePacket)
+// beginning with the link-layer header.  It also extracts the capture
+// timestamp and link layer type and returns those values along with the
+// packet.  If an error is encountered, zero values are returned for the three
+// values.
+//
+// Simple packet blocks carry no timestamp so they are given the timestamp of
+// the enhanced packet block or interface statistics block that most recently
+// preceded them in their section (or zero if there was none).  Since this
+// context is tracked by Read, Packet must be called on a simple packet block
+// before the next call to Read.  We do not support the original deprecated
+// PCAP-NG packet format but could add support if users request this (it
+// would only be because old pcaps with this deprecated format are sitting
+// around).
 func (r *NgReader) Packet(block []byte) ([]byte, nano.Ts, layers.LinkType, error) {
 	packet, ifno, err := r.parsePacket(block)
 	if err != nil {
 		return nil, 0, 0, err
 	}
-	ts := uint64(r.getUint32(block[12:16]))<<32 | uint64(r.getUint32(block[16:20]))
-	t := time.Unix(r.convertTime(ifno, ts)).UTC()
-	return packet, nano.TimeToTs(t), r.ifaces[ifno].LinkType, nil
+	var ts nano.Ts
+	if r.IsSimplePacket(block) {
+		ts = r.timestamp(r.simple)
+	} else {
+		ts = r.timestamp(ngTimestamp{ifno, r.getTimestamp(block[12:20])})
+	}
+	return packet, ts, r.ifaces[ifno].LinkType, nil
+}
+
+// PacketOptions returns the options of an enhanced or simple packet block
+// returned by Read() (i.e., with BlockType equal to TypePacket).  Simple
+// packet blocks have no options.  The values in the returned options
+// reference block.
+func (r *NgReader) PacketOptions(block []byte) (NgPacketOptions, error) {
+	opts := NgPacketOptions{DropCount: NgNoValue64}
+	if r.IsSimplePacket(block) {
+		return opts, nil
+	}
+	packet, _, err := r.parsePacket(block)
+	if err != nil {
+		return opts, err
+	}
+	b := block[PacketBlockHeaderLen+(len(packet)+3)&^3 : len(block)-4]
+	for len(b) >= 4 {
+		code, body, length, err := r.readOption(b)
+		if err != nil {
+			return opts, err
+		}
+		if code == ngOptionCodeEndOfOptions {
+			break
+		}
+		b = b[length:]
+		switch code {
+		case ngOptionCodeComment:
+			opts.Comments = append(opts.Comments, string(body))
+		case ngOptionCodePacketFlags:
+			if len(body) != 4 {
+				return opts, errInvalidf("bad option value: epb_flags")
+			}
+			opts.Flags = r.getUint32(body)
+		case ngOptionCodePacketHash:
+			if len(body) < 1 {
+				return opts, errInvalidf("bad option value: epb_hash")
+			}
+			opts.Hashes = append(opts.Hashes, NgPacketHash{Algorithm: body[0], Value: body[1:]})
+		case ngOptionCodePacketDropCount:
+			if len(body) != 8 {
+				return opts, errInvalidf("bad option value: epb_dropcount")
+			}
+			opts.DropCount = r.getUint64(body)
+		}
+	}
+	return opts, nil
+}
+
+func (r *NgReader) ifno(block []byte) int {
+	if r.IsSimplePacket(block) {
+		return 0
+	}
+	return int(r.getUint32(block[8:12]))
+}
+
+func (r *NgReader) origLen(block []byte) int {
+	if r.IsSimplePacket(block) {
+		return int(r.getUint32(block[8:12]))
+	}
+	return int(r.getUint32(block[24:28]))
+}
+
+// getTimestamp returns the 64-bit timestamp stored as high and low 32-bit
+// words in b.
+func (r *NgReader) getTimestamp(b []byte) uint64 {
+	return uint64(r.getUint32(b[0:4]))<<32 | uint64(r.getUint32(b[4:8]))
+}
+
+func (r *NgReader) timestamp(t ngTimestamp) nano.Ts {
+	if t.ifno < 0 || t.ifno >= len(r.ifaces) {
+		return 0
+	}
+	return nano.TimeToTs(time.Unix(r.convertTime(t.ifno, t.ts)).UTC())
 }
 
 func (r *NgReader) InterfaceDescriptor(block []byte) (NgInterface, error) {
 	return r.parseInterfaceDescriptor(block)
 }
 
+// SectionHeader returns the version and options of a section header block
+// returned by Read() (i.e., with BlockType equal to TypeSection).  Malformed
+// options are ignored.
 func (r *NgReader) SectionHeader(block []byte) NgSectionInfo {
-	return NgSectionInfo{
+	info := NgSectionInfo{
 		MajorVersion: r.getUint16(block[12:14]),
 		MinorVersion: r.getUint16(block[14:16]),
 	}
+	if len(block) < 28 {
+		return info
+	}
+	b := block[24 : len(block)-4]
+	for len(b) >= 4 {
+		code, body, length, err := r.readOption(b)
+		if err != nil || code == ngOptionCodeEndOfOptions {
+			break
+		}
+		b = b[length:]
+		switch code {
+		case ngOptionCodeComment:
+			info.Comment = string(body)
+		case ngOptionCodeHardware:
+			info.Hardware = string(body)
+		case ngOptionCodeOS:
+			info.OS = string(body)
+		case ngOptionCodeUserApplication:
+			info.Application = string(body)
+		}
+	}
+	return info
+}
+
+// NameResolution returns the records of a name resolution block returned by
+// Read() (i.e., with BlockType equal to TypeNameResolution).
+func (r *NgReader) NameResolution(block []byte) ([]NgNameRecord, error) {
+	if len(block) < 12 {
+		return nil, errInvalidf("bad name resolution block")
+	}
+	var records []NgNameRecord
+	b := block[8 : len(block)-4]
+	for len(b) >= 4 {
+		typ := ngNameRecordType(r.getUint16(b[:2]))
+		length := int(r.getUint16(b[2:4]))
+		b = b[4:]
+		if typ == ngNameRecordEnd {
+			break
+		}
+		if length > len(b) {
+			return nil, errInvalidf("bad name resolution record length")
+		}
+		value := b[:length]
+		b = b[min(len(b), (length+3)&^3):]
+		var addrLen int
+		switch typ {
+		case ngNameRecordIPv4:
+			addrLen = net.IPv4len
+		case ngNameRecordIPv6:
+			addrLen = net.IPv6len
+		default:
+			// skip unknown record types
+			continue
+		}
+		if len(value) < addrLen {
+			return nil, errInvalidf("bad name resolution record")
+		}
+		record := NgNameRecord{IP: net.IP(append([]byte(nil), value[:addrLen]...))}
+		for _, name := range bytes.Split(value[addrLen:], []byte{0}) {
+			if len(name) > 0 {
+				record.Names = append(record.Names, string(name))
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// InterfaceStatistics returns the interface number and statistics from an
+// interface statistics block returned by Read() (i.e., with BlockType equal
+// to TypeInterfaceStatistics).  Counters missing from the block are set to
+// NgNoValue64.
+func (r *NgReader) InterfaceStatistics(block []byte) (int, NgInterfaceStatistics, error) {
+	stats := ngEmptyStatistics
+	if len(block) < 24 {
+		return 0, stats, errInvalidf("bad interface statistics block")
+	}
+	ifno := int(r.getUint32(block[8:12]))
+	if ifno >= len(r.ifaces) {
+		return 0, stats, errInvalidf("interface statistics reference unknown interface no: %d", ifno)
+	}
+	toTime := func(ts uint64) time.Time {
+		return time.Unix(r.convertTime(ifno, ts)).UTC()
+	}
+	stats.LastUpdate = toTime(r.getTimestamp(block[12:20]))
+	b := block[20 : len(block)-4]
+	for len(b) >= 4 {
+		code, body, length, err := r.readOption(b)
+		if err != nil {
+			return 0, stats, err
+		}
+		if code == ngOptionCodeEndOfOptions {
+			break
+		}
+		b = b[length:]
+		if code == ngOptionCodeComment {
+			stats.Comment = string(body)
+			continue
+		}
+		if len(body) != 8 {
+			// all other options we know about are 64-bit values
+			continue
+		}
+		switch code {
+		case ngOptionCodeInterfaceStatisticsStartTime:
+			stats.StartTime = toTime(r.getTimestamp(body))
+		case ngOptionCodeInterfaceStatisticsEndTime:
+			stats.EndTime = toTime(r.getTimestamp(body))
+		case ngOptionCodeInterfaceStatisticsInterfaceReceived:
+			stats.PacketsReceived = r.getUint64(body)
+		case ngOptionCodeInterfaceStatisticsInterfaceDropped:
+			stats.PacketsDropped = r.getUint64(body)
+		case ngOptionCodeInterfaceStatisticsFilterAccept:
+			stats.FilterAccepted = r.getUint64(body)
+		case ngOptionCodeInterfaceStatisticsOSDrop:
+			stats.OSDropped = r.getUint64(body)
+		case ngOptionCodeInterfaceStatisticsDelivered:
+			stats.PacketsDelivered = r.getUint64(body)
+		}
+	}
+	return ifno, stats, nil
+}
+
+// DecryptionSecrets returns the secrets held in a decryption secrets block
+// returned by Read() (i.e., with BlockType equal to TypeDecryptionSecrets).
+// The returned Data references block.
+func (r *NgReader) DecryptionSecrets(block []byte) (NgDecryptionSecrets, error) {
+	if len(block) < 20 {
+		return NgDecryptionSecrets{}, errInvalidf("bad decryption secrets block")
+	}
+	typ := r.getUint32(block[8:12])
+	length := int(r.getUint32(block[12:16]))
+	if length > len(block)-20 {
+		return NgDecryptionSecrets{}, errInvalidf("bad decryption secrets length")
+	}
+	return NgDecryptionSecrets{Type: typ, Data: block[16 : 16+length]}, nil
+}
+
+// DecryptionSecretsBlock encodes secrets as a decryption secrets block in the
+// byte order of the current section so it may be written out along with the
+// blocks returned by Read.
+func (r *NgReader) DecryptionSecretsBlock(secrets NgDecryptionSecrets) []byte {
+	order := r.byteOrder()
+	length := 20 + (len(secrets.Data)+3)&^3
+	b := make([]byte, 0, length)
+	b = order.AppendUint32(b, uint32(ngBlockTypeDecryptionSecrets))
+	b = order.AppendUint32(b, uint32(length))
+	b = order.AppendUint32(b, secrets.Type)
+	b = order.AppendUint32(b, uint32(len(secrets.Data)))
+	b = appendPadded(b, secrets.Data)
+	return order.AppendUint32(b, uint32(length))
+}
+
+// EnhancedPacket returns a packet block returned by Read as an enhanced
+// packet block.  An enhanced packet block is returned as is while a simple
+// packet block is rewritten as an enhanced packet block on the first interface
+// of the section with the timestamp it was assigned by Read (see Packet) and
+// in the byte order of the current section.  Since a simple packet block takes
+// its timestamp from the blocks preceding it, it must be rewritten this way
+// before being written to output that does not keep all of those blocks.
+// Like Packet, EnhancedPacket must be called before the next call to Read.
+func (r *NgReader) EnhancedPacket(block []byte) ([]byte, error) {
+	if !r.IsSimplePacket(block) {
+		return block, nil
+	}
+	packet, ts, _, err := r.Packet(block)
+	if err != nil {
+		return nil, err
+	}
+	units, ok := r.ifaces[0].units(ts)
+	if !ok {
+		return nil, errUnrepresentablef("timestamp %s at resolution %#x", ts.Time().Format(time.RFC3339Nano), uint8(r.ifaces[0].TimestampResolution))
+	}
+	order := r.byteOrder()
+	length := PacketBlockHeaderLen + (len(packet)+3)&^3 + 4
+	b := make([]byte, 0, length)
+	b = order.AppendUint32(b, uint32(ngBlockTypeEnhancedPacket))
+	b = order.AppendUint32(b, uint32(length))
+	b = order.AppendUint32(b, 0)
+	b = order.AppendUint32(b, uint32(units>>32))
+	b = order.AppendUint32(b, uint32(units))
+	b = order.AppendUint32(b, uint32(len(packet)))
+	b = order.AppendUint32(b, uint32(r.origLen(block)))
+	b = appendPadded(b, packet)
+	return order.AppendUint32(b, uint32(length)), nil
+}
+
+func (r *NgReader) byteOrder() binary.AppendByteOrder {
+	if r.bigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
 }
 
 func (r *NgReader) Offset() uint64 {
@@ -157,12 +480,12 @@ func (r *NgReader) getUint64(buffer []byte) uint64 {
 // readBlock reads a the blocktype and length from the file.
 // If the type is a section header, endianess is also read.
 func (r *NgReader) readBlock() (ngBlockType, []byte, error) {
-	hdr, err := r.Peek(12)
+	hdr, err := r.src.Peek(12)
 	if err != nil {
 		if err == peeker.ErrTruncated {
 			r.warn("pcap-ng has extra bytes at eof: %s", hex.EncodeToString(hdr))
 			// read the bytes to discard and reach eof
-			r.Reader.Read(len(hdr))
+			r.src.Read(len(hdr))
 			err = nil
 		}
 		return 0, nil, err
@@ -182,7 +505,7 @@ func (r *NgReader) readBlock() (ngBlockType, []byte, error) {
 		// avoid infinite loop for bad input
 		return 0, nil, errInvalidf("pcap-ng block too small: %d bytes", length)
 	}
-	b, err := r.Reader.Read(int(length))
+	b, err := r.src.Read(int(length))
 	if err != nil {
 		return 0, nil, err
 	}
@@ -204,6 +527,7 @@ func (r *NgReader) parseSectionMagic(b []byte) error {
 		return errInvalidf("Wrong byte order value in Section Header")
 	}
 	r.ifaces = r.ifaces[:0]
+	r.last = ngTimestamp{ifno: -1}
 	return nil
 }
 
@@ -225,7 +549,7 @@ func (r *NgReader) readOption(b []byte) (ngOptionCode, []byte, int, error) {
 	if padding > 0 {
 		padding = 4 - padding
 	}
-	return code, b[:length], 4 + int(length+padding), nil
+	return code, b[:length], 4 + int(length) + int(padding), nil
 }
 
 // readInterfaceDescriptor parses an interface descriptor, prepares timing
@@ -262,49 +586,32 @@ func (r *NgReader) parseInterfaceDescriptor(b []byte) (NgInterface, error) {
 			if len(body) != 8 {
 				return intf, errInvalidf("bad option value: ngOptionCodeInterfaceTimestampOffset")
 			}
-			intf.TimestampOffset = r.getUint64(body[:8])
+			intf.TimestampOffset = int64(r.getUint64(body[:8]))
 		case ngOptionCodeInterfaceTimestampResolution:
 			if len(body) != 1 {
 				return intf, errInvalidf("bad option value: ngOptionCodeInterfaceTimestampResolution")
 			}
 			intf.TimestampResolution = NgResolution(body[0])
+			if !intf.TimestampResolution.valid() {
+				return intf, errInvalidf("unsupported timestamp resolution: %#x", body[0])
+			}
 		}
 	}
-	if intf.TimestampResolution == 0 {
-		intf.TimestampResolution = 6
-	}
-
-	//parse options
-	if intf.TimestampResolution.Binary() {
-		//negative power of 2
-		intf.secondMask = 1 << intf.TimestampResolution.Exponent()
-	} else {
-		//negative power of 10
-		intf.secondMask = 1
-		for j := uint8(0); j < intf.TimestampResolution.Exponent(); j++ {
-			intf.secondMask *= 10
-		}
-	}
-	intf.scaleDown = 1
-	intf.scaleUp = 1
-	if intf.secondMask < 1e9 {
-		intf.scaleUp = 1e9 / intf.secondMask
-	} else {
-		intf.scaleDown = intf.secondMask / 1e9
-	}
+	intf.setScale()
 	r.ifaces = append(r.ifaces, intf)
 	return intf, nil
 }
 
-// convertTime adds offset + shifts the given time value according to the given interface
+// convertTime converts the given time value to seconds and nanoseconds
+// according to the resolution and offset of the given interface.
 func (r *NgReader) convertTime(ifaceID int, ts uint64) (int64, int64) {
-	iface := r.ifaces[ifaceID]
-	return int64(ts/iface.secondMask + iface.TimestampOffset), int64(ts % iface.secondMask * iface.scaleUp / iface.scaleDown)
+	return r.ifaces[ifaceID].time(ts)
 }
 
-// readPacketHeader looks for a packet (enhanced, simple, or packet) and parses the header.
-// If an interface descriptor, an interface statistics block, or a section header is encountered, those are handled accordingly.
-// All other block types are skipped. New block types must be added here.
+// Read returns the next block of interest.  Packet blocks (enhanced or simple)
+// are checked and interface descriptors and interface statistics blocks are
+// parsed to track the state needed to interpret subsequent packets.  All other
+// block types are skipped. New block types must be added here.
 func (r *NgReader) Read() ([]byte, BlockType, error) {
 	block := r.first
 	if block != nil {
@@ -320,18 +627,35 @@ func (r *NgReader) Read() ([]byte, BlockType, error) {
 		r.offset += uint64(len(block))
 		switch typ {
 		case ngBlockTypeEnhancedPacket:
-			packet, _, err := r.parsePacket(block)
+			packet, ifno, err := r.parsePacket(block)
 			if packet == nil {
 				return nil, 0, err
 			}
+			r.last = ngTimestamp{ifno, r.getTimestamp(block[12:20])}
 			return block, TypePacket, nil
 		case ngBlockTypeSimplePacket:
-			return nil, 0, errInvalidf("pcap-ng simple packets not supported")
+			packet, _, err := r.parsePacket(block)
+			if packet == nil {
+				return nil, 0, err
+			}
+			r.simple = r.last
+			return block, TypePacket, nil
 		case ngBlockTypeInterfaceDescriptor:
 			_, err := r.parseInterfaceDescriptor(block)
 			return block, TypeInterface, err
 		case ngBlockTypeInterfaceStatistics:
-			// ignore and drop
+			ifno, stats, err := r.InterfaceStatistics(block)
+			if err != nil {
+				r.warn("skipping interface statistics block: %s", err)
+				continue
+			}
+			r.ifaces[ifno].Statistics = stats
+			r.last = ngTimestamp{ifno, r.getTimestamp(block[12:20])}
+			return block, TypeInterfaceStatistics, nil
+		case ngBlockTypeNameResolution:
+			return block, TypeNameResolution, nil
+		case ngBlockTypeDecryptionSecrets:
+			return block, TypeDecryptionSecrets, nil
 		case ngBlockTypeSectionHeader:
 			return block, TypeSection, err
 		case ngBlockTypePacket:
@@ -0,0 +1,263 @@
+package pcapio
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"math/bits"
+	"time"
+
+	"github.com/brimdata/zed/pkg/nano"
+)
+
+// NgWriter implements the Writer interface to write packet data in pcapng
+// format.  All blocks are written in little-endian byte order.
+type NgWriter struct {
+	w       io.Writer
+	section bool
+	ifaces  []NgInterface
+	buf     []byte
+}
+
+// NewNgWriter returns a new writer that writes pcapng data to w.
+func NewNgWriter(w io.Writer) *NgWriter {
+	return &NgWriter{w: w}
+}
+
+// WriteSection writes a section header block.  Interfaces described in
+// previous sections are forgotten.
+func (w *NgWriter) WriteSection(info NgSectionInfo) error {
+	b := w.begin(ngBlockTypeSectionHeader)
+	b = binary.LittleEndian.AppendUint32(b, ngByteOrderMagic)
+	b = binary.LittleEndian.AppendUint16(b, ngVersionMajor)
+	b = binary.LittleEndian.AppendUint16(b, ngVersionMinor)
+	// section length is unspecified
+	b = binary.LittleEndian.AppendUint64(b, 0xffffffffffffffff)
+	var opts ngOptions
+	opts.addString(ngOptionCodeHardware, info.Hardware)
+	opts.addString(ngOptionCodeOS, info.OS)
+	opts.addString(ngOptionCodeUserApplication, info.Application)
+	opts.addString(ngOptionCodeComment, info.Comment)
+	b, err := opts.append(b)
+	if err != nil {
+		return err
+	}
+	if err := w.end(b); err != nil {
+		return err
+	}
+	w.section = true
+	w.ifaces = w.ifaces[:0]
+	return nil
+}
+
+// WriteInterface writes an interface description block.  Interfaces are
+// numbered in the order they are written within a section.
+func (w *NgWriter) WriteInterface(intf NgInterface) error {
+	if !w.section {
+		if err := w.WriteSection(NgSectionInfo{}); err != nil {
+			return err
+		}
+	}
+	if !intf.TimestampResolution.valid() {
+		return errUnrepresentablef("timestamp resolution %#x", uint8(intf.TimestampResolution))
+	}
+	intf.setScale()
+	b := w.begin(ngBlockTypeInterfaceDescriptor)
+	b = binary.LittleEndian.AppendUint16(b, uint16(intf.LinkType))
+	b = binary.LittleEndian.AppendUint16(b, 0) // reserved
+	b = binary.LittleEndian.AppendUint32(b, intf.SnapLength)
+	var opts ngOptions
+	opts.addString(ngOptionCodeInterfaceName, intf.Name)
+	opts.addString(ngOptionCodeComment, intf.Comment)
+	opts.addString(ngOptionCodeInterfaceDescription, intf.Description)
+	if intf.Filter != "" {
+		// filter type 0 is a libpcap filter string
+		opts.add(ngOptionCodeInterfaceFilter, append([]byte{0}, intf.Filter...))
+	}
+	opts.addString(ngOptionCodeInterfaceOS, intf.OS)
+	if intf.TimestampResolution != 6 {
+		opts.add(ngOptionCodeInterfaceTimestampResolution, []byte{byte(intf.TimestampResolution)})
+	}
+	if intf.TimestampOffset != 0 {
+		opts.add(ngOptionCodeInterfaceTimestampOffset, binary.LittleEndian.AppendUint64(nil, uint64(intf.TimestampOffset)))
+	}
+	b, err := opts.append(b)
+	if err != nil {
+		return err
+	}
+	if err := w.end(b); err != nil {
+		return err
+	}
+	w.ifaces = append(w.ifaces, intf)
+	return nil
+}
+
+// WritePacket writes an enhanced packet block.  The packet is written on the
+// interface numbered by p.Interface if it has the packet's link type and
+// otherwise on the first interface of the current section with the packet's
+// link type.  If there is no such interface, one is described with nanosecond
+// timestamp resolution.
+func (w *NgWriter) WritePacket(p Packet) error {
+	ifno := w.lookupInterface(p)
+	if ifno < 0 {
+		intf := NgInterface{LinkType: p.LinkType, TimestampResolution: 9}
+		if err := w.WriteInterface(intf); err != nil {
+			return err
+		}
+		ifno = len(w.ifaces) - 1
+	}
+	ts, ok := w.ifaces[ifno].units(p.Ts)
+	if !ok {
+		return errUnrepresentablef("timestamp %s at resolution %#x", p.Ts.Time().Format(time.RFC3339Nano), uint8(w.ifaces[ifno].TimestampResolution))
+	}
+	b := w.begin(ngBlockTypeEnhancedPacket)
+	b = binary.LittleEndian.AppendUint32(b, uint32(ifno))
+	b = binary.LittleEndian.AppendUint32(b, uint32(ts>>32))
+	b = binary.LittleEndian.AppendUint32(b, uint32(ts))
+	b = binary.LittleEndian.AppendUint32(b, uint32(p.CaptureLength()))
+	b = binary.LittleEndian.AppendUint32(b, uint32(p.Length))
+	b = appendPadded(b, p.Data)
+	b, err := p.Options.options().append(b)
+	if err != nil {
+		return err
+	}
+	return w.end(b)
+}
+
+// WriteDecryptionSecrets writes a decryption secrets block to the current
+// section.
+func (w *NgWriter) WriteDecryptionSecrets(secrets NgDecryptionSecrets) error {
+	if !w.section {
+		if err := w.WriteSection(NgSectionInfo{}); err != nil {
+			return err
+		}
+	}
+	b := w.begin(ngBlockTypeDecryptionSecrets)
+	b = binary.LittleEndian.AppendUint32(b, secrets.Type)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(secrets.Data)))
+	b = appendPadded(b, secrets.Data)
+	return w.end(b)
+}
+
+func (w *NgWriter) lookupInterface(p Packet) int {
+	if p.Interface >= 0 && p.Interface < len(w.ifaces) && w.ifaces[p.Interface].LinkType == p.LinkType {
+		return p.Interface
+	}
+	for i, intf := range w.ifaces {
+		if intf.LinkType == p.LinkType {
+			return i
+		}
+	}
+	return -1
+}
+
+// begin starts a new block in the writer's buffer leaving room for the
+// block length, which is filled in by end.
+func (w *NgWriter) begin(typ ngBlockType) []byte {
+	b := binary.LittleEndian.AppendUint32(w.buf[:0], uint32(typ))
+	return binary.LittleEndian.AppendUint32(b, 0)
+}
+
+func (w *NgWriter) end(b []byte) error {
+	length := uint32(len(b) + 4)
+	binary.LittleEndian.PutUint32(b[4:8], length)
+	b = binary.LittleEndian.AppendUint32(b, length)
+	w.buf = b
+	_, err := w.w.Write(b)
+	return err
+}
+
+// units converts ts to the interface's timestamp units taking into account
+// the interface's timestamp offset.  It returns false if the result does not
+// fit in 64 bits, e.g., for current times at a resolution of 2^-40, or if ts
+// precedes the offset.
+func (i NgInterface) units(ts nano.Ts) (uint64, bool) {
+	ns := uint64(ts)
+	secs := int64(ns/1_000_000_000) - i.TimestampOffset
+	if secs < 0 {
+		return 0, false
+	}
+	hi, whole := bits.Mul64(uint64(secs), i.secondMask)
+	if hi != 0 {
+		return 0, false
+	}
+	nsec := ns % 1_000_000_000
+	hi, lo := bits.Mul64(nsec, i.secondMask)
+	frac, rem := bits.Div64(hi, lo, 1_000_000_000)
+	// Truncating loses the unit that a reader truncated to nsec when the
+	// resolution is coarser than nanoseconds, so round up when the next
+	// unit reads back as nsec to preserve timestamps across a round trip.
+	if _, next := i.time(frac + 1); rem != 0 && uint64(next) == nsec {
+		frac++
+	}
+	units, carry := bits.Add64(whole, frac, 0)
+	return units, carry == 0
+}
+
+// options encodes the packet options.  Flags and a drop count of zero are
+// not written.
+func (o NgPacketOptions) options() ngOptions {
+	var opts ngOptions
+	for _, comment := range o.Comments {
+		opts.addString(ngOptionCodeComment, comment)
+	}
+	if o.Flags != 0 {
+		opts.add(ngOptionCodePacketFlags, binary.LittleEndian.AppendUint32(nil, o.Flags))
+	}
+	for _, hash := range o.Hashes {
+		opts.add(ngOptionCodePacketHash, append([]byte{hash.Algorithm}, hash.Value...))
+	}
+	if o.DropCount != 0 && o.DropCount != NgNoValue64 {
+		opts.add(ngOptionCodePacketDropCount, binary.LittleEndian.AppendUint64(nil, o.DropCount))
+	}
+	return opts
+}
+
+// ngOptions accumulates the encoded options of a block.  The first error
+// adding an option is returned by append.
+type ngOptions struct {
+	b   []byte
+	err error
+}
+
+func (o *ngOptions) add(code ngOptionCode, value []byte) {
+	if o.err != nil {
+		return
+	}
+	if len(value) > math.MaxUint16 {
+		o.err = errUnrepresentablef("option %d value of %d bytes", code, len(value))
+		return
+	}
+	o.b = binary.LittleEndian.AppendUint16(o.b, uint16(code))
+	o.b = binary.LittleEndian.AppendUint16(o.b, uint16(len(value)))
+	o.b = appendPadded(o.b, value)
+}
+
+func (o *ngOptions) addString(code ngOptionCode, value string) {
+	if value != "" {
+		o.add(code, []byte(value))
+	}
+}
+
+// append appends the options to b followed by the end of options marker
+// if there are any options.  It returns an error if an option could not be
+// encoded, e.g., because its value is longer than 65535 bytes.
+func (o ngOptions) append(b []byte) ([]byte, error) {
+	if o.err != nil {
+		return nil, o.err
+	}
+	if len(o.b) == 0 {
+		return b, nil
+	}
+	b = append(b, o.b...)
+	return binary.LittleEndian.AppendUint32(b, uint32(ngOptionCodeEndOfOptions)), nil
+}
+
+// appendPadded appends value to b padded with zeros to a 32-bit boundary.
+func appendPadded(b, value []byte) []byte {
+	b = append(b, value...)
+	if pad := len(value) % 4; pad != 0 {
+		b = append(b, make([]byte, 4-pad)...)
+	}
+	return b
+}
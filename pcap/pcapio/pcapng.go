@@ -13,6 +13,8 @@ package pcapio
 import (
 	"fmt"
 	"math"
+	"math/bits"
+	"net"
 	"time"
 
 	"github.com/gopacket/gopacket"
@@ -33,11 +35,21 @@ const (
 	ngBlockTypeInterfaceDescriptor ngBlockType = 1          // Interface description block
 	ngBlockTypePacket              ngBlockType = 2          // Packet block (deprecated)
 	ngBlockTypeSimplePacket        ngBlockType = 3          // Simple packet block
+	ngBlockTypeNameResolution      ngBlockType = 4          // Name resolution block
 	ngBlockTypeInterfaceStatistics ngBlockType = 5          // Interface statistics block
 	ngBlockTypeEnhancedPacket      ngBlockType = 6          // Enhanced packet block
+	ngBlockTypeDecryptionSecrets   ngBlockType = 0x0000000A // Decryption secrets block
 	ngBlockTypeSectionHeader       ngBlockType = 0x0A0D0D0A // Section header block (same in both endians)
 )
 
+type ngNameRecordType uint16
+
+const (
+	ngNameRecordEnd  ngNameRecordType = 0 // end of records
+	ngNameRecordIPv4 ngNameRecordType = 1 // IPv4 address and names
+	ngNameRecordIPv6 ngNameRecordType = 2 // IPv6 address and names
+)
+
 type ngOptionCode uint16
 
 const (
@@ -74,6 +86,12 @@ const (
 	ngOptionCodeInterfaceStatisticsDelivered                                 // Packets delivered to user
 )
 
+const (
+	ngOptionCodePacketFlags     ngOptionCode = iota + 2 // link-layer information (e.g., direction)
+	ngOptionCodePacketHash                              // hash of the packet
+	ngOptionCodePacketDropCount                         // packets lost between this packet and the preceding one
+)
+
 // ngOption is a pcapng option
 type ngOption struct {
 	code   ngOptionCode
@@ -104,6 +122,15 @@ func (r NgResolution) Exponent() uint8 {
 	return uint8(r) & 0x7f
 }
 
+// valid returns true if the timestamp units of resolution r fit in
+// 64 bits, i.e., if a second can be expressed in those units.
+func (r NgResolution) valid() bool {
+	if r.Binary() {
+		return r.Exponent() < 64
+	}
+	return r.Exponent() < 20
+}
+
 // ToTimestampResolution converts an NgResolution to a gopaket.TimestampResolution
 func (r NgResolution) ToTimestampResolution() (ret gopacket.TimestampResolution) {
 	if r.Binary() {
@@ -130,13 +157,97 @@ type NgInterfaceStatistics struct {
 	Comment string
 	// PacketsReceived are the number of received packets. This value might be NoValue64 if this option is missing.
 	PacketsReceived uint64
-	// PacketsReceived are the number of received packets. This value might be NoValue64 if this option is missing.
+	// PacketsDropped are the number of packets dropped by the interface. This value might be NoValue64 if this option is missing.
 	PacketsDropped uint64
+	// FilterAccepted are the number of packets accepted by the capture filter. This value might be NoValue64 if this option is missing.
+	FilterAccepted uint64
+	// OSDropped are the number of packets dropped by the operating system. This value might be NoValue64 if this option is missing.
+	OSDropped uint64
+	// PacketsDelivered are the number of packets delivered to the capturing application. This value might be NoValue64 if this option is missing.
+	PacketsDelivered uint64
 }
 
 var ngEmptyStatistics = NgInterfaceStatistics{
-	PacketsReceived: NgNoValue64,
-	PacketsDropped:  NgNoValue64,
+	PacketsReceived:  NgNoValue64,
+	PacketsDropped:   NgNoValue64,
+	FilterAccepted:   NgNoValue64,
+	OSDropped:        NgNoValue64,
+	PacketsDelivered: NgNoValue64,
+}
+
+// NgNameRecord is a record from a pcapng name resolution block mapping an
+// IP address to one or more names.
+type NgNameRecord struct {
+	IP    net.IP
+	Names []string
+}
+
+// NgSecretsTypeTLSKeyLog is the secrets type of a decryption secrets block
+// holding TLS key log lines in the NSS key log format.
+const NgSecretsTypeTLSKeyLog = 0x544c534b
+
+// NgDecryptionSecrets holds the contents of a pcapng decryption secrets block.
+type NgDecryptionSecrets struct {
+	// Type identifies the format of Data, e.g., NgSecretsTypeTLSKeyLog.
+	Type uint32
+	// Data holds the secrets.
+	Data []byte
+}
+
+// NgDirection is the direction of a packet as indicated by the epb_flags
+// option of an enhanced packet block.
+type NgDirection uint8
+
+const (
+	NgDirectionUnknown NgDirection = iota
+	NgDirectionInbound
+	NgDirectionOutbound
+)
+
+func (d NgDirection) String() string {
+	switch d {
+	case NgDirectionInbound:
+		return "inbound"
+	case NgDirectionOutbound:
+		return "outbound"
+	}
+	return "unknown"
+}
+
+// ParseDirection returns the NgDirection named by s, which is either
+// "inbound" or "outbound".
+func ParseDirection(s string) (NgDirection, error) {
+	switch s {
+	case "inbound":
+		return NgDirectionInbound, nil
+	case "outbound":
+		return NgDirectionOutbound, nil
+	}
+	return 0, fmt.Errorf("unknown direction %q (must be inbound or outbound)", s)
+}
+
+// NgPacketHash is the value of an epb_hash option.
+type NgPacketHash struct {
+	// Algorithm identifies the hash algorithm, e.g., 2 for CRC32.
+	Algorithm uint8
+	Value     []byte
+}
+
+// NgPacketOptions holds the options of an enhanced packet block.
+type NgPacketOptions struct {
+	// Comments holds the opt_comment options. This value might be empty if this option is missing.
+	Comments []string
+	// Flags holds the epb_flags option. This value might be zero if this option is missing.
+	Flags uint32
+	// Hashes holds the epb_hash options. This value might be empty if this option is missing.
+	Hashes []NgPacketHash
+	// DropCount is the number of packets lost between this packet and the preceding one. This value might be NgNoValue64 if this option is missing.
+	DropCount uint64
+}
+
+// Direction returns the inbound/outbound direction indicated by the flags.
+func (o NgPacketOptions) Direction() NgDirection {
+	return NgDirection(o.Flags & 0x3)
 }
 
 // NgInterface holds all the information of a pcapng interface.
@@ -155,16 +266,41 @@ type NgInterface struct {
 	LinkType layers.LinkType
 	// TimestampResolution is the timestamp resolution of the packets in the pcapng file belonging to this interface.
 	TimestampResolution NgResolution
-	// TimestampResolution is the timestamp offset in seconds of the packets in the pcapng file belonging to this interface.
-	TimestampOffset uint64
+	// TimestampOffset is the offset in seconds that is added to the timestamps of the packets in the pcapng file belonging to this interface.
+	TimestampOffset int64
 	// SnapLength is the maximum packet length captured by this interface. 0 for unlimited
 	SnapLength uint32
 	// Statistics holds the interface statistics
 	Statistics NgInterfaceStatistics
 
 	secondMask uint64
-	scaleUp    uint64
-	scaleDown  uint64
+}
+
+// setScale computes the number of timestamp units per second used to convert
+// between timestamps in units of the interface's resolution and nanoseconds.
+func (i *NgInterface) setScale() {
+	if i.TimestampResolution == 0 {
+		i.TimestampResolution = 6
+	}
+	if i.TimestampResolution.Binary() {
+		//negative power of 2
+		i.secondMask = 1 << i.TimestampResolution.Exponent()
+	} else {
+		//negative power of 10
+		i.secondMask = 1
+		for j := uint8(0); j < i.TimestampResolution.Exponent(); j++ {
+			i.secondMask *= 10
+		}
+	}
+}
+
+// time converts ts, a timestamp in units of the interface's resolution, to
+// seconds and nanoseconds since the epoch after adding the interface's
+// timestamp offset.  Fractional nanoseconds are truncated.
+func (i NgInterface) time(ts uint64) (int64, int64) {
+	hi, lo := bits.Mul64(ts%i.secondMask, 1_000_000_000)
+	nsec, _ := bits.Div64(hi, lo, i.secondMask)
+	return int64(ts/i.secondMask) + i.TimestampOffset, int64(nsec)
 }
 
 // Resolution returns the timestamp resolution of acquired timestamps before scaling to NanosecondTimestampResolution.
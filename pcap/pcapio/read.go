@@ -16,7 +16,6 @@ import (
 	"io"
 
 	"github.com/brimdata/zed/pkg/nano"
-	"github.com/brimdata/zed/pkg/peeker"
 	"github.com/gopacket/gopacket"
 	"github.com/gopacket/gopacket/layers"
 )
@@ -27,16 +26,21 @@ import (
 // for information on the file format.
 //
 // We currenty read v2.4 file format with nanosecond and microsecond
-// timestamp resolution in little-endian and big-endian encoding.
+// timestamp resolution in little-endian and big-endian encoding.  We also
+// read the "modified" pcap format written by some old Red Hat and SuSE
+// builds of tcpdump (patches by Alexey Kuznetzov), which is identified by its
+// own magic and extends each packet header with the interface index,
+// protocol, and packet type.
 //
-// If the PCAP data is gzip compressed it is transparently uncompressed
-// by wrapping the given io.Reader with a gzip.Reader.
+// Compressed PCAP data is transparently uncompressed by NewReader (but not by
+// NewPcapReader).
 type PcapReader struct {
-	*peeker.Reader
+	src      source
 	LinkType layers.LinkType
 
 	byteOrder      binary.ByteOrder
 	nanoSecsFactor uint32
+	hdrLen         int
 	// timezone
 	// sigfigs
 	versionMajor uint16
@@ -54,13 +58,13 @@ const versionMinor = 4
 const magicNanoseconds = 0xA1B23C4D
 const magicMicrosecondsBigendian = 0xD4C3B2A1
 const magicNanosecondsBigendian = 0x4D3CB2A1
-
-const magicGzip1 = 0x1f
-const magicGzip2 = 0x8b
+const magicModified = 0xA1B2CD34
+const magicModifiedBigendian = 0x34CDB2A1
 
 const (
-	fileHeaderLen   = 24
-	packetHeaderLen = 16
+	fileHeaderLen           = 24
+	packetHeaderLen         = 16
+	modifiedPacketHeaderLen = 24
 )
 
 // NewPcapReader returns a new reader object, for reading packet data from
@@ -74,9 +78,11 @@ const (
 //	r, err := NewReader(f)
 //	data, info, err := r.Read()
 func NewPcapReader(r io.Reader) (*PcapReader, error) {
-	reader := &PcapReader{
-		Reader: peeker.NewReader(r, 32*1024, 1024*1024),
-	}
+	return newPcapReader(newPeeker(r))
+}
+
+func newPcapReader(src source) (*PcapReader, error) {
+	reader := &PcapReader{src: src}
 	if err := reader.readHeader(); err != nil {
 		return nil, err
 	}
@@ -84,25 +90,30 @@ func NewPcapReader(r io.Reader) (*PcapReader, error) {
 }
 
 func (r *PcapReader) Packet(block []byte) ([]byte, nano.Ts, layers.LinkType, error) {
-	if len(block) <= packetHeaderLen {
+	if len(block) <= r.hdrLen {
 		return nil, 0, 0, errInvalidf("packet buffer length less than minimum packet size")
 	}
 	caplen := int(r.byteOrder.Uint32(block[8:12]))
-	if caplen+packetHeaderLen > len(block) {
+	if caplen+r.hdrLen > len(block) {
 		return nil, 0, 0, errInvalidf("invalid capture length")
 	}
 	ts := r.TsFromHeader(block)
-	pkt := block[packetHeaderLen:]
+	pkt := block[r.hdrLen:]
 	return pkt[:caplen], ts, r.LinkType, nil
 }
 
+func (r *PcapReader) origLen(block []byte) int {
+	return int(r.byteOrder.Uint32(block[12:16]))
+}
+
 func (r *PcapReader) readHeader() error {
-	hdr, err := r.Reader.Read(fileHeaderLen)
+	hdr, err := r.src.Read(fileHeaderLen)
 	if err != nil {
 		return err
 	}
 	r.header = make([]byte, fileHeaderLen)
 	copy(r.header, hdr)
+	r.hdrLen = packetHeaderLen
 	if magic := binary.LittleEndian.Uint32(hdr[0:4]); magic == magicNanoseconds {
 		r.byteOrder = binary.LittleEndian
 		r.nanoSecsFactor = 1
@@ -115,6 +126,14 @@ func (r *PcapReader) readHeader() error {
 	} else if magic == magicMicrosecondsBigendian {
 		r.byteOrder = binary.BigEndian
 		r.nanoSecsFactor = 1000
+	} else if magic == magicModified {
+		r.byteOrder = binary.LittleEndian
+		r.nanoSecsFactor = 1000
+		r.hdrLen = modifiedPacketHeaderLen
+	} else if magic == magicModifiedBigendian {
+		r.byteOrder = binary.BigEndian
+		r.nanoSecsFactor = 1000
+		r.hdrLen = modifiedPacketHeaderLen
 	} else {
 		return errInvalidf("unknown magic %x", magic)
 	}
@@ -143,7 +162,7 @@ func (r *PcapReader) Read() ([]byte, BlockType, error) {
 		r.offset += uint64(len(header))
 		return header, TypeSection, nil
 	}
-	hdr, err := r.Reader.Peek(packetHeaderLen)
+	hdr, err := r.src.Peek(r.hdrLen)
 	if err != nil {
 		if err == io.EOF {
 			err = nil
@@ -161,8 +180,8 @@ func (r *PcapReader) Read() ([]byte, BlockType, error) {
 	//if caplen > fullLength {
 	//	return nil, 0, fmt.Errorf("capture length exceeds original packet length: %d > %d", caplen, fullLength)
 	//}
-	n := caplen + packetHeaderLen
-	block, err := r.Reader.Read(n)
+	n := caplen + r.hdrLen
+	block, err := r.src.Read(n)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -179,6 +198,11 @@ func (r *PcapReader) Offset() uint64 {
 	return r.offset
 }
 
+// Modified returns true if the capture file is in the modified pcap format.
+func (r *PcapReader) Modified() bool {
+	return r.hdrLen == modifiedPacketHeaderLen
+}
+
 func (r *PcapReader) Version() string {
 	return fmt.Sprintf("%d.%d", r.versionMajor, r.versionMinor)
 }
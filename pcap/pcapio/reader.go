@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/brimdata/brimcap/decompress"
 	"github.com/brimdata/brimcap/recorder"
+	"github.com/brimdata/brimcap/slicer"
 	"github.com/brimdata/zed/pkg/nano"
 	"github.com/gopacket/gopacket/layers"
 	"go.uber.org/multierr"
@@ -17,13 +19,19 @@ const (
 	TypePacket BlockType = iota
 	TypeSection
 	TypeInterface
+	TypeNameResolution
+	TypeInterfaceStatistics
+	TypeDecryptionSecrets
 )
 
 // Reader is an interface for reading data blocks from a pcap, either a legacy
 // pcap or a next-gen pcap.  The Read method returns blocks of data that are
 // one of: a pcap file header (TypeSection), a pcap packet including the capture
 // header (TypePacket), a pcap-ng section block (TypeSection), a pcap-ng
-// interface block (TypeInterface), or a pcap-ng packet block (TypePacket).
+// interface block (TypeInterface), a pcap-ng enhanced or simple packet block
+// (TypePacket), a pcap-ng name resolution block (TypeNameResolution), a
+// pcap-ng interface statistics block (TypeInterfaceStatistics), or a pcap-ng
+// decryption secrets block (TypeDecryptionSecrets).
 // For TypePacket, the capture timestamp and the link-layer type of the packet
 // is indicated in the Info return value.
 type Reader interface {
@@ -32,35 +40,98 @@ type Reader interface {
 	Offset() uint64
 }
 
-// NewReader returns a Reader by trying both pcap and pcap-ng formats.
+// NewReader returns a Reader by trying each of the supported capture formats.
 func NewReader(r io.Reader) (Reader, error) {
 	return NewReaderWithWarnings(r, nil)
 }
 
-// NewReaderWithWarnings returns a Reader by trying both pcap and pcap-ng formats
-// and arranges for warning messages to be sent over the given channel.  Different
+// formats lists the supported capture formats in the order they are tried by
+// NewReaderWithWarnings.  ERF is last since it has no magic and is recognized
+// heuristically.
+var formats = []struct {
+	name string
+	new  func(source) (Reader, error)
+}{
+	{"pcap", func(s source) (Reader, error) { return newPcapReader(s) }},
+	{"pcapng", func(s source) (Reader, error) { return newNgReader(s) }},
+	{"snoop", func(s source) (Reader, error) { return newSnoopReader(s) }},
+	{"erf", func(s source) (Reader, error) { return newErfReader(s) }},
+}
+
+// NewReaderWithWarnings returns a Reader by trying each of the supported
+// capture formats (legacy and modified pcap, pcap-ng, snoop, and ERF) and
+// arranges for warning messages to be sent over the given channel.  Different
 // pcap implementations can have out-of-spec peculiarities that can be tolerated
-// so we send warnings and try to keep going.
+// so we send warnings and try to keep going.  Compressed input is
+// transparently decompressed (see package decompress).
 func NewReaderWithWarnings(r io.Reader, warner Warner) (Reader, error) {
+	dr, err := decompress.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewDecompressedReader(dr, warner)
+}
+
+// NewDecompressedReader is like NewReaderWithWarnings but expects r to be
+// decompressed already, e.g., by a decompress.Reader whose offsets must line
+// up with those of the returned Reader.
+func NewDecompressedReader(r io.Reader, warner Warner) (Reader, error) {
 	record := recorder.NewRecorder(r)
 	track := recorder.NewTrack(record)
-	_, err1 := NewPcapReader(track)
-	if err1 == nil {
-		return NewPcapReader(record)
-	}
-	track.Reset()
-	_, err2 := NewNgReader(track)
-	if err2 == nil {
-		r, err := NewNgReader(record)
-		r.SetWarningHandler(warner)
-		return r, err
+	return newReader(func(probe bool) source {
+		if probe {
+			track.Reset()
+			return newPeeker(track)
+		}
+		return newPeeker(record)
+	}, warner)
+}
+
+// NewReaderAt returns a Reader over the blocks held in the regions of the
+// uncompressed capture r, which is size bytes long, given by slices, e.g.,
+// those generated from a pcap.Index by pcap.GenerateSlices.  Rather than seeking and copying data
+// through a buffer, blocks are read with ReadAt or, if r provides its contents
+// as a byte slice like an mmap.File does, returned directly from that memory,
+// in which case the blocks are valid only as long as the memory is.
+func NewReaderAt(r io.ReaderAt, size int64, slices []slicer.Slice, warner Warner) (Reader, error) {
+	src := newAtSource(r, size, slices)
+	return newReader(func(probe bool) source {
+		if probe {
+			return src.clone()
+		}
+		return src
+	}, warner)
+}
+
+// newReader tries each of the supported formats on the sources returned by
+// open.  Each format is first tried on a probe source and, if recognized, the
+// Reader is created from the real source.
+func newReader(open func(probe bool) source, warner Warner) (Reader, error) {
+	var errs []error
+	invalid := true
+	for _, format := range formats {
+		_, err := format.new(open(true))
+		if err == nil {
+			r, err := format.new(open(false))
+			if err != nil {
+				return nil, err
+			}
+			if r, ok := r.(interface{ SetWarningHandler(Warner) }); ok {
+				r.SetWarningHandler(warner)
+			}
+			return r, err
+		}
+		var perr *ErrInvalidPcap
+		if errors.As(err, &perr) {
+			err = fmt.Errorf("%s: %w", format.name, perr.err)
+		} else {
+			invalid = false
+		}
+		errs = append(errs, err)
 	}
-	var pcaperr, ngerr *ErrInvalidPcap
-	if errors.As(err1, &pcaperr) && errors.As(err2, &ngerr) {
-		err1 = fmt.Errorf("pcap: %w", pcaperr.err)
-		err2 = fmt.Errorf("pcapng: %w", ngerr.err)
-		err := multierr.Combine(err1, err2)
+	err := multierr.Combine(errs...)
+	if invalid {
 		return nil, NewErrInvalidPcap(err)
 	}
-	return nil, multierr.Combine(err1, err2)
+	return nil, err
 }
@@ -0,0 +1,60 @@
+package pcapio_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/brimdata/brimcap/pcap/pcapio"
+	"github.com/brimdata/brimcap/slicer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReaderAt(t *testing.T) {
+	var buf bytes.Buffer
+	w := pcapio.NewNgWriter(&buf)
+	for _, p := range testPackets {
+		require.NoError(t, w.WritePacket(p))
+	}
+	// Find the offsets of the blocks.
+	reader, err := pcapio.NewReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	var slices []slicer.Slice
+	for {
+		off := reader.Offset()
+		block, _, err := reader.Read()
+		if err != io.EOF {
+			require.NoError(t, err)
+		}
+		if block == nil {
+			break
+		}
+		slices = append(slices, slicer.Slice{Offset: off, Length: uint64(len(block))})
+	}
+	// slices holds the section, the first interface, the first two
+	// packets, the second interface, and the last packet.  Skip the first
+	// packet and let the last region extend past the end of the file.
+	require.Len(t, slices, 6)
+	slices = append(slices[:2], slices[3:]...)
+	slices[len(slices)-1].Length = 1 << 40
+	r, err := pcapio.NewReaderAt(bytes.NewReader(buf.Bytes()), int64(buf.Len()), slices, nil)
+	require.NoError(t, err)
+	var pkts []pcapio.Packet
+	for {
+		block, typ, err := r.Read()
+		if err != io.EOF {
+			require.NoError(t, err)
+		}
+		if block == nil {
+			break
+		}
+		if typ == pcapio.TypePacket {
+			pkt, err := pcapio.DecodePacket(r, block)
+			require.NoError(t, err)
+			pkt.Data = append([]byte(nil), pkt.Data...)
+			pkts = append(pkts, pkt)
+		}
+	}
+	assert.Equal(t, testPackets[1:], pkts)
+}
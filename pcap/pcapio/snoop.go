@@ -0,0 +1,141 @@
+package pcapio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/brimdata/zed/pkg/nano"
+	"github.com/brimdata/zed/pkg/peeker"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// SnoopReader implements the Reader interface to read packet data in the
+// Snoop format written by Solaris snoop and described in RFC 1761.  All
+// fields are big-endian.  The file header is returned as a TypeSection block
+// and each packet record (including its header and padding) as a TypePacket
+// block.
+type SnoopReader struct {
+	src      source
+	LinkType layers.LinkType
+
+	version uint32
+	offset  uint64
+	header  []byte
+}
+
+var snoopMagic = []byte("snoop\x00\x00\x00")
+
+const (
+	snoopHeaderLen       = 16
+	snoopRecordHeaderLen = 24
+	snoopVersion         = 2
+)
+
+// snoopLinkTypes maps RFC 1761 datalink types to pcap link types.
+var snoopLinkTypes = map[uint32]layers.LinkType{
+	0: layers.LinkTypeEthernet, // IEEE 802.3
+	2: layers.LinkTypeTokenRing,
+	4: layers.LinkTypeEthernet,
+	8: layers.LinkTypeFDDI,
+}
+
+// NewSnoopReader returns a new reader object, for reading packet data from
+// the given reader in Snoop format.  The file header is read from it at this
+// point.
+func NewSnoopReader(r io.Reader) (*SnoopReader, error) {
+	return newSnoopReader(newPeeker(r))
+}
+
+func newSnoopReader(src source) (*SnoopReader, error) {
+	reader := &SnoopReader{src: src}
+	if err := reader.readHeader(); err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+func (r *SnoopReader) readHeader() error {
+	hdr, err := r.src.Read(snoopHeaderLen)
+	if err != nil {
+		if err == peeker.ErrTruncated || err == io.EOF {
+			err = errInvalidf("snoop file is too small to be valid")
+		}
+		return err
+	}
+	if !bytes.Equal(hdr[:8], snoopMagic) {
+		return errInvalidf("unknown magic %x", hdr[:8])
+	}
+	if r.version = binary.BigEndian.Uint32(hdr[8:12]); r.version != snoopVersion {
+		return errInvalidf("unknown snoop version %d", r.version)
+	}
+	datalink := binary.BigEndian.Uint32(hdr[12:16])
+	linkType, ok := snoopLinkTypes[datalink]
+	if !ok {
+		return errInvalidf("unsupported snoop datalink type %d", datalink)
+	}
+	r.LinkType = linkType
+	r.header = make([]byte, snoopHeaderLen)
+	copy(r.header, hdr)
+	return nil
+}
+
+func (r *SnoopReader) Read() ([]byte, BlockType, error) {
+	header := r.header
+	if header != nil {
+		r.header = nil
+		r.offset += uint64(len(header))
+		return header, TypeSection, nil
+	}
+	hdr, err := r.src.Peek(snoopRecordHeaderLen)
+	if err != nil {
+		if err == io.EOF {
+			err = nil
+		}
+		return nil, 0, err
+	}
+	n := int(binary.BigEndian.Uint32(hdr[8:12]))
+	caplen := int(binary.BigEndian.Uint32(hdr[4:8]))
+	if n < snoopRecordHeaderLen+caplen {
+		return nil, 0, errInvalidf("snoop record length too small: %d", n)
+	}
+	block, err := r.src.Read(n)
+	if err != nil {
+		return nil, 0, err
+	}
+	r.offset += uint64(n)
+	return block, TypePacket, nil
+}
+
+func (r *SnoopReader) Packet(block []byte) ([]byte, nano.Ts, layers.LinkType, error) {
+	if len(block) < snoopRecordHeaderLen {
+		return nil, 0, 0, errInvalidf("packet buffer length less than minimum packet size")
+	}
+	caplen := int(binary.BigEndian.Uint32(block[4:8]))
+	if caplen+snoopRecordHeaderLen > len(block) {
+		return nil, 0, 0, errInvalidf("invalid capture length")
+	}
+	ns := int64(binary.BigEndian.Uint32(block[16:20])) * 1_000_000_000
+	ns += int64(binary.BigEndian.Uint32(block[20:24])) * 1000
+	pkt := block[snoopRecordHeaderLen:]
+	return pkt[:caplen], nano.Ts(ns), r.LinkType, nil
+}
+
+// Drops returns the cumulative number of packets dropped by the capture
+// as of the packet record block.
+func (r *SnoopReader) Drops(block []byte) uint32 {
+	return binary.BigEndian.Uint32(block[12:16])
+}
+
+func (r *SnoopReader) origLen(block []byte) int {
+	return int(binary.BigEndian.Uint32(block[0:4]))
+}
+
+func (r *SnoopReader) Offset() uint64 {
+	return r.offset
+}
+
+func (r *SnoopReader) Version() string {
+	return fmt.Sprintf("%d", r.version)
+}
@@ -0,0 +1,152 @@
+package pcapio
+
+import (
+	"errors"
+	"io"
+
+	"github.com/brimdata/brimcap/slicer"
+	"github.com/brimdata/zed/pkg/peeker"
+)
+
+// source is the input of the readers in this package.  Peek returns the next
+// n bytes without consuming them and Read returns and consumes them.  Both
+// return io.EOF at the end of the input and peeker.ErrTruncated along with
+// the remaining bytes if fewer than n remain.  The returned bytes are valid
+// only until the next call to Peek or Read.
+type source interface {
+	Peek(n int) ([]byte, error)
+	Read(n int) ([]byte, error)
+}
+
+const (
+	sourceBufferSize = 32 * 1024
+	sourceMaxBlock   = 1024 * 1024
+)
+
+func newPeeker(r io.Reader) *peeker.Reader {
+	return peeker.NewReader(r, sourceBufferSize, sourceMaxBlock)
+}
+
+// atSource is a source over regions of an io.ReaderAt.  If the io.ReaderAt
+// provides its contents as a byte slice (see mmap.File), Peek and Read return
+// slices of that memory.  Otherwise, each region is read with ReadAt into a
+// buffer so no seeks are needed.  Data spanning regions, which is rare since
+// regions generated from an index begin and end at block boundaries, is
+// copied into a scratch buffer.
+type atSource struct {
+	reader io.ReaderAt
+	data   []byte
+	// slices holds the regions not yet consumed.  The offset and length
+	// of the first region are advanced as it is consumed.
+	slices []slicer.Slice
+	buf    []byte
+	bufOff uint64
+	join   []byte
+}
+
+// newAtSource returns an atSource over the regions of r, which holds size
+// bytes, given by slices.  The regions are clipped to size since the last
+// region generated from an index extends to the end of the file.
+func newAtSource(r io.ReaderAt, size int64, slices []slicer.Slice) *atSource {
+	s := &atSource{reader: r}
+	if b, ok := r.(interface{ Bytes() []byte }); ok {
+		s.data = b.Bytes()
+	}
+	for _, slice := range slices {
+		if slice.Offset >= uint64(size) {
+			continue
+		}
+		slice.Length = min(slice.Length, uint64(size)-slice.Offset)
+		s.slices = append(s.slices, slice)
+	}
+	return s
+}
+
+// clone returns a copy of s positioned at the same place in the same
+// regions but with its own buffers.
+func (s *atSource) clone() *atSource {
+	return &atSource{
+		reader: s.reader,
+		data:   s.data,
+		slices: append([]slicer.Slice(nil), s.slices...),
+	}
+}
+
+func (s *atSource) Peek(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, errors.New("pcapio: negative length")
+	}
+	if n > sourceMaxBlock {
+		return nil, peeker.ErrBufferOverflow
+	}
+	for len(s.slices) > 0 && s.slices[0].Length == 0 {
+		s.slices = s.slices[1:]
+	}
+	if len(s.slices) == 0 {
+		return nil, io.EOF
+	}
+	if slice := s.slices[0]; uint64(n) <= slice.Length {
+		return s.at(slice, n)
+	}
+	s.join = s.join[:0]
+	for _, slice := range s.slices {
+		k := min(uint64(n-len(s.join)), slice.Length)
+		b, err := s.at(slice, int(k))
+		if err != nil {
+			return nil, err
+		}
+		s.join = append(s.join, b...)
+		if len(s.join) == n {
+			return s.join, nil
+		}
+	}
+	return s.join, peeker.ErrTruncated
+}
+
+func (s *atSource) Read(n int) ([]byte, error) {
+	b, err := s.Peek(n)
+	if err != nil {
+		return nil, err
+	}
+	for n > 0 {
+		k := min(uint64(n), s.slices[0].Length)
+		s.slices[0].Offset += k
+		s.slices[0].Length -= k
+		n -= int(k)
+		if s.slices[0].Length == 0 {
+			s.slices = s.slices[1:]
+		}
+	}
+	return b, nil
+}
+
+// at returns the n bytes at the beginning of slice.  Since the regions were
+// clipped to the size of the file, running out of data means the file was
+// truncated, which results in io.ErrUnexpectedEOF.
+func (s *atSource) at(slice slicer.Slice, n int) ([]byte, error) {
+	off := slice.Offset
+	if s.data != nil {
+		if off+uint64(n) > uint64(len(s.data)) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return s.data[off : off+uint64(n)], nil
+	}
+	if off >= s.bufOff && off+uint64(n) <= s.bufOff+uint64(len(s.buf)) {
+		return s.buf[off-s.bufOff : off-s.bufOff+uint64(n)], nil
+	}
+	size := int(min(slice.Length, uint64(max(n, sourceBufferSize))))
+	if cap(s.buf) < size {
+		s.buf = make([]byte, size)
+	}
+	s.buf = s.buf[:size]
+	m, err := s.reader.ReadAt(s.buf, int64(off))
+	s.buf = s.buf[:m]
+	s.bufOff = off
+	if m < n {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return s.buf[:n], nil
+}
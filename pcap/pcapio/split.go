@@ -0,0 +1,206 @@
+package pcapio
+
+import (
+	"io"
+
+	"github.com/brimdata/zed/pkg/nano"
+)
+
+// SplitLimit bounds the chunks written by Split.  A chunk ends when any of
+// the nonzero limits is reached.
+type SplitLimit struct {
+	// Duration is the time window of each chunk.  Windows are aligned to
+	// the timestamp of the first packet and chunks are not written for
+	// windows without packets.  A packet that precedes the window of the
+	// current chunk stays in the chunk.
+	Duration nano.Duration
+	// Packets is the maximum number of packets in a chunk.
+	Packets int
+	// Bytes is the size at which a chunk ends, so a chunk may exceed it by
+	// at most one packet.
+	Bytes int64
+}
+
+// Split copies the capture read from r into consecutive chunks bounded by
+// limit.  Each chunk is written to the io.WriteCloser returned by create,
+// which is called with the number of the chunk starting at zero and closed
+// by Split once the chunk is complete.  Legacy pcap input is written as
+// legacy pcap and all other formats as pcap-ng.
+//
+// Each chunk is a complete capture: for pcap-ng, a chunk begins with the
+// section header and the interface descriptions of the section in effect
+// so that interface numbers are preserved, along with the decryption secrets
+// seen so far in the section.  Name resolution and interface statistics
+// blocks are not copied.
+func Split(r Reader, limit SplitLimit, create func(int) (io.WriteCloser, error)) error {
+	s := &splitter{reader: r, limit: limit, create: create}
+	err := s.run()
+	if s.out != nil {
+		if cerr := s.out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+type splitter struct {
+	reader  Reader
+	limit   SplitLimit
+	create  func(int) (io.WriteCloser, error)
+	section NgSectionInfo
+	secrets []NgDecryptionSecrets
+	pending bool
+
+	chunk   int
+	out     io.WriteCloser
+	writer  Writer
+	counter *byteCounter
+	packets int
+	window  nano.Ts
+	start   nano.Ts
+}
+
+func (s *splitter) run() error {
+	for {
+		block, typ, err := s.reader.Read()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if block == nil {
+			return nil
+		}
+		switch typ {
+		case TypeSection:
+			s.section = NgSectionInfo{}
+			if ng, ok := s.reader.(*NgReader); ok {
+				s.section = ng.SectionHeader(block)
+			}
+			s.secrets = nil
+			// The section is described to the current chunk along
+			// with its first packet so that a chunk never ends with
+			// an empty section.
+			s.pending = true
+		case TypeInterface:
+			if s.writer != nil && !s.pending {
+				ng := s.reader.(*NgReader)
+				err = s.writer.WriteInterface(ng.ifaces[len(ng.ifaces)-1])
+			}
+		case TypeDecryptionSecrets:
+			var secrets NgDecryptionSecrets
+			secrets, err = s.reader.(*NgReader).DecryptionSecrets(block)
+			if err == nil {
+				secrets.Data = append([]byte(nil), secrets.Data...)
+				s.secrets = append(s.secrets, secrets)
+				if s.writer != nil && !s.pending {
+					err = s.writer.(SecretsWriter).WriteDecryptionSecrets(secrets)
+				}
+			}
+		case TypePacket:
+			var pkt Packet
+			pkt, err = DecodePacket(s.reader, block)
+			if err == nil {
+				err = s.writePacket(pkt)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *splitter) writePacket(pkt Packet) error {
+	if s.chunk == 0 && s.writer == nil {
+		s.start = pkt.Ts
+	}
+	if s.writer != nil && s.full(pkt) {
+		err := s.out.Close()
+		s.out, s.writer = nil, nil
+		if err != nil {
+			return err
+		}
+		s.chunk++
+	}
+	if s.writer == nil {
+		if err := s.open(pkt); err != nil {
+			return err
+		}
+	} else if s.pending {
+		if err := s.writeHeaders(); err != nil {
+			return err
+		}
+	}
+	if err := s.writer.WritePacket(pkt); err != nil {
+		return err
+	}
+	s.packets++
+	return nil
+}
+
+// full returns true if pkt does not belong in the current chunk.
+func (s *splitter) full(pkt Packet) bool {
+	l := s.limit
+	return (l.Packets > 0 && s.packets >= l.Packets) ||
+		(l.Bytes > 0 && s.counter.n >= l.Bytes) ||
+		(l.Duration > 0 && pkt.Ts >= s.window.Add(l.Duration))
+}
+
+func (s *splitter) open(pkt Packet) error {
+	out, err := s.create(s.chunk)
+	if err != nil {
+		return err
+	}
+	s.out = out
+	s.counter = &byteCounter{w: out}
+	if _, ok := s.reader.(*PcapReader); ok {
+		s.writer = NewPcapWriter(s.counter)
+	} else {
+		s.writer = NewNgWriter(s.counter)
+	}
+	s.packets = 0
+	if d := s.limit.Duration; d > 0 {
+		// Align the window to the first packet of the capture.
+		s.window = s.start.Add(pkt.Ts.SubTs(s.start) / d * d)
+	}
+	return s.writeHeaders()
+}
+
+// writeHeaders describes the current section to the chunk being written
+// along with the interfaces and secrets read so far in the section.
+func (s *splitter) writeHeaders() error {
+	s.pending = false
+	if err := s.writer.WriteSection(s.section); err != nil {
+		return err
+	}
+	if intf, ok := fileInterface(s.reader); ok {
+		return s.writer.WriteInterface(intf)
+	}
+	ng, ok := s.reader.(*NgReader)
+	if !ok {
+		return nil
+	}
+	for _, intf := range ng.ifaces {
+		if err := s.writer.WriteInterface(intf); err != nil {
+			return err
+		}
+	}
+	for _, secrets := range s.secrets {
+		if err := s.writer.(SecretsWriter).WriteDecryptionSecrets(secrets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type byteCounter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *byteCounter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
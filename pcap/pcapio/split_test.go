@@ -0,0 +1,48 @@
+package pcapio_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/brimdata/brimcap/pcap/pcapio"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type chunkBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *chunkBuffer) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestSplit(t *testing.T) {
+	var buf bytes.Buffer
+	w := pcapio.NewNgWriter(&buf)
+	require.NoError(t, w.WriteInterface(pcapio.NgInterface{LinkType: layers.LinkTypeEthernet, TimestampResolution: 9}))
+	require.NoError(t, w.WriteInterface(pcapio.NgInterface{LinkType: layers.LinkTypeRaw, TimestampResolution: 9}))
+	for _, p := range testPackets {
+		require.NoError(t, w.WritePacket(p))
+	}
+	r, err := pcapio.NewReader(&buf)
+	require.NoError(t, err)
+	var chunks []*chunkBuffer
+	create := func(n int) (io.WriteCloser, error) {
+		require.Equal(t, len(chunks), n)
+		chunks = append(chunks, &chunkBuffer{})
+		return chunks[n], nil
+	}
+	require.NoError(t, pcapio.Split(r, pcapio.SplitLimit{Packets: 2}, create))
+	require.Len(t, chunks, 2)
+	assert.True(t, chunks[0].closed)
+	assert.True(t, chunks[1].closed)
+	assert.Equal(t, testPackets[:2], readAll(t, &chunks[0].Buffer))
+	// The second chunk describes both interfaces so the packet keeps
+	// its interface number.
+	assert.Equal(t, testPackets[2:], readAll(t, &chunks[1].Buffer))
+}
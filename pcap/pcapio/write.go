@@ -0,0 +1,119 @@
+package pcapio
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// PcapWriter implements the Writer interface to write packet data in legacy
+// PCAP format.  The file header is written lazily from the first interface
+// described by WriteInterface (or from the first packet if no interface was
+// described) since a legacy pcap holds exactly one link type and snap length.
+// The nanosecond magic is used when the interface's timestamp resolution is
+// finer than microseconds.
+//
+// Since legacy pcap has no notion of sections, WriteSection is accepted only
+// as long as the interfaces that follow are compatible with the file header.
+type PcapWriter struct {
+	w              io.Writer
+	header         bool
+	linkType       layers.LinkType
+	snaplen        uint32
+	nanoSecsFactor uint32
+	buf            [packetHeaderLen]byte
+}
+
+// defaultSnaplen is the snap length written to the file header when the
+// interface's snap length is zero (unlimited in pcapng) or larger, since many
+// readers reject such values.  This is the same maximum used by tcpdump.
+const defaultSnaplen = 262144
+
+// NewPcapWriter returns a new writer that writes legacy pcap data to w.
+func NewPcapWriter(w io.Writer) *PcapWriter {
+	return &PcapWriter{w: w}
+}
+
+func (w *PcapWriter) WriteSection(NgSectionInfo) error {
+	return nil
+}
+
+func (w *PcapWriter) WriteInterface(intf NgInterface) error {
+	if w.header {
+		if intf.LinkType != w.linkType {
+			return errUnrepresentablef("legacy pcap link type %s, interface has link type %s", w.linkType, intf.LinkType)
+		}
+		if snaplen(intf) > w.snaplen {
+			return errUnrepresentablef("legacy pcap snap length %d, interface has snap length %d", w.snaplen, intf.SnapLength)
+		}
+		if w.nanoSecsFactor != 1 && needsNanoseconds(intf) {
+			return errUnrepresentablef("legacy pcap has microsecond timestamps, interface has timestamp resolution %s", intf.Resolution())
+		}
+		return nil
+	}
+	return w.writeHeader(intf)
+}
+
+func (w *PcapWriter) writeHeader(intf NgInterface) error {
+	magic := uint32(magicMicroseconds)
+	w.nanoSecsFactor = 1000
+	if needsNanoseconds(intf) {
+		magic = magicNanoseconds
+		w.nanoSecsFactor = 1
+	}
+	var hdr [fileHeaderLen]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], magic)
+	binary.LittleEndian.PutUint16(hdr[4:6], versionMajor)
+	binary.LittleEndian.PutUint16(hdr[6:8], versionMinor)
+	// timezone 8:12 and sigfigs 12:16 are left as zero
+	binary.LittleEndian.PutUint32(hdr[16:20], snaplen(intf))
+	binary.LittleEndian.PutUint32(hdr[20:24], uint32(intf.LinkType))
+	if _, err := w.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	w.header = true
+	w.linkType = intf.LinkType
+	w.snaplen = snaplen(intf)
+	return nil
+}
+
+// needsNanoseconds returns true if the interface's timestamps are finer than
+// microseconds and so require the nanosecond legacy pcap magic.
+func needsNanoseconds(intf NgInterface) bool {
+	res := intf.TimestampResolution
+	return res.Binary() || res.Exponent() > 6
+}
+
+func snaplen(intf NgInterface) uint32 {
+	if intf.SnapLength == 0 || intf.SnapLength > defaultSnaplen {
+		return defaultSnaplen
+	}
+	return intf.SnapLength
+}
+
+func (w *PcapWriter) WritePacket(p Packet) error {
+	if !w.header {
+		intf := NgInterface{LinkType: p.LinkType, TimestampResolution: 9}
+		if err := w.writeHeader(intf); err != nil {
+			return err
+		}
+	}
+	if p.LinkType != w.linkType {
+		return errUnrepresentablef("legacy pcap link type %s, packet has link type %s", w.linkType, p.LinkType)
+	}
+	caplen := p.CaptureLength()
+	if caplen > int(w.snaplen) {
+		return errUnrepresentablef("capture length exceeds snap length: %d > %d", caplen, w.snaplen)
+	}
+	ns := int64(p.Ts)
+	binary.LittleEndian.PutUint32(w.buf[0:4], uint32(ns/1_000_000_000))
+	binary.LittleEndian.PutUint32(w.buf[4:8], uint32(ns%1_000_000_000)/w.nanoSecsFactor)
+	binary.LittleEndian.PutUint32(w.buf[8:12], uint32(caplen))
+	binary.LittleEndian.PutUint32(w.buf[12:16], uint32(p.Length))
+	if _, err := w.w.Write(w.buf[:]); err != nil {
+		return err
+	}
+	_, err := w.w.Write(p.Data)
+	return err
+}
@@ -0,0 +1,84 @@
+package pcapio
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/brimdata/zed/pkg/nano"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// ErrUnrepresentable is returned by a Writer when asked to write data that
+// its output format cannot hold, e.g., a second link type in a legacy pcap.
+var ErrUnrepresentable = errors.New("cannot be represented in output format")
+
+func errUnrepresentablef(format string, a ...interface{}) error {
+	return fmt.Errorf("%s: %w", fmt.Sprintf(format, a...), ErrUnrepresentable)
+}
+
+// Packet is a decoded packet record.  Data holds the captured portion of the
+// packet beginning with the link-layer header so the capture length is
+// len(Data).  Length is the original length of the packet on the wire.
+// Interface is the number of the interface the packet was captured on within
+// its section (always zero for formats without interfaces).  Options holds
+// the pcap-ng packet options (with DropCount equal to NgNoValue64 when
+// unknown), which are dropped by a legacy pcap Writer.
+type Packet struct {
+	Ts        nano.Ts
+	LinkType  layers.LinkType
+	Interface int
+	Length    int
+	Data      []byte
+	Options   NgPacketOptions
+}
+
+// CaptureLength returns the number of bytes of the packet that were captured.
+func (p Packet) CaptureLength() int {
+	return len(p.Data)
+}
+
+// Writer is an interface for writing a pcap, either a legacy pcap or a
+// next-gen pcap, from decoded data rather than from the raw blocks returned
+// by a Reader.  WriteSection starts a new section, WriteInterface describes
+// an interface in the current section, and WritePacket writes a packet
+// on the interface of the current section numbered by the packet's Interface
+// or, if that interface does not exist or has a different link type, on the
+// first interface whose link type matches that of the packet.  Sections and
+// interfaces are created as needed if WritePacket is called without them.
+type Writer interface {
+	WriteSection(NgSectionInfo) error
+	WriteInterface(NgInterface) error
+	WritePacket(Packet) error
+}
+
+// DecodePacket decodes a packet block returned by r.Read into a Packet
+// suitable for a Writer.  The returned Packet's Data references block.
+func DecodePacket(r Reader, block []byte) (Packet, error) {
+	data, ts, linkType, err := r.Packet(block)
+	if data == nil {
+		if err == nil {
+			err = errInvalidf("packet buffer length less than minimum packet size")
+		}
+		return Packet{}, err
+	}
+	length := len(data)
+	if r, ok := r.(interface{ origLen([]byte) int }); ok {
+		length = r.origLen(block)
+	}
+	var ifno int
+	opts := NgPacketOptions{DropCount: NgNoValue64}
+	if ng, ok := r.(*NgReader); ok {
+		ifno = ng.ifno(block)
+		if opts, err = ng.PacketOptions(block); err != nil {
+			return Packet{}, err
+		}
+	}
+	return Packet{
+		Ts:        ts,
+		LinkType:  linkType,
+		Interface: ifno,
+		Length:    length,
+		Data:      data,
+		Options:   opts,
+	}, nil
+}
@@ -0,0 +1,165 @@
+package pcapio_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/brimdata/brimcap/pcap/pcapio"
+	"github.com/brimdata/zed/pkg/nano"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var noOptions = pcapio.NgPacketOptions{DropCount: pcapio.NgNoValue64}
+
+var testPackets = []pcapio.Packet{
+	{Ts: 1425567047803929123, LinkType: layers.LinkTypeEthernet, Length: 60, Data: []byte("abcdefghij"), Options: noOptions},
+	{Ts: 1425567047804906000, LinkType: layers.LinkTypeEthernet, Length: 3, Data: []byte("abc"), Options: noOptions},
+	{Ts: 1425567047804914000, LinkType: layers.LinkTypeRaw, Interface: 1, Length: 1500, Data: []byte("0123456789abcdef"), Options: noOptions},
+}
+
+func readAll(t *testing.T, r io.Reader) []pcapio.Packet {
+	reader, err := pcapio.NewReader(r)
+	require.NoError(t, err)
+	var pkts []pcapio.Packet
+	for {
+		block, typ, err := reader.Read()
+		if err != io.EOF {
+			require.NoError(t, err)
+		}
+		if block == nil {
+			return pkts
+		}
+		if typ != pcapio.TypePacket {
+			continue
+		}
+		pkt, err := pcapio.DecodePacket(reader, block)
+		require.NoError(t, err)
+		pkt.Data = append([]byte(nil), pkt.Data...)
+		pkts = append(pkts, pkt)
+	}
+}
+
+func TestNgWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := pcapio.NewNgWriter(&buf)
+	require.NoError(t, w.WriteSection(pcapio.NgSectionInfo{Application: "brimcap"}))
+	require.NoError(t, w.WriteInterface(pcapio.NgInterface{
+		Name:                "eth0",
+		LinkType:            layers.LinkTypeEthernet,
+		TimestampResolution: 9,
+	}))
+	for _, p := range testPackets {
+		require.NoError(t, w.WritePacket(p))
+	}
+	assert.Equal(t, testPackets, readAll(t, &buf))
+}
+
+func TestNgWriterResolution(t *testing.T) {
+	cases := []struct {
+		resolution pcapio.NgResolution
+		offset     int64
+		expected   nano.Ts
+	}{
+		{6, 0, 1425567047803929000},
+		{9, -100, 1425567047803929123},
+		{0x94, 1000, 1425567047803928375},
+		{0xa0, 0, 1425567047803929123},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		w := pcapio.NewNgWriter(&buf)
+		require.NoError(t, w.WriteInterface(pcapio.NgInterface{
+			LinkType:            layers.LinkTypeEthernet,
+			TimestampResolution: c.resolution,
+			TimestampOffset:     c.offset,
+		}))
+		require.NoError(t, w.WritePacket(testPackets[0]))
+		pkts := readAll(t, &buf)
+		require.Len(t, pkts, 1)
+		assert.Equal(t, c.expected, pkts[0].Ts, "resolution %#x", uint8(c.resolution))
+	}
+}
+
+func TestNgWriterOverflow(t *testing.T) {
+	for _, res := range []pcapio.NgResolution{0xa8, 12} {
+		w := pcapio.NewNgWriter(io.Discard)
+		require.NoError(t, w.WriteInterface(pcapio.NgInterface{
+			LinkType:            layers.LinkTypeEthernet,
+			TimestampResolution: res,
+		}))
+		err := w.WritePacket(testPackets[0])
+		assert.ErrorIs(t, err, pcapio.ErrUnrepresentable, "resolution %#x", uint8(res))
+	}
+}
+
+func TestNgWriterInterfaces(t *testing.T) {
+	var buf bytes.Buffer
+	w := pcapio.NewNgWriter(&buf)
+	require.NoError(t, w.WriteInterface(pcapio.NgInterface{
+		Name:                "eth0",
+		LinkType:            layers.LinkTypeEthernet,
+		TimestampResolution: 6,
+	}))
+	require.NoError(t, w.WriteInterface(pcapio.NgInterface{
+		Name:                "eth1",
+		LinkType:            layers.LinkTypeEthernet,
+		TimestampResolution: 9,
+	}))
+	pkts := []pcapio.Packet{testPackets[0], testPackets[0]}
+	pkts[1].Interface = 1
+	for _, p := range pkts {
+		require.NoError(t, w.WritePacket(p))
+	}
+	out := readAll(t, &buf)
+	require.Len(t, out, 2)
+	assert.Equal(t, 0, out[0].Interface)
+	assert.Equal(t, nano.Ts(1425567047803929000), out[0].Ts)
+	assert.Equal(t, pkts[1], out[1])
+}
+
+func TestNgWriterPacketOptions(t *testing.T) {
+	var buf bytes.Buffer
+	w := pcapio.NewNgWriter(&buf)
+	p := testPackets[0]
+	p.Options = pcapio.NgPacketOptions{
+		Comments:  []string{"first", "second"},
+		Flags:     uint32(pcapio.NgDirectionOutbound),
+		Hashes:    []pcapio.NgPacketHash{{Algorithm: 2, Value: []byte{1, 2, 3, 4}}},
+		DropCount: 7,
+	}
+	require.NoError(t, w.WritePacket(p))
+	assert.Equal(t, []pcapio.Packet{p}, readAll(t, &buf))
+}
+
+func TestNgWriterOptionTooLong(t *testing.T) {
+	var buf bytes.Buffer
+	w := pcapio.NewNgWriter(&buf)
+	p := testPackets[0]
+	p.Options.Comments = []string{strings.Repeat("x", 65536)}
+	assert.ErrorIs(t, w.WritePacket(p), pcapio.ErrUnrepresentable)
+	p.Options.Comments = []string{strings.Repeat("x", 65535)}
+	require.NoError(t, w.WritePacket(p))
+	assert.Equal(t, []pcapio.Packet{p}, readAll(t, &buf))
+}
+
+func TestPcapWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := pcapio.NewPcapWriter(&buf)
+	for _, p := range testPackets[:2] {
+		require.NoError(t, w.WritePacket(p))
+	}
+	assert.Equal(t, testPackets[:2], readAll(t, &buf))
+}
+
+func TestPcapWriterUnrepresentable(t *testing.T) {
+	w := pcapio.NewPcapWriter(io.Discard)
+	require.NoError(t, w.WriteInterface(pcapio.NgInterface{LinkType: layers.LinkTypeEthernet}))
+	err := w.WriteInterface(pcapio.NgInterface{LinkType: layers.LinkTypeRaw})
+	assert.ErrorIs(t, err, pcapio.ErrUnrepresentable)
+	err = w.WritePacket(testPackets[2])
+	assert.ErrorIs(t, err, pcapio.ErrUnrepresentable)
+}
@@ -1,10 +1,15 @@
 package pcap
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"net"
+	"slices"
 
 	"github.com/brimdata/brimcap/pcap/pcapio"
 	"github.com/brimdata/zed/pkg/nano"
@@ -19,28 +24,54 @@ type PacketFilter func(gopacket.Packet) bool
 
 // Search describes the parameters for a packet search over a pcap file.
 type Search struct {
-	span   nano.Span
-	filter PacketFilter
+	span      nano.Span
+	flow      headerFilter
+	tunnel    TunnelLayer
+	defrag    bool
+	filter    PacketFilter
+	direction pcapio.NgDirection
+	dedup     nano.Duration
+	// keys holds the flow keys of the packets the search may match for
+	// pruning the regions of an index without them (see GenerateSlices).
+	keys *searchKeys
+	// alts holds the searches of a union (see Union).
+	alts []Search
 }
 
 func NewTCPSearch(span nano.Span, flow Flow) Search {
 	return Search{
-		span:   span,
-		filter: genTCPFilter(flow),
+		span: span,
+		flow: genTCPFilter(flow),
+		keys: newTupleKeys(layers.IPProtocolTCP, flow),
 	}
 }
 
 func NewUDPSearch(span nano.Span, flow Flow) Search {
 	return Search{
-		span:   span,
-		filter: genUDPFilter(flow),
+		span: span,
+		flow: genUDPFilter(flow),
+		keys: newTupleKeys(layers.IPProtocolUDP, flow),
+	}
+}
+
+// NewSCTPSearch returns a search for the SCTP association of flow.  If vtags
+// is not empty, only packets whose verification tag is one of vtags or zero
+// (as for packets carrying an INIT chunk) match.
+func NewSCTPSearch(span nano.Span, flow Flow, vtags ...uint32) Search {
+	return Search{
+		span: span,
+		flow: genSCTPFilter(flow, vtags),
+		keys: newTupleKeys(layers.IPProtocolSCTP, flow),
 	}
 }
 
+// NewICMPSearch returns a search for the ICMP and ICMPv6 messages between src
+// and dst.  WithICMPType and WithICMPEchoID narrow the search.
 func NewICMPSearch(span nano.Span, src, dst net.IP) Search {
 	return Search{
-		span:   span,
-		filter: genICMPFilter(src, dst),
+		span: span,
+		flow: genICMPFilter(src, dst),
+		keys: &searchKeys{pair: PairKey(src, dst)},
 	}
 }
 
@@ -50,18 +81,290 @@ func NewRangeSearch(span nano.Span) Search {
 	}
 }
 
+// Union returns a search for the packets matched by any of searches, each
+// within its own span, so that the packets of many flows are found in a
+// single pass over a pcap.  The span of the union covers those of searches.
+// A packet matched by several of searches is written once.  The union
+// defragments (see WithDefrag) if any of searches does and drops duplicates
+// (see WithDedup) within the largest window of searches.  Options set on the
+// union apply on top of those of searches.
+func Union(searches ...Search) Search {
+	var u Search
+	for i, s := range searches {
+		if i == 0 {
+			u.span = s.span
+		} else {
+			u.span = u.span.Union(s.span)
+		}
+		u.defrag = u.defrag || s.defrag
+		u.dedup = max(u.dedup, s.dedup)
+	}
+	u.alts = searches
+	return u
+}
+
+// searchKeys are the flow keys of a search.  Since the fragments of a
+// datagram other than the first lack a transport header, only pair is
+// present in the index for them.
+type searchKeys struct {
+	pair     FlowKey
+	tuple    FlowKey
+	hasTuple bool
+}
+
+func newTupleKeys(proto layers.IPProtocol, flow Flow) *searchKeys {
+	return &searchKeys{
+		pair:     PairKey(flow.S0.IP, flow.S1.IP),
+		tuple:    TupleKey(proto, flow),
+		hasTuple: true,
+	}
+}
+
+// target is a span of a search along with the flow keys of the packets
+// matched within it, which are nil if any packet may match.
+type target struct {
+	span nano.Span
+	keys []FlowKey
+}
+
+// targets returns the targets of s or, if s is a union, those of each of its
+// searches.
+func (s Search) targets() []target {
+	if len(s.alts) == 0 {
+		return []target{{s.span, s.flowKeys(s.defrag)}}
+	}
+	var targets []target
+	for _, alt := range s.alts {
+		// The union defragments on behalf of all of its searches.
+		alt.defrag = alt.defrag || s.defrag
+		targets = append(targets, alt.targets()...)
+	}
+	return targets
+}
+
+func (s Search) flowKeys(defrag bool) []FlowKey {
+	switch {
+	case s.keys == nil:
+		return nil
+	case defrag || !s.keys.hasTuple:
+		return []FlowKey{s.keys.pair}
+	}
+	return []FlowKey{s.keys.tuple}
+}
+
 func (s Search) Span() nano.Span {
 	return s.span
 }
 
-func matchIP(packet gopacket.Packet) (net.IP, net.IP, bool) {
-	network := packet.NetworkLayer()
-	if ip, ok := network.(*layers.IPv4); ok {
-		return ip.SrcIP, ip.DstIP, true
-	} else if ip, ok := network.(*layers.IPv6); ok {
-		return ip.SrcIP, ip.DstIP, true
+// Dedup returns the window set by WithDedup.
+func (s Search) Dedup() nano.Duration {
+	return s.dedup
+}
+
+// WithDirection returns a copy of s that matches only pcap-ng packets whose
+// epb_flags option indicates they were captured in direction dir.  Packets
+// of unknown direction (including all packets of legacy pcaps) never match.
+func (s Search) WithDirection(dir pcapio.NgDirection) Search {
+	s.direction = dir
+	return s
+}
+
+// WithTunnel returns a copy of s whose flow is matched against the IP headers
+// of tunneled packets selected by layer.
+func (s Search) WithTunnel(layer TunnelLayer) Search {
+	s.tunnel = layer
+	return s
+}
+
+// WithDefrag returns a copy of s that, if defrag is true, tracks fragmented
+// IPv4 and IPv6 datagrams so that all fragments of a datagram match when its
+// initial fragment, which holds the transport header, does.  Fragments that
+// precede the initial fragment of their datagram in the input are written
+// right after it.
+func (s Search) WithDefrag(defrag bool) Search {
+	s.defrag = defrag
+	return s
+}
+
+// WithDedup returns a copy of s that, if window is positive, drops matching
+// packets that duplicate a packet written within window of them (see
+// pcapio.Deduper), keeping the first of each run of duplicates.
+func (s Search) WithDedup(window nano.Duration) Search {
+	s.dedup = window
+	return s
+}
+
+// WithCommunityID returns a copy of s that matches only packets of the flow
+// with Community ID id computed using seed.
+func (s Search) WithCommunityID(id CommunityID, seed uint16) Search {
+	return s.withFlowFilter(genCommunityIDFilter(id, seed))
+}
+
+// WithICMPType returns a copy of s that matches only the ICMP and ICMPv6
+// messages of the exchange keyed by typ and code as in Zeek's conn log, whose
+// id.orig_p and id.resp_p fields of ICMP connections are a message type and
+// code.  For message types with a counterpart, like echo request and echo
+// reply, code is ignored and messages of both types match.
+func (s Search) WithICMPType(typ, code uint8) Search {
+	return s.withFlowFilter(genICMPTypeFilter(typ, code))
+}
+
+// WithICMPEchoID returns a copy of s that matches only the ICMP and ICMPv6
+// echo requests and replies with identifier id.
+func (s Search) WithICMPEchoID(id uint16) Search {
+	return s.withFlowFilter(genICMPEchoIDFilter(id))
+}
+
+func (s Search) withFlowFilter(filter headerFilter) Search {
+	if prev := s.flow; prev != nil {
+		s.flow = func(h ipHeader) bool {
+			return prev(h) && filter(h)
+		}
+	} else {
+		s.flow = filter
+	}
+	return s
+}
+
+// WithFilter returns a copy of s that matches only packets that also match
+// filter.
+func (s Search) WithFilter(filter PacketFilter) Search {
+	if prev := s.filter; prev != nil {
+		s.filter = func(packet gopacket.Packet) bool {
+			return prev(packet) && filter(packet)
+		}
+	} else {
+		s.filter = filter
+	}
+	return s
+}
+
+func (s Search) filtering() bool {
+	return s.flow != nil || s.filter != nil || slices.ContainsFunc(s.alts, Search.filtering)
+}
+
+// selecting returns true if s leaves out packets of a capture other than
+// duplicates, i.e., if it filters or its span doesn't cover all time.
+func (s Search) selecting() bool {
+	return s.filtering() || s.direction != pcapio.NgDirectionUnknown ||
+		s.span.Ts > 0 || s.span.End() < nano.MaxTs ||
+		slices.ContainsFunc(s.alts, Search.selecting)
+}
+
+func (s Search) match(packet gopacket.Packet, headers []ipHeader) bool {
+	if s.flow != nil && !s.tunnel.match(headers, s.flow) {
+		return false
+	}
+	return s.filter == nil || s.filter(packet)
+}
+
+// TunnelLayer selects which IP headers of a tunneled packet a flow is matched
+// against.
+type TunnelLayer int
+
+const (
+	// TunnelEither matches a flow against any of a packet's IP headers.
+	TunnelEither TunnelLayer = iota
+	// TunnelOuter matches a flow against a packet's outermost IP header.
+	TunnelOuter
+	// TunnelInner matches a flow against a packet's innermost IP header.
+	TunnelInner
+)
+
+// ParseTunnelLayer parses "either", "outer", or "inner".
+func ParseTunnelLayer(s string) (TunnelLayer, error) {
+	switch s {
+	case "either":
+		return TunnelEither, nil
+	case "outer":
+		return TunnelOuter, nil
+	case "inner":
+		return TunnelInner, nil
+	}
+	return TunnelEither, fmt.Errorf("unknown tunnel layer: %q", s)
+}
+
+func (t TunnelLayer) String() string {
+	switch t {
+	case TunnelOuter:
+		return "outer"
+	case TunnelInner:
+		return "inner"
+	}
+	return "either"
+}
+
+func (t TunnelLayer) match(headers []ipHeader, filter headerFilter) bool {
+	if len(headers) == 0 {
+		return false
+	}
+	switch t {
+	case TunnelOuter:
+		return filter(headers[0])
+	case TunnelInner:
+		return filter(headers[len(headers)-1])
+	}
+	for _, h := range headers {
+		if filter(h) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipHeader is an IP header of a packet along with the transport or control
+// layer it carries, if any.
+type ipHeader struct {
+	src       net.IP
+	dst       net.IP
+	transport gopacket.Layer
+	// frag is set if the header is that of an IP fragment.
+	frag *fragment
+}
+
+type headerFilter func(ipHeader) bool
+
+// ipHeaders returns the IP headers of packet from outermost to innermost.
+// Since gopacket decodes through VLAN and QinQ tags, MPLS labels, and GRE,
+// VXLAN, GENEVE, and IP-in-IP encapsulations, a tunneled packet has a header
+// for each tunnel as well as one for the inner packet.  gopacket leaves the
+// payloads of IP fragments undecoded, so the payload of an initial fragment
+// is decoded here to find the transport header of its datagram.
+func ipHeaders(packet gopacket.Packet) []ipHeader {
+	var headers []ipHeader
+	pending := packet.Layers()
+	for len(pending) > 0 {
+		layer := pending[0]
+		pending = pending[1:]
+		switch layer := layer.(type) {
+		case *layers.IPv4:
+			h := ipHeader{src: layer.SrcIP, dst: layer.DstIP}
+			if layer.Flags&layers.IPv4MoreFragments != 0 || layer.FragOffset != 0 {
+				h.frag = newFragment(layer.SrcIP, layer.DstIP, layer.Protocol, uint32(layer.Id), layer.FragOffset, layer.Flags&layers.IPv4MoreFragments != 0)
+			}
+			headers = append(headers, h)
+		case *layers.IPv6:
+			headers = append(headers, ipHeader{src: layer.SrcIP, dst: layer.DstIP})
+		case *layers.IPv6Fragment:
+			if n := len(headers); n > 0 && headers[n-1].frag == nil {
+				h := &headers[n-1]
+				h.frag = newFragment(h.src, h.dst, layer.NextHeader, layer.Identification, layer.FragmentOffset, layer.MoreFragments)
+			}
+		case *gopacket.Fragment:
+			n := len(headers)
+			if n == 0 || headers[n-1].frag == nil || headers[n-1].frag.offset != 0 || headers[n-1].transport != nil {
+				break
+			}
+			opts := gopacket.DecodeOptions{Lazy: true, NoCopy: true}
+			payload := gopacket.NewPacket(*layer, headers[n-1].frag.key.proto, opts)
+			pending = append(payload.Layers(), pending...)
+		case *layers.TCP, *layers.UDP, *layers.SCTP, *layers.ICMPv4, *layers.ICMPv6:
+			if n := len(headers); n > 0 && headers[n-1].transport == nil {
+				headers[n-1].transport = layer
+			}
+		}
 	}
-	return nil, nil, false
+	return headers
 }
 
 func genFlowFilter(flow Flow) func(Socket, Socket) bool {
@@ -70,52 +373,97 @@ func genFlowFilter(flow Flow) func(Socket, Socket) bool {
 	}
 }
 
-func genTCPFilter(flow Flow) PacketFilter {
+func genTCPFilter(flow Flow) headerFilter {
 	match := genFlowFilter(flow)
-	return func(packet gopacket.Packet) bool {
-		srcIP, dstIP, ok := matchIP(packet)
+	return func(h ipHeader) bool {
+		tcp, ok := h.transport.(*layers.TCP)
 		if !ok {
 			return false
 		}
-		transport := packet.TransportLayer()
-		tcp, ok := transport.(*layers.TCP)
+		src := Socket{h.src, int(tcp.SrcPort)}
+		dst := Socket{h.dst, int(tcp.DstPort)}
+		return match(src, dst) || match(dst, src)
+	}
+}
+
+func genUDPFilter(flow Flow) headerFilter {
+	match := genFlowFilter(flow)
+	return func(h ipHeader) bool {
+		udp, ok := h.transport.(*layers.UDP)
 		if !ok {
 			return false
 		}
-		src := Socket{srcIP, int(tcp.SrcPort)}
-		dst := Socket{dstIP, int(tcp.DstPort)}
+		src := Socket{h.src, int(udp.SrcPort)}
+		dst := Socket{h.dst, int(udp.DstPort)}
 		return match(src, dst) || match(dst, src)
 	}
 }
 
-func genUDPFilter(flow Flow) PacketFilter {
+func genSCTPFilter(flow Flow, vtags []uint32) headerFilter {
 	match := genFlowFilter(flow)
-	return func(packet gopacket.Packet) bool {
-		srcIP, dstIP, ok := matchIP(packet)
+	return func(h ipHeader) bool {
+		sctp, ok := h.transport.(*layers.SCTP)
 		if !ok {
 			return false
 		}
-		transport := packet.TransportLayer()
-		udp, ok := transport.(*layers.UDP)
-		if !ok {
+		if len(vtags) > 0 && sctp.VerificationTag != 0 && !slices.Contains(vtags, sctp.VerificationTag) {
 			return false
 		}
-		src := Socket{srcIP, int(udp.SrcPort)}
-		dst := Socket{dstIP, int(udp.DstPort)}
+		src := Socket{h.src, int(sctp.SrcPort)}
+		dst := Socket{h.dst, int(sctp.DstPort)}
 		return match(src, dst) || match(dst, src)
 	}
 }
 
-func genICMPFilter(src, dst net.IP) PacketFilter {
-	return func(packet gopacket.Packet) bool {
-		if packet.LayerClass(layers.LayerClassIPControl) == nil {
+func genICMPFilter(src, dst net.IP) headerFilter {
+	return func(h ipHeader) bool {
+		if h.transport == nil || !layers.LayerClassIPControl.Contains(h.transport.LayerType()) {
 			return false
 		}
-		srcIP, dstIP, ok := matchIP(packet)
+		return (src.Equal(h.src) && dst.Equal(h.dst)) || (src.Equal(h.dst) && dst.Equal(h.src))
+	}
+}
+
+// icmpTypeCode returns the type and code of an ICMP or ICMPv6 layer.
+func icmpTypeCode(layer gopacket.Layer) (uint8, uint8, bool, bool) {
+	switch layer := layer.(type) {
+	case *layers.ICMPv4:
+		return layer.TypeCode.Type(), layer.TypeCode.Code(), false, true
+	case *layers.ICMPv6:
+		return layer.TypeCode.Type(), layer.TypeCode.Code(), true, true
+	}
+	return 0, 0, false, false
+}
+
+func genICMPTypeFilter(typ, code uint8) headerFilter {
+	return func(h ipHeader) bool {
+		t, c, v6, ok := icmpTypeCode(h.transport)
 		if !ok {
 			return false
 		}
-		return (src.Equal(srcIP) && dst.Equal(dstIP)) || (src.Equal(dstIP) && dst.Equal(srcIP))
+		want0, want1, oneWay := ICMPPorts(typ, code, v6)
+		p0, p1, _ := ICMPPorts(t, c, v6)
+		return (p0 == want0 && p1 == want1) || (!oneWay && p0 == want1 && p1 == want0)
+	}
+}
+
+func genICMPEchoIDFilter(id uint16) headerFilter {
+	return func(h ipHeader) bool {
+		switch layer := h.transport.(type) {
+		case *layers.ICMPv4:
+			switch layer.TypeCode.Type() {
+			case layers.ICMPv4TypeEchoRequest, layers.ICMPv4TypeEchoReply:
+				return layer.Id == id
+			}
+		case *layers.ICMPv6:
+			// The identifier begins the body of ICMPv6 echo
+			// messages.
+			switch layer.TypeCode.Type() {
+			case layers.ICMPv6TypeEchoRequest, layers.ICMPv6TypeEchoReply:
+				return len(layer.Payload) >= 2 && binary.BigEndian.Uint16(layer.Payload) == id
+			}
+		}
+		return false
 	}
 }
 
@@ -135,11 +483,23 @@ type SearchReader struct {
 	opts   gopacket.DecodeOptions
 	window []byte
 	buf    []byte
+	// keylog holds the TLS key log lines of the current section, which
+	// are written out only for the sessions of matching packets when
+	// selecting.
+	keylog []byte
+	frags  *defragmenter
+	dedup  *pcapio.Deduper
 }
 
 func (s Search) Reader(ctx context.Context, r pcapio.Reader) (*SearchReader, error) {
 	opts := gopacket.DecodeOptions{Lazy: true, NoCopy: true}
 	reader := &SearchReader{Search: s, reader: r, opts: opts}
+	if s.defrag && s.filtering() {
+		reader.frags = newDefragmenter()
+	}
+	if s.dedup > 0 {
+		reader.dedup = pcapio.NewDeduper(s.dedup)
+	}
 	if err := reader.fill(ctx); err != nil {
 		return nil, err
 	}
@@ -179,18 +539,26 @@ func (s *SearchReader) fill(ctx context.Context) error {
 		if block == nil {
 			break
 		}
-		// Write any blocks that aren't packets.  For pcap-ng, this has
-		// the effect of writing out all the sections in the underlying
-		// pcap, with empty sections where there a no matches.  This is a
-		// bit ugly but perfectly valid output.  We could clean this up
-		// by looking for sections headers, a buffering unnwritten sections
-		// until we get to the first packet and never writing the blocksa
-		// for sections that have no packets.
+		// Blocks that aren't packets are buffered with the packets
+		// that follow them and written only along with a matching
+		// packet.  A section header discards the blocks buffered for
+		// the previous section, so sections without matches are
+		// dropped from the output.
 		switch typ {
 		case pcapio.TypeSection:
 			s.buf = append(s.buf[:0], block...)
-		case pcapio.TypeInterface:
+			s.keylog = s.keylog[:0]
+			if s.frags != nil {
+				s.frags.reset()
+			}
+		case pcapio.TypeInterface, pcapio.TypeNameResolution:
 			s.buf = append(s.buf, block...)
+		case pcapio.TypeInterfaceStatistics:
+			// Drop statistics since they don't describe the output.
+		case pcapio.TypeDecryptionSecrets:
+			if err := s.addSecrets(block); err != nil {
+				return err
+			}
 		default:
 			pktBuf, ts, linkType, err := s.reader.Packet(block)
 			if pktBuf == nil {
@@ -199,14 +567,202 @@ func (s *SearchReader) fill(ctx context.Context) error {
 			if !s.span.ContainsClosed(ts) {
 				continue
 			}
+			if s.direction != pcapio.NgDirectionUnknown && s.packetDirection(block) != s.direction {
+				continue
+			}
 			packet := gopacket.NewPacket(pktBuf, linkType, s.opts)
-			if s.filter != nil && !s.filter(packet) {
+			var headers []ipHeader
+			if s.flow != nil || s.frags != nil || len(s.alts) > 0 {
+				headers = ipHeaders(packet)
+			}
+			matched, fragment := false, false
+			if s.frags != nil {
+				// Fragments may be held for output so
+				// convert them up front.
+				if block, err = s.enhancedPacket(block); err != nil {
+					return err
+				}
+				matched, fragment = s.frags.later(headers, ts, block)
+			}
+			var pending [][]byte
+			if !fragment {
+				matched = s.matchAlts(packet, headers, ts, block)
+				if s.frags != nil {
+					pending = s.frags.initial(headers, ts, matched)
+				}
+			}
+			if !matched {
+				continue
+			}
+			// With dedup, duplicates are dropped here, after
+			// matching, so the first match of a run is kept.
+			dup, err := s.duplicate(block)
+			if err != nil {
+				return err
+			}
+			wrote := !dup
+			if !dup {
+				if block, err = s.enhancedPacket(block); err != nil {
+					return err
+				}
+				s.appendSecrets(packet)
+				s.buf = append(s.buf, block...)
+			}
+			for _, b := range pending {
+				if dup, err = s.duplicate(b); err != nil {
+					return err
+				}
+				if !dup {
+					s.buf = append(s.buf, b...)
+					wrote = true
+				}
+			}
+			if !wrote {
 				continue
 			}
-			s.buf = append(s.buf, block...)
 			s.window = s.buf[:]
 			return nil
 		}
 	}
 	return nil
 }
+
+// matchAlts returns true if packet matches s and, if s is a union, one of
+// its searches.
+func (s *SearchReader) matchAlts(packet gopacket.Packet, headers []ipHeader, ts nano.Ts, block []byte) bool {
+	if !s.match(packet, headers) {
+		return false
+	}
+	if len(s.alts) == 0 {
+		return true
+	}
+	for _, alt := range s.alts {
+		if !alt.span.ContainsClosed(ts) {
+			continue
+		}
+		if alt.direction != pcapio.NgDirectionUnknown && s.packetDirection(block) != alt.direction {
+			continue
+		}
+		if alt.match(packet, headers) {
+			return true
+		}
+	}
+	return false
+}
+
+// duplicate returns true if the packet in block duplicates a packet already
+// written.
+func (s *SearchReader) duplicate(block []byte) (bool, error) {
+	if s.dedup == nil {
+		return false, nil
+	}
+	p, err := pcapio.DecodePacket(s.reader, block)
+	if err != nil {
+		return false, err
+	}
+	return s.dedup.Duplicate(p.Ts, p.Data, p.Length), nil
+}
+
+// enhancedPacket converts a pcap-ng simple packet block to an enhanced packet
+// block since the output doesn't keep the blocks that simple packets take
+// their timestamps from.
+func (s *SearchReader) enhancedPacket(block []byte) ([]byte, error) {
+	if ng, ok := s.reader.(*pcapio.NgReader); ok {
+		return ng.EnhancedPacket(block)
+	}
+	return block, nil
+}
+
+func (s *SearchReader) packetDirection(block []byte) pcapio.NgDirection {
+	ng, ok := s.reader.(*pcapio.NgReader)
+	if !ok {
+		return pcapio.NgDirectionUnknown
+	}
+	opts, err := ng.PacketOptions(block)
+	if err != nil {
+		return pcapio.NgDirectionUnknown
+	}
+	return opts.Direction()
+}
+
+// addSecrets handles a decryption secrets block.  If the search matches every
+// packet, the block is written out as is.  Otherwise, TLS key log lines are
+// saved so that those for the sessions of matching packets can be written by
+// appendSecrets and all other secrets are dropped.
+func (s *SearchReader) addSecrets(block []byte) error {
+	if !s.selecting() {
+		s.buf = append(s.buf, block...)
+		return nil
+	}
+	ng, ok := s.reader.(*pcapio.NgReader)
+	if !ok {
+		return nil
+	}
+	secrets, err := ng.DecryptionSecrets(block)
+	if err != nil || secrets.Type != pcapio.NgSecretsTypeTLSKeyLog {
+		return err
+	}
+	s.keylog = append(s.keylog, secrets.Data...)
+	if n := len(s.keylog); n > 0 && s.keylog[n-1] != '\n' {
+		s.keylog = append(s.keylog, '\n')
+	}
+	return nil
+}
+
+// appendSecrets appends a decryption secrets block holding the TLS key log
+// lines for the session started by packet if it is a TLS ClientHello.
+func (s *SearchReader) appendSecrets(packet gopacket.Packet) {
+	if len(s.keylog) == 0 {
+		return
+	}
+	random := tlsClientRandom(packet)
+	if random == nil {
+		return
+	}
+	lines := keyLogLines(s.keylog, random)
+	if len(lines) == 0 {
+		return
+	}
+	secrets := pcapio.NgDecryptionSecrets{
+		Type: pcapio.NgSecretsTypeTLSKeyLog,
+		Data: lines,
+	}
+	s.buf = append(s.buf, s.reader.(*pcapio.NgReader).DecryptionSecretsBlock(secrets)...)
+}
+
+// tlsClientRandom returns the client random of a TLS ClientHello beginning
+// at the start of the packet's TCP payload or nil if there is none.
+func tlsClientRandom(packet gopacket.Packet) []byte {
+	headers := ipHeaders(packet)
+	if len(headers) == 0 {
+		return nil
+	}
+	// The innermost TCP segment carries the TLS session of a tunneled
+	// packet.
+	tcp, ok := headers[len(headers)-1].transport.(*layers.TCP)
+	if !ok {
+		return nil
+	}
+	// The random follows the record header (content type 22 for
+	// handshake), the handshake header (type 1 for ClientHello), and the
+	// client version.
+	b := tcp.Payload
+	if len(b) < 43 || b[0] != 22 || b[5] != 1 {
+		return nil
+	}
+	return b[11:43]
+}
+
+// keyLogLines returns the lines of keylog, which is in the NSS key log
+// format, whose client random matches random.
+func keyLogLines(keylog, random []byte) []byte {
+	want := []byte(hex.EncodeToString(random))
+	var lines []byte
+	for _, line := range bytes.SplitAfter(keylog, []byte{'\n'}) {
+		fields := bytes.Fields(line)
+		if len(fields) == 3 && bytes.EqualFold(fields[1], want) {
+			lines = append(lines, line...)
+		}
+	}
+	return lines
+}
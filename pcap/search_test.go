@@ -0,0 +1,280 @@
+package pcap_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/brimdata/brimcap/pcap"
+	"github.com/brimdata/brimcap/pcap/pcapio"
+	"github.com/brimdata/zed/pkg/nano"
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	inner0 = net.IP{10, 0, 0, 1}
+	inner1 = net.IP{10, 0, 0, 2}
+	outer0 = net.IP{172, 16, 0, 1}
+	outer1 = net.IP{172, 16, 0, 2}
+	mac    = net.HardwareAddr{0, 1, 2, 3, 4, 5}
+)
+
+func serialize(t *testing.T, ls ...gopacket.SerializableLayer) []byte {
+	for _, l := range ls {
+		if nl, ok := l.(gopacket.NetworkLayer); ok {
+			for _, l := range ls {
+				switch l := l.(type) {
+				case *layers.TCP:
+					require.NoError(t, l.SetNetworkLayerForChecksum(nl))
+				case *layers.UDP:
+					require.NoError(t, l.SetNetworkLayerForChecksum(nl))
+				case *layers.ICMPv6:
+					require.NoError(t, l.SetNetworkLayerForChecksum(nl))
+				}
+			}
+		}
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, ls...))
+	return buf.Bytes()
+}
+
+func ipv4(proto layers.IPProtocol, src, dst net.IP) *layers.IPv4 {
+	return &layers.IPv4{Version: 4, TTL: 64, Protocol: proto, SrcIP: src, DstIP: dst}
+}
+
+func ether(typ layers.EthernetType) *layers.Ethernet {
+	return &layers.Ethernet{SrcMAC: mac, DstMAC: mac, EthernetType: typ}
+}
+
+// tunnelPackets returns a packet of the TCP flow between inner0:1234 and
+// inner1:80 sent without encapsulation and in each supported encapsulation,
+// where the tunnel endpoints are outer0 and outer1.
+func tunnelPackets(t *testing.T) [][]byte {
+	tcp := func() *layers.TCP { return &layers.TCP{SrcPort: 1234, DstPort: 80, SYN: true} }
+	return [][]byte{
+		serialize(t, ether(layers.EthernetTypeIPv4),
+			ipv4(layers.IPProtocolTCP, inner0, inner1), tcp()),
+		serialize(t, ether(layers.EthernetTypeQinQ),
+			&layers.Dot1Q{VLANIdentifier: 100, Type: layers.EthernetTypeDot1Q},
+			&layers.Dot1Q{VLANIdentifier: 200, Type: layers.EthernetTypeMPLSUnicast},
+			&layers.MPLS{Label: 16, StackBottom: true, TTL: 64},
+			ipv4(layers.IPProtocolTCP, inner0, inner1), tcp()),
+		serialize(t, ether(layers.EthernetTypeIPv4),
+			ipv4(layers.IPProtocolGRE, outer0, outer1),
+			&layers.GRE{Protocol: layers.EthernetTypeIPv4},
+			ipv4(layers.IPProtocolTCP, inner0, inner1), tcp()),
+		serialize(t, ether(layers.EthernetTypeIPv4),
+			ipv4(layers.IPProtocolUDP, outer0, outer1),
+			&layers.UDP{SrcPort: 50000, DstPort: 4789},
+			&layers.VXLAN{ValidIDFlag: true, VNI: 42},
+			ether(layers.EthernetTypeIPv4),
+			ipv4(layers.IPProtocolTCP, inner0, inner1), tcp()),
+		serialize(t, ether(layers.EthernetTypeIPv4),
+			ipv4(layers.IPProtocolUDP, outer0, outer1),
+			&layers.UDP{SrcPort: 50000, DstPort: 6081},
+			&layers.Geneve{Protocol: layers.EthernetTypeTransparentEthernetBridging},
+			ether(layers.EthernetTypeIPv4),
+			ipv4(layers.IPProtocolTCP, inner0, inner1), tcp()),
+		serialize(t, ether(layers.EthernetTypeIPv4),
+			ipv4(layers.IPProtocolIPv4, outer0, outer1),
+			ipv4(layers.IPProtocolTCP, inner0, inner1), tcp()),
+	}
+}
+
+func searchPackets(t *testing.T, search pcap.Search, packets [][]byte) []nano.Ts {
+	var buf bytes.Buffer
+	w := pcapio.NewNgWriter(&buf)
+	require.NoError(t, w.WriteInterface(pcapio.NgInterface{LinkType: layers.LinkTypeEthernet, TimestampResolution: 9}))
+	for i, data := range packets {
+		p := pcapio.Packet{Ts: nano.Ts(i), LinkType: layers.LinkTypeEthernet, Length: len(data), Data: data}
+		require.NoError(t, w.WritePacket(p))
+	}
+	r, err := pcapio.NewReader(&buf)
+	require.NoError(t, err)
+	var out bytes.Buffer
+	err = search.Run(context.Background(), &out, r)
+	if err == pcap.ErrNoPcapsFound {
+		return nil
+	}
+	require.NoError(t, err)
+	return readTimestamps(t, &out)
+}
+
+// readTimestamps returns the timestamps of the packets of the pcap read from r.
+func readTimestamps(t *testing.T, in io.Reader) []nano.Ts {
+	r, err := pcapio.NewReader(in)
+	require.NoError(t, err)
+	var matches []nano.Ts
+	for {
+		block, typ, err := r.Read()
+		if err != io.EOF {
+			require.NoError(t, err)
+		}
+		if block == nil {
+			return matches
+		}
+		if typ == pcapio.TypePacket {
+			p, err := pcapio.DecodePacket(r, block)
+			require.NoError(t, err)
+			matches = append(matches, p.Ts)
+		}
+	}
+}
+
+func TestSearchTunnel(t *testing.T) {
+	packets := tunnelPackets(t)
+	span := nano.Span{Ts: 0, Dur: nano.Duration(len(packets))}
+	innerFlow := pcap.NewFlow(inner0, 1234, inner1, 80)
+	outerFlow := pcap.NewFlow(outer0, 50000, outer1, 4789)
+	cases := []struct {
+		name     string
+		search   pcap.Search
+		expected []nano.Ts
+	}{
+		{"inner tcp either", pcap.NewTCPSearch(span, innerFlow), []nano.Ts{0, 1, 2, 3, 4, 5}},
+		{"inner tcp inner", pcap.NewTCPSearch(span, innerFlow).WithTunnel(pcap.TunnelInner), []nano.Ts{0, 1, 2, 3, 4, 5}},
+		{"inner tcp outer", pcap.NewTCPSearch(span, innerFlow).WithTunnel(pcap.TunnelOuter), []nano.Ts{0, 1}},
+		{"outer udp either", pcap.NewUDPSearch(span, outerFlow), []nano.Ts{3}},
+		{"outer udp outer", pcap.NewUDPSearch(span, outerFlow).WithTunnel(pcap.TunnelOuter), []nano.Ts{3}},
+		{"outer udp inner", pcap.NewUDPSearch(span, outerFlow).WithTunnel(pcap.TunnelInner), nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, searchPackets(t, c.search, packets))
+		})
+	}
+}
+
+func TestParseTunnelLayer(t *testing.T) {
+	for _, layer := range []pcap.TunnelLayer{pcap.TunnelEither, pcap.TunnelOuter, pcap.TunnelInner} {
+		parsed, err := pcap.ParseTunnelLayer(layer.String())
+		require.NoError(t, err)
+		assert.Equal(t, layer, parsed)
+	}
+	_, err := pcap.ParseTunnelLayer("middle")
+	assert.EqualError(t, err, `unknown tunnel layer: "middle"`)
+}
+
+func TestSearchSCTP(t *testing.T) {
+	sctp := func(src, dst net.IP, sport, dport layers.SCTPPort, vtag uint32) []byte {
+		return serialize(t, ether(layers.EthernetTypeIPv4), ipv4(layers.IPProtocolSCTP, src, dst),
+			&layers.SCTP{SrcPort: sport, DstPort: dport, VerificationTag: vtag})
+	}
+	packets := [][]byte{
+		sctp(inner0, inner1, 3868, 3868, 0),
+		sctp(inner1, inner0, 3868, 3868, 0xaaaa),
+		sctp(inner0, inner1, 3868, 3868, 0xbbbb),
+		sctp(inner0, inner1, 3868, 3868, 0xcccc),
+		sctp(inner0, inner1, 3868, 2905, 0xbbbb),
+	}
+	span := nano.Span{Ts: 0, Dur: nano.Duration(len(packets))}
+	flow := pcap.NewFlow(inner0, 3868, inner1, 3868)
+	assert.Equal(t, []nano.Ts{0, 1, 2, 3}, searchPackets(t, pcap.NewSCTPSearch(span, flow), packets))
+	assert.Equal(t, []nano.Ts{0, 1, 2}, searchPackets(t, pcap.NewSCTPSearch(span, flow, 0xaaaa, 0xbbbb), packets))
+	assert.Nil(t, searchPackets(t, pcap.NewTCPSearch(span, flow), packets))
+}
+
+func TestSearchICMP(t *testing.T) {
+	icmp := func(src, dst net.IP, typ, code uint8, id uint16) []byte {
+		return serialize(t, ether(layers.EthernetTypeIPv4), ipv4(layers.IPProtocolICMPv4, src, dst),
+			&layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(typ, code), Id: id})
+	}
+	icmp6 := func(src, dst net.IP, typ uint8, id uint16) []byte {
+		return serialize(t, ether(layers.EthernetTypeIPv6),
+			&layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolICMPv6, SrcIP: src, DstIP: dst},
+			&layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(typ, 0)},
+			&layers.ICMPv6Echo{Identifier: id})
+	}
+	v6src, v6dst := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")
+	packets := [][]byte{
+		icmp(inner0, inner1, layers.ICMPv4TypeEchoRequest, 0, 1),
+		icmp(inner1, inner0, layers.ICMPv4TypeEchoReply, 0, 1),
+		icmp(inner0, inner1, layers.ICMPv4TypeEchoRequest, 0, 2),
+		icmp(inner1, inner0, layers.ICMPv4TypeDestinationUnreachable, layers.ICMPv4CodeHost, 0),
+		icmp(inner1, inner0, layers.ICMPv4TypeDestinationUnreachable, layers.ICMPv4CodePort, 0),
+		icmp6(v6src, v6dst, layers.ICMPv6TypeEchoRequest, 5),
+		icmp6(v6dst, v6src, layers.ICMPv6TypeEchoReply, 5),
+		icmp6(v6src, v6dst, layers.ICMPv6TypeEchoRequest, 6),
+	}
+	span := nano.Span{Ts: 0, Dur: nano.Duration(len(packets))}
+	search := pcap.NewICMPSearch(span, inner0, inner1)
+	assert.Equal(t, []nano.Ts{0, 1, 2, 3, 4}, searchPackets(t, search, packets))
+	assert.Equal(t, []nano.Ts{0, 1, 2}, searchPackets(t, search.WithICMPType(layers.ICMPv4TypeEchoRequest, 0), packets))
+	assert.Equal(t, []nano.Ts{0, 1, 2}, searchPackets(t, search.WithICMPType(layers.ICMPv4TypeEchoReply, 0), packets))
+	assert.Equal(t, []nano.Ts{0, 1}, searchPackets(t, search.WithICMPType(layers.ICMPv4TypeEchoRequest, 0).WithICMPEchoID(1), packets))
+	assert.Equal(t, []nano.Ts{4}, searchPackets(t, search.WithICMPType(layers.ICMPv4TypeDestinationUnreachable, layers.ICMPv4CodePort), packets))
+	assert.Nil(t, searchPackets(t, search.WithICMPType(layers.ICMPv4TypeTimeExceeded, 0), packets))
+	search6 := pcap.NewICMPSearch(span, v6dst, v6src)
+	assert.Equal(t, []nano.Ts{5, 6}, searchPackets(t, search6.WithICMPType(layers.ICMPv6TypeEchoRequest, 0).WithICMPEchoID(5), packets))
+	assert.Equal(t, []nano.Ts{7}, searchPackets(t, search6.WithICMPEchoID(6), packets))
+}
+
+func TestSearchUnion(t *testing.T) {
+	tcp := func(src, dst net.IP, sport, dport layers.TCPPort) []byte {
+		return serialize(t, ether(layers.EthernetTypeIPv4), ipv4(layers.IPProtocolTCP, src, dst),
+			&layers.TCP{SrcPort: sport, DstPort: dport, ACK: true})
+	}
+	packets := [][]byte{
+		tcp(inner0, inner1, 1234, 80),
+		tcp(inner0, outer1, 1234, 80),
+		tcp(inner1, inner0, 80, 1234),
+		tcp(outer1, inner0, 80, 1234),
+	}
+	flow0 := pcap.NewTCPSearch(nano.Span{Ts: 0, Dur: 1}, pcap.NewFlow(inner0, 1234, inner1, 80))
+	flow1 := pcap.NewTCPSearch(nano.Span{Ts: 1, Dur: 3}, pcap.NewFlow(inner0, 1234, outer1, 80))
+	assert.Equal(t, []nano.Ts{0, 1, 3}, searchPackets(t, pcap.Union(flow0, flow1), packets))
+	// A packet matched by several searches is written once.
+	assert.Equal(t, []nano.Ts{0, 1, 3}, searchPackets(t, pcap.Union(flow0, flow1, flow1), packets))
+	assert.Equal(t, nano.Span{Ts: 0, Dur: 4}, pcap.Union(flow0, flow1).Span())
+	assert.Nil(t, searchPackets(t, pcap.Union(flow0, flow1).WithFilter(func(gopacket.Packet) bool { return false }), packets))
+}
+
+func TestSearchDropsEmptySections(t *testing.T) {
+	packets := tunnelPackets(t)
+	var buf bytes.Buffer
+	w := pcapio.NewNgWriter(&buf)
+	// Only the first and last of three sections hold a packet in span.
+	for i, ts := range []nano.Ts{0, 10, 1} {
+		require.NoError(t, w.WriteSection(pcapio.NgSectionInfo{}))
+		require.NoError(t, w.WriteInterface(pcapio.NgInterface{LinkType: layers.LinkTypeEthernet, TimestampResolution: 9}))
+		p := pcapio.Packet{Ts: ts, LinkType: layers.LinkTypeEthernet, Length: len(packets[i]), Data: packets[i]}
+		require.NoError(t, w.WritePacket(p))
+	}
+	r, err := pcapio.NewReader(&buf)
+	require.NoError(t, err)
+	var out bytes.Buffer
+	require.NoError(t, pcap.NewRangeSearch(nano.Span{Ts: 0, Dur: 2}).Run(context.Background(), &out, r))
+	r, err = pcapio.NewReader(&out)
+	require.NoError(t, err)
+	var types []pcapio.BlockType
+	for {
+		block, typ, err := r.Read()
+		if err != io.EOF {
+			require.NoError(t, err)
+		}
+		if block == nil {
+			break
+		}
+		types = append(types, typ)
+	}
+	section := []pcapio.BlockType{pcapio.TypeSection, pcapio.TypeInterface, pcapio.TypePacket}
+	assert.Equal(t, append(section, section...), types)
+}
+
+func TestSearchDedup(t *testing.T) {
+	packets := tunnelPackets(t)
+	// Each packet is captured twice, the copies two apart.
+	dups := [][]byte{packets[0], packets[1], packets[0], packets[1], packets[2], packets[2]}
+	span := nano.Span{Ts: 0, Dur: nano.Duration(len(dups))}
+	search := pcap.NewTCPSearch(span, pcap.NewFlow(inner0, 1234, inner1, 80))
+	assert.Equal(t, []nano.Ts{0, 1, 2, 3, 4, 5}, searchPackets(t, search, dups))
+	assert.Equal(t, []nano.Ts{0, 1, 4}, searchPackets(t, search.WithDedup(2), dups))
+	assert.Equal(t, []nano.Ts{0, 1, 2, 3, 4}, searchPackets(t, search.WithDedup(1), dups))
+}
@@ -2,46 +2,127 @@ package pcap
 
 import (
 	"io"
+	"sort"
 
+	"github.com/brimdata/brimcap/decompress"
+	"github.com/brimdata/brimcap/pcap/pcapio"
 	"github.com/brimdata/brimcap/ranger"
 	"github.com/brimdata/brimcap/slicer"
 	"github.com/brimdata/zed/pkg/nano"
 )
 
-func NewSlicer(seeker io.ReadSeeker, index Index, span nano.Span) (*slicer.Reader, error) {
-	slices, err := GenerateSlices(index, span)
+// NewSlicer returns a slicer.Reader over the regions of the pcap read from
+// seeker that search needs according to index.  If the pcap is compressed,
+// the regions are read from the decompressed stream.
+func NewSlicer(seeker io.ReadSeeker, index Index, search Search) (*slicer.Reader, error) {
+	slices, err := GenerateSlices(index, search)
 	if err != nil {
 		return nil, err
 	}
 	if len(slices) == 0 {
 		return nil, nil
 	}
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	format, err := sniff(seeker)
+	if err != nil {
+		return nil, err
+	}
+	if format != decompress.None {
+		seeker = decompress.NewSeeker(seeker, format, index.checkpoints())
+	}
 	return slicer.NewReader(seeker, slices)
 }
 
-// GenerateSlices takes an index and time span and generates a list of
+// sniff returns the compression format of the pcap read from r.
+func sniff(r io.Reader) (decompress.Format, error) {
+	magic := make([]byte, 6)
+	n, err := io.ReadFull(r, magic)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return decompress.None, err
+	}
+	return decompress.Sniff(magic[:n]), nil
+}
+
+// NewReaderAt returns a pcapio.Reader over the blocks of the pcap held in r,
+// which is size bytes long, that search needs according to index.
+// Blocks of an uncompressed pcap are read directly from r (see
+// pcapio.NewReaderAt) while a compressed pcap is sliced as by NewSlicer.  If no
+// blocks are needed, NewReaderAt returns a nil Reader.
+func NewReaderAt(r io.ReaderAt, size int64, index Index, search Search, warner pcapio.Warner) (pcapio.Reader, error) {
+	format, err := sniff(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	if format != decompress.None {
+		slicer, err := NewSlicer(io.NewSectionReader(r, 0, size), index, search)
+		if err != nil || slicer == nil {
+			return nil, err
+		}
+		return pcapio.NewReaderWithWarnings(slicer, warner)
+	}
+	slices, err := GenerateSlices(index, search)
+	if err != nil || len(slices) == 0 {
+		return nil, err
+	}
+	return pcapio.NewReaderAt(r, size, slices, warner)
+}
+
+// GenerateSlices takes an index and a search and generates a list of
 // slices that should be read to enumerate the relevant chunks of an
 // underlying pcap file.  Extra packets may appear in the resulting stream
-// but all packets that fall within the time range will be produced, i.e.,
-// another layering of time filtering should be applied to resulting packets.
-func GenerateSlices(index Index, span nano.Span) ([]slicer.Slice, error) {
+// but all packets that fall within the time ranges of search (or, for a
+// union, of each of its searches) will be produced, i.e., another layering
+// of filtering should be applied to resulting packets.  If the index has
+// flow summaries (see IndexOptions), the regions of a section that can't
+// hold the flow searched for are skipped.  The packet slices of a section
+// that overlap or abut are merged so that no packet is read twice.
+func GenerateSlices(index Index, search Search) ([]slicer.Slice, error) {
+	targets := search.targets()
 	var slices []slicer.Slice
 	for _, section := range index {
-		pslice, err := FindPacketSlice(section.Index, span)
-		if err == ErrNoPcapsFound {
-			continue
+		var pslices []slicer.Slice
+		for _, target := range targets {
+			pslice, err := FindPacketSlice(section.Index, target.span)
+			if err == ErrNoPcapsFound {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			pslices = append(pslices, pruneSlice(pslice, section.Flows, target.keys)...)
 		}
-		if err != nil {
-			return nil, err
+		if len(pslices) == 0 {
+			continue
 		}
 		for _, slice := range section.Blocks {
 			slices = append(slices, slice)
 		}
-		slices = append(slices, pslice)
+		slices = append(slices, mergeSlices(pslices)...)
 	}
 	return slices, nil
 }
 
+// mergeSlices sorts slices by offset and merges those that overlap or abut.
+func mergeSlices(slices []slicer.Slice) []slicer.Slice {
+	sort.Slice(slices, func(i, j int) bool {
+		return slices[i].Offset < slices[j].Offset
+	})
+	merged := slices[:1]
+	for _, slice := range slices[1:] {
+		last := &merged[len(merged)-1]
+		if slice.Offset > last.Offset+last.Length {
+			merged = append(merged, slice)
+			continue
+		}
+		if end := slice.Offset + slice.Length; end > last.Offset+last.Length {
+			last.Length = end - last.Offset
+		}
+	}
+	return merged
+}
+
 func FindPacketSlice(e ranger.Envelope, span nano.Span) (slicer.Slice, error) {
 	if len(e) == 0 {
 		return slicer.Slice{}, ErrNoPcapsFound
@@ -0,0 +1,30 @@
+package pcap_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/brimdata/brimcap/pcap"
+	"github.com/brimdata/brimcap/ranger"
+	"github.com/brimdata/brimcap/slicer"
+	"github.com/brimdata/zed/pkg/nano"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateSlicesMerge(t *testing.T) {
+	// The packets at offsets 100, 200, 300, and 400 have timestamps 10,
+	// 20, 30, and 40.
+	points := []ranger.Point{{X: 100, Y: 10}, {X: 200, Y: 20}, {X: 300, Y: 30}, {X: 400, Y: 40}}
+	index := pcap.Index{{
+		Blocks: []slicer.Slice{{Offset: 0, Length: 100}},
+		Index:  ranger.NewEnvelope(points, len(points)),
+	}}
+	span := func(ts, end nano.Ts) pcap.Search { return pcap.NewRangeSearch(nano.NewSpanTs(ts, end)) }
+	slices, err := pcap.GenerateSlices(index, pcap.Union(span(40, 41), span(10, 11), span(20, 21)))
+	require.NoError(t, err)
+	assert.Equal(t, []slicer.Slice{{Offset: 0, Length: 100}, {Offset: 100, Length: 200}, {Offset: 400, Length: math.MaxUint64 - 400}}, slices)
+	slices, err = pcap.GenerateSlices(index, span(50, 60))
+	require.NoError(t, err)
+	assert.Nil(t, slices)
+}
@@ -1,27 +1,32 @@
 package brimcap
 
 import (
+	"bufio"
 	"context"
-	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"hash"
 	"io"
+	"io/fs"
 	"net"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/brimdata/brimcap/mmap"
 	"github.com/brimdata/brimcap/pcap"
+	"github.com/brimdata/brimcap/pcap/bpf"
 	"github.com/brimdata/brimcap/pcap/pcapio"
 	"github.com/brimdata/brimcap/ztail"
 	"github.com/brimdata/zed/pkg/nano"
 	"golang.org/x/sync/errgroup"
 )
 
-const indexPrefix = "idx-"
+const (
+	indexPrefix = "idx-"
+	indexSuffix = ".idx"
+)
 
 type Search struct {
 	Span    nano.Span
@@ -30,12 +35,60 @@ type Search struct {
 	SrcPort uint16
 	DstIP   net.IP
 	DstPort uint16
+	// Direction, if known, restricts the search to pcap-ng packets
+	// captured in that direction.
+	Direction pcapio.NgDirection
+	// Filter, if set, is a packet filter expression (see package bpf)
+	// further restricting the packets matched.
+	Filter string
+	// Tunnel selects the IP headers of tunneled packets that the
+	// connection is matched against.
+	Tunnel pcap.TunnelLayer
+	// Defrag, if true, matches all fragments of the matching IP
+	// datagrams.
+	Defrag bool
+	// CommunityID, if set, restricts the search to the flow with this
+	// Community ID computed using CommunityIDSeed.  If Proto is empty,
+	// the flow is identified by CommunityID alone.
+	CommunityID     string
+	CommunityIDSeed uint16
+	// SCTPTags, if set, restricts an SCTP search to packets with one of
+	// these verification tags (or a zero tag, as in INIT packets).
+	SCTPTags []uint32
+	// ICMPType and ICMPCode, if ICMPType is set, restrict an ICMP search
+	// to the exchange keyed by that type and code in Zeek's conn log
+	// (see pcap.Search.WithICMPType).  ICMPEchoID, if set, restricts it
+	// to echo messages with that identifier.
+	ICMPType   *uint8
+	ICMPCode   uint8
+	ICMPEchoID *uint16
+	// Dedup, if positive, drops packets that duplicate a packet found
+	// within this window, e.g., the same packet captured by overlapping
+	// sensors and held in different pcaps.
+	Dedup nano.Duration
+	// Mmap, if true, reads the pcaps through memory mappings (see package
+	// mmap) rather than with ReadAt, saving copies and system calls.  A
+	// pcap truncated while mapped may crash the process on some
+	// platforms, so only pcaps that were complete when indexed and
+	// haven't changed since are mapped.  SearchAll and SearchEach map the
+	// pcaps only if all of their requests set Mmap.
+	Mmap bool
 }
 
 type Root string
 
 // AddPcap adds the pcap path to the brimcap root.
 func (r Root) AddPcap(pcappath string, limit int, warner ztail.Warner) (nano.Span, error) {
+	return r.AddPcapWithOptions(pcappath, pcap.IndexOptions{Size: limit, Warner: warner})
+}
+
+// AddPcapWithOptions is like AddPcap but indexes the pcap with opts, e.g.,
+// to summarize its flows so that searches skip it unless it may hold the
+// flow searched for.  If the root already holds an index of the pcap and the
+// pcap has only been appended to since, just the packets appended are
+// indexed (see pcap.UpdateIndexFile).  The new entry replaces any others of
+// the pcap.
+func (r Root) AddPcapWithOptions(pcappath string, opts pcap.IndexOptions) (nano.Span, error) {
 	f, err := os.Open(pcappath)
 	if err != nil {
 		return nano.Span{}, err
@@ -44,98 +97,283 @@ func (r Root) AddPcap(pcappath string, limit int, warner ztail.Warner) (nano.Spa
 	if pcappath, err = filepath.Abs(pcappath); err != nil {
 		return nano.Span{}, err
 	}
-	hash := sha256.New()
-	reader := io.TeeReader(f, hash)
-	index, err := pcap.CreateIndexWithWarnings(reader, limit, warner)
+	pcappath = filepath.Clean(pcappath)
+	files, err := r.Pcaps()
 	if err != nil {
 		return nano.Span{}, err
 	}
-	b, err := json.Marshal(File{PcapPath: filepath.Clean(pcappath), Index: index})
+	var prev []File
+	for _, file := range files {
+		if file.PcapPath == pcappath {
+			prev = append(prev, file)
+		}
+	}
+	var header pcap.IndexHeader
+	var index pcap.Index
+	if len(prev) > 0 {
+		header, index, err = pcap.UpdateIndexFile(f, pcappath, prev[0].Header, prev[0].Index, opts)
+	} else {
+		header, index, err = pcap.CreateIndexFile(f, pcappath, opts)
+	}
 	if err != nil {
 		return nano.Span{}, err
 	}
-	return index.Span(), os.WriteFile(r.Filepath(hash), b, 0600)
+	path := r.Filepath(header.PcapHash)
+	if err := r.writeEntry(path, header, index); err != nil {
+		return nano.Span{}, err
+	}
+	for _, file := range prev {
+		if file.path != path {
+			if err := os.Remove(file.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nano.Span{}, err
+			}
+		}
+	}
+	return index.Span(), nil
 }
 
-func (r Root) Filepath(hash hash.Hash) string {
-	name := indexPrefix + base64.RawURLEncoding.EncodeToString(hash.Sum(nil)) + ".json"
+// writeEntry writes an entry to path by renaming a temporary file so that
+// concurrent searches never read a partial entry.
+func (r Root) writeEntry(path string, header pcap.IndexHeader, index pcap.Index) error {
+	f, err := os.CreateTemp(string(r), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	err = pcap.WriteIndexFile(f, header, index)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Filepath returns the path of the entry of the pcap whose contents have the
+// SHA-256 hash sum.
+func (r Root) Filepath(sum []byte) string {
+	name := indexPrefix + base64.RawURLEncoding.EncodeToString(sum) + indexSuffix
 	return r.join(name)
 }
 
 func (r Root) Search(ctx context.Context, req Search, w io.Writer) error {
-	var search pcap.Search
+	search, err := req.pcapSearch()
+	if err != nil {
+		return err
+	}
+	return r.search(ctx, search, req.Mmap, w)
+}
+
+// SearchAll writes the packets of all of reqs to w, reading the regions of
+// each pcap that the requests need just once.
+func (r Root) SearchAll(ctx context.Context, reqs []Search, w io.Writer) error {
+	searches, err := pcapSearches(reqs)
+	if err != nil {
+		return err
+	}
+	return r.search(ctx, pcap.Union(searches...), mmapAll(reqs), w)
+}
+
+// SearchEach is like SearchAll but writes the packets of reqs[i] to the
+// writer returned by create(i), which is only called if there are any.  The
+// pcaps are searched once for all of reqs and the packets found are then
+// routed to the requests from a temporary file.
+func (r Root) SearchEach(ctx context.Context, reqs []Search, create func(int) (io.WriteCloser, error)) error {
+	searches, err := pcapSearches(reqs)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp("", "brimcap-search-*.pcapng")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := r.search(ctx, pcap.Union(searches...), mmapAll(reqs), tmp); err != nil {
+		return err
+	}
+	for i, search := range searches {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		pr, err := pcapio.NewReader(bufio.NewReader(tmp))
+		if err != nil {
+			return err
+		}
+		sr, err := search.Reader(ctx, pr)
+		if err != nil {
+			if errors.Is(err, pcap.ErrNoPcapsFound) {
+				continue
+			}
+			return err
+		}
+		out, err := create(i)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, sr)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mmapAll(reqs []Search) bool {
+	for _, req := range reqs {
+		if !req.Mmap {
+			return false
+		}
+	}
+	return true
+}
+
+func pcapSearches(reqs []Search) ([]pcap.Search, error) {
+	if len(reqs) == 0 {
+		return nil, pcap.ErrNoPcapsFound
+	}
+	var searches []pcap.Search
+	for _, req := range reqs {
+		search, err := req.pcapSearch()
+		if err != nil {
+			return nil, err
+		}
+		searches = append(searches, search)
+	}
+	return searches, nil
+}
+
+func (req Search) pcapSearch() (pcap.Search, error) {
+	if req.Proto == "" && req.CommunityID == "" {
+		return pcap.Search{}, errors.New("proto type or community ID must be set")
+	}
 	// We add two microseconds to the end of the span as fudge to deal with the
 	// fact that zeek truncates timestamps to microseconds where pcap-ng
 	// timestamps have nanosecond precision.  We need two microseconds because
 	// both the base timestamp of a conn record as well as the duration time
 	// can be truncated downward.
-	span := nano.NewSpanTs(req.Span.Ts, req.Span.End()+2000)
+	req.Span = nano.NewSpanTs(req.Span.Ts, req.Span.End()+2000)
+	return req.PcapSearch()
+}
+
+// PcapSearch returns the pcap.Search for the packets that req matches within
+// req.Span exactly.  If neither Proto nor CommunityID is set, every packet
+// within the span that the options of req allow is matched.
+func (req Search) PcapSearch() (pcap.Search, error) {
+	var search pcap.Search
+	span := req.Span
 	flow := pcap.NewFlow(req.SrcIP, int(req.SrcPort), req.DstIP, int(req.DstPort))
 	switch req.Proto {
 	case "tcp":
 		search = pcap.NewTCPSearch(span, flow)
 	case "udp":
 		search = pcap.NewUDPSearch(span, flow)
+	case "sctp":
+		search = pcap.NewSCTPSearch(span, flow, req.SCTPTags...)
 	case "icmp":
 		search = pcap.NewICMPSearch(span, req.SrcIP, req.DstIP)
+		if req.ICMPType != nil {
+			search = search.WithICMPType(*req.ICMPType, req.ICMPCode)
+		}
+		if req.ICMPEchoID != nil {
+			search = search.WithICMPEchoID(*req.ICMPEchoID)
+		}
+	case "":
+		search = pcap.NewRangeSearch(span)
 	default:
-		return fmt.Errorf("unsupported proto type: %s", req.Proto)
+		return search, fmt.Errorf("unsupported proto type: %s", req.Proto)
 	}
+	if req.CommunityID != "" {
+		id, err := pcap.ParseCommunityID(req.CommunityID)
+		if err != nil {
+			return search, err
+		}
+		search = search.WithCommunityID(id, req.CommunityIDSeed)
+	}
+	search = search.WithDirection(req.Direction).WithTunnel(req.Tunnel).WithDefrag(req.Defrag).WithDedup(req.Dedup)
+	if req.Filter != "" {
+		filter, err := bpf.Compile(req.Filter)
+		if err != nil {
+			return search, err
+		}
+		search = search.WithFilter(filter)
+	}
+	return search, nil
+}
 
+// search searches the pcaps for the packets matching search, reading only
+// the regions of each that search needs, and writes them to w.  If mmap is
+// true, the pcaps that can be are memory mapped (see File.PcapReader).
+func (r Root) search(ctx context.Context, search pcap.Search, mmap bool, w io.Writer) error {
 	files, err := r.Pcaps()
 	if err != nil {
 		return err
 	}
 
+	// Each file is searched in parallel for its first match and the
+	// matches are then merged in timestamp order.  The readers are kept in
+	// the order of files so that the output is deterministic.
 	group, ctx := errgroup.WithContext(ctx)
-	readers := make(chan *pcap.SearchReader, len(files))
-	closers := make(chan io.Closer, len(files))
-	for _, file := range files {
-		file := file
+	readers := make([]*pcap.SearchReader, len(files))
+	closers := make([]io.Closer, len(files))
+	defer func() {
+		for _, closer := range closers {
+			if closer != nil {
+				closer.Close()
+			}
+		}
+	}()
+	for i, file := range files {
+		i, file := i, file
 		group.Go(func() error {
-			pr, closer, err := file.PcapReader(span)
+			pr, closer, err := file.PcapReader(search, mmap)
 			if err != nil || pr == nil {
 				return err
 			}
-
+			closers[i] = closer
 			r, err := search.Reader(ctx, pr)
 			if err != nil {
-				closer.Close()
 				if errors.Is(err, pcap.ErrNoPcapsFound) {
 					return nil
 				}
 				return err
 			}
-
-			readers <- r
-			closers <- closer
+			readers[i] = r
 			return nil
 		})
 	}
-	go func() {
-		group.Wait()
-		close(readers)
-		close(closers)
-	}()
-
-	defer func() {
-		for closer := range closers {
-			closer.Close()
+	if err := group.Wait(); err != nil {
+		return err
+	}
+	var matches []pcapio.Reader
+	for _, r := range readers {
+		if r == nil {
+			continue
 		}
-	}()
-
-	var count int
-	for pr := range readers {
-		count++
-		if _, err := io.Copy(w, pr); err != nil {
+		pr, err := pcapio.NewReader(r)
+		if err != nil {
 			return err
 		}
+		matches = append(matches, pr)
 	}
-	err = group.Wait()
-	if err == nil && count == 0 {
+	if len(matches) == 0 {
 		return pcap.ErrNoPcapsFound
 	}
-	return err
+	out := bufio.NewWriter(w)
+	// Each file's matches are deduplicated as they are found while
+	// duplicates held in different files are dropped as they are merged.
+	mw := pcapio.NewMergeWriter(out, matches...)
+	if window := search.Dedup(); window > 0 {
+		mw = pcapio.NewDedupWriter(mw, window)
+	}
+	if err := pcapio.Merge(mw, matches...); err != nil {
+		return err
+	}
+	return out.Flush()
 }
 
 // DeletePcap removes all files associated with the pcap path (if they exist).
@@ -158,40 +396,110 @@ func (r Root) DeletePcap(pcappath string) (err error) {
 	return nil
 }
 
+// File is an entry of a root: the index of a pcap.  Entries written by older
+// brimcaps are in JSON, lacking a Header to check the index against the pcap.
 type File struct {
-	Index    pcap.Index `json:"index"`
-	PcapPath string     `json:"pcap_path"`
+	Header   pcap.IndexHeader `json:"-"`
+	Index    pcap.Index       `json:"index"`
+	PcapPath string           `json:"pcap_path"`
 
 	path string
 }
 
-func (f File) PcapReader(span nano.Span) (pcapio.Reader, io.Closer, error) {
-	file, err := os.Open(f.PcapPath)
+// Delete removes the entry from its root.
+func (f File) Delete() error {
+	return os.Remove(f.path)
+}
+
+// Check returns an error if the index can't be used for the pcap as it is
+// now, i.e., if it wraps pcap.ErrStaleIndex or fs.ErrNotExist.  Like brimcap
+// slice, it accepts the index of an entry written by an older brimcap, which
+// has no header to check (see pcap.IndexHeader.Check).
+func (f File) Check() error {
+	info, err := os.Stat(f.PcapPath)
+	if err != nil {
+		return err
+	}
+	return f.check(info)
+}
+
+func (f File) check(info fs.FileInfo) error {
+	return f.Header.Check(f.PcapPath, info)
+}
+
+// PcapReader returns a pcapio.Reader over the packets of the file's pcap
+// that search may match.  Its blocks are read with ReadAt or, if useMmap is
+// true and the pcap is settled (see Settled), from a memory mapping of the
+// pcap (see package mmap) without seeks or copies.  The returned io.Closer
+// must be closed once the Reader and its blocks are no longer needed.  If the
+// index shows that search can't match any packets, the pcap isn't opened and
+// the Reader is nil.  If the index is stale (see Check), the Reader covers the
+// whole pcap.  If the pcap is missing, the Reader is nil.
+func (f File) PcapReader(search pcap.Search, useMmap bool) (pcapio.Reader, io.Closer, error) {
+	info, err := os.Stat(f.PcapPath)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			os.Remove(f.path)
 			err = nil
 		}
 		return nil, nil, err
 	}
-
-	slicer, err := pcap.NewSlicer(file, f.Index, span)
+	stale := f.check(info) != nil
+	if !stale {
+		if slices, err := pcap.GenerateSlices(f.Index, search); err != nil || len(slices) == 0 {
+			return nil, nil, err
+		}
+	}
+	file, size, err := f.open(useMmap && !stale && f.Settled())
 	if err != nil {
-		file.Close()
 		return nil, nil, err
 	}
-	if slicer == nil {
+	var pcapReader pcapio.Reader
+	if stale {
+		pcapReader, err = pcapio.NewReader(io.NewSectionReader(file, 0, size))
+	} else {
+		size = pcap.IndexedSize(f.Header, size)
+		pcapReader, err = pcap.NewReaderAt(file, size, f.Index, search, nil)
+	}
+	if err != nil || pcapReader == nil {
 		file.Close()
-		return nil, nil, nil
+		return nil, nil, err
 	}
+	return pcapReader, file, nil
+}
+
+// Settled returns true if the file's pcap was complete when indexed, i.e.,
+// it ended with a whole block, as opposed to a capture still being written
+// (see pcap.UpdateIndexFile).  The pcap of an entry written by an older
+// brimcap is never settled since its index has no header to tell.
+func (f File) Settled() bool {
+	return f.Header.Version != 0 && f.Header.PcapModTime != 0 && f.Header.Indexed == uint64(f.Header.PcapSize)
+}
+
+type readerAtCloser interface {
+	io.ReaderAt
+	io.Closer
+}
 
-	pcapReader, err := pcapio.NewReader(slicer)
+// open opens the file's pcap, memory mapping it if useMmap is true, and
+// returns it along with its size.
+func (f File) open(useMmap bool) (readerAtCloser, int64, error) {
+	if useMmap {
+		file, err := mmap.Open(f.PcapPath)
+		if err != nil {
+			return nil, 0, err
+		}
+		return file, file.Size(), nil
+	}
+	file, err := os.Open(f.PcapPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
 	if err != nil {
 		file.Close()
-		return nil, nil, err
+		return nil, 0, err
 	}
-
-	return pcapReader, file, nil
+	return file, info.Size(), nil
 }
 
 func (r Root) Pcaps() ([]File, error) {
@@ -206,14 +514,24 @@ func (r Root) Pcaps() ([]File, error) {
 			path := r.join(entry.Name())
 			b, err := os.ReadFile(path)
 			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					// The entry was replaced since the
+					// directory was read.
+					continue
+				}
 				return nil, err
 			}
 
 			file := File{path: path}
-			if err := json.Unmarshal(b, &file); err != nil {
+			if pcap.IsIndexFile(b) {
+				file.Header, file.Index, err = pcap.ReadIndexFile(b)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", path, err)
+				}
+				file.PcapPath = file.Header.PcapPath
+			} else if err := json.Unmarshal(b, &file); err != nil {
 				return nil, err
 			}
-
 			files = append(files, file)
 		}
 	}
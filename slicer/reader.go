@@ -24,6 +24,11 @@ func NewReader(seeker io.ReadSeeker, slices []Slice) (*Reader, error) {
 }
 
 func (r *Reader) next() error {
+	// Skip empty slices, e.g., for the empty section header of a
+	// capture format without a file header.
+	for len(r.slices) > 0 && r.slices[0].Length == 0 {
+		r.slices = r.slices[1:]
+	}
 	if len(r.slices) == 0 {
 		r.eof = true
 		return nil
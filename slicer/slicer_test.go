@@ -13,6 +13,7 @@ func TestSlicer(t *testing.T) {
 	in := []byte("abcdefghijklmnopqrstuvwxyz")
 	slices := []slicer.Slice{
 		{0, 2},
+		{5, 0},
 		{0, 26},
 		{3, 4},
 		{25, 1},
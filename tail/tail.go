@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 
@@ -12,6 +13,10 @@ import (
 
 var ErrIsDir = errors.New("path is a directory")
 
+// ErrDirRemoved is sent by a Dir whose directory was removed or renamed
+// before it stops.
+var ErrDirRemoved = errors.New("directory was removed")
+
 type File struct {
 	ctx     context.Context
 	f       *os.File
@@ -139,7 +144,7 @@ func TailDir(dir string, globs ...string) (*Dir, error) {
 	}
 	w := &Dir{
 		Events:  make(chan FileEvent),
-		dir:     dir,
+		dir:     filepath.Clean(dir),
 		globs:   globs,
 		watched: make(map[string]struct{}),
 		watcher: watcher,
@@ -166,6 +171,8 @@ func (d *Dir) run() error {
 	}
 	for ev := range d.watcher.Events {
 		switch {
+		case ev.Op&(fsnotify.Rename|fsnotify.Remove) != 0 && filepath.Clean(ev.Name) == d.dir:
+			return ErrDirRemoved
 		case ev.Op&fsnotify.Create == fsnotify.Create:
 			if err := d.addFile(ev.Name); err != nil {
 				return err
@@ -213,6 +220,9 @@ func (d *Dir) removeFile(name string) error {
 
 func (d *Dir) poll() error {
 	infos, err := os.ReadDir(d.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrDirRemoved
+	}
 	if err != nil {
 		return err
 	}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -80,3 +81,16 @@ line #9
 	require.NoError(t, err)
 	assert.Equal(t, expected, buf.String())
 }
+
+func TestTailDirRemoved(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dir")
+	require.NoError(t, os.Mkdir(dir, 0755))
+	d, err := TailDir(dir)
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(dir))
+	var errs []error
+	for ev := range d.Events {
+		errs = append(errs, ev.Err)
+	}
+	assert.Equal(t, []error{ErrDirRemoved}, errs)
+}
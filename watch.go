@@ -0,0 +1,224 @@
+package brimcap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brimdata/brimcap/pcap"
+	"github.com/brimdata/brimcap/tail"
+	"github.com/brimdata/brimcap/ztail"
+)
+
+// Watch keeps the root up to date with the pcaps in the directories given by
+// patterns until ctx is done or it stops watching every directory.  A pattern
+// is a directory, whose files are all watched, or a directory followed by a
+// glob matching the names of the files watched, e.g., "/data/*.pcap".  A file
+// is indexed with opts (see AddPcapWithOptions) once its size and modification
+// time haven't changed for settle, and again each time it settles after
+// growing, and its entry is deleted when it's removed or renamed.  Entries of
+// files matched by patterns that were removed before Watch was called are
+// deleted when it starts.  Errors indexing or deleting a file and errors
+// watching a directory, which is then no longer watched, are passed to warner.
+func (r Root) Watch(ctx context.Context, patterns []string, opts pcap.IndexOptions, settle time.Duration, warner ztail.Warner) error {
+	if settle <= 0 {
+		return errors.New("settle time must be positive")
+	}
+	// The events of all directories are sent to events, each followed by
+	// a stopped event once the directory stops.
+	events := make(chan watchEvent)
+	var dirs []*tail.Dir
+	var running int
+	defer func() {
+		// Stop the directories and drain their events so their
+		// goroutines exit.
+		for _, d := range dirs {
+			d.Stop()
+		}
+		for running > 0 {
+			if ev := <-events; ev.stopped {
+				running--
+			}
+		}
+	}()
+	for _, pattern := range patterns {
+		dir, globs := splitPattern(pattern)
+		d, err := tail.TailDir(dir, globs...)
+		if err != nil {
+			return err
+		}
+		dirs = append(dirs, d)
+		running++
+		go func() {
+			for ev := range d.Events {
+				events <- watchEvent{FileEvent: ev, dir: dir}
+			}
+			events <- watchEvent{dir: dir, stopped: true}
+		}()
+	}
+	if err := r.prune(patterns, warner); err != nil {
+		return err
+	}
+	files := make(map[string]*watchedFile)
+	ticker := time.NewTicker(settle / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev := <-events:
+			switch {
+			case ev.stopped:
+				running--
+				warn(warner, fmt.Sprintf("%s: stopped watching directory", ev.dir))
+				if running == 0 {
+					return nil
+				}
+			case ev.Err != nil:
+				warn(warner, fmt.Sprintf("%s: %s", ev.dir, ev.Err))
+			case ev.Op.Exists():
+				if files[ev.Name] == nil {
+					files[ev.Name] = &watchedFile{}
+				}
+			default:
+				delete(files, ev.Name)
+				if err := r.DeletePcap(ev.Name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					warn(warner, fmt.Sprintf("%s: %s", ev.Name, err))
+				}
+			}
+		case now := <-ticker.C:
+			for path, file := range files {
+				if !file.settled(path, now, settle) {
+					continue
+				}
+				w := &pathWarner{path: path, warner: warner}
+				o := opts
+				o.Warner = w
+				if _, err := r.AddPcapWithOptions(path, o); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					w.Warn(err.Error())
+				}
+			}
+		}
+	}
+}
+
+// watchEvent is an event of a directory watched by Root.Watch.
+type watchEvent struct {
+	tail.FileEvent
+	dir string
+	// stopped is true if the directory stopped and has no more events.
+	stopped bool
+}
+
+// prune deletes the entries of the pcaps matched by patterns that no longer
+// exist, e.g., because they were removed while the root wasn't watched.
+func (r Root) prune(patterns []string, warner ztail.Warner) error {
+	files, err := r.Pcaps()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		match, err := matchPatterns(patterns, file.PcapPath)
+		if err != nil {
+			return err
+		}
+		if !match {
+			continue
+		}
+		if _, err := os.Stat(file.PcapPath); !errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err := file.Delete(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			warn(warner, fmt.Sprintf("%s: %s", file.PcapPath, err))
+		}
+	}
+	return nil
+}
+
+// matchPatterns returns true if the absolute path is that of a file watched
+// with patterns.
+func matchPatterns(patterns []string, path string) (bool, error) {
+	for _, pattern := range patterns {
+		dir, globs := splitPattern(pattern)
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return false, err
+		}
+		if filepath.Dir(path) != dir {
+			continue
+		}
+		match := true
+		for _, glob := range globs {
+			if ok, err := filepath.Match(glob, filepath.Base(path)); err != nil || !ok {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// splitPattern splits a pattern of Watch into its directory and globs.
+func splitPattern(pattern string) (string, []string) {
+	dir, base := filepath.Split(pattern)
+	if strings.ContainsAny(base, `*?[\`) {
+		return filepath.Clean(dir), []string{base}
+	}
+	return filepath.Clean(pattern), nil
+}
+
+// watchedFile tracks a file watched by Root.Watch.
+type watchedFile struct {
+	// size and modTime are those of the file when last seen and changed
+	// the time they last changed.
+	size    int64
+	modTime time.Time
+	changed time.Time
+	// indexed is true if the file was indexed (or failed to be) since
+	// last changing.
+	indexed bool
+}
+
+// settled returns true if the file at path needs indexing, i.e., it has
+// changed since it was last indexed and not since settle before now.
+func (f *watchedFile) settled(path string, now time.Time, settle time.Duration) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	if info.Size() != f.size || !info.ModTime().Equal(f.modTime) || f.changed.IsZero() {
+		f.size, f.modTime, f.changed = info.Size(), info.ModTime(), now
+		f.indexed = false
+		return false
+	}
+	if f.indexed || now.Sub(f.changed) < settle {
+		return false
+	}
+	f.indexed = true
+	return true
+}
+
+// pathWarner prefixes the warnings of a pcap with its path.
+type pathWarner struct {
+	path   string
+	warner ztail.Warner
+}
+
+func (p *pathWarner) Warn(msg string) error {
+	return warn(p.warner, fmt.Sprintf("%s: %s", p.path, msg))
+}
+
+func warn(warner ztail.Warner, msg string) error {
+	if warner == nil {
+		return nil
+	}
+	return warner.Warn(msg)
+}